@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/metrics"
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+	"github.com/Khan/clever-repartee/pkg/tripperware"
+	"github.com/Khan/clever-repartee/pkg/version"
+)
+
+// clientFlags configure the HTTP client of every command that calls Clever:
+// metrics, recording and replaying, retries, the circuit breaker and HTTP
+// debugging
+type clientFlags struct {
+	metricsAddr    string
+	metricsPushURL string
+
+	recordPath string
+	replayPath string
+
+	retryPolicy tripperware.RetryPolicy
+
+	breakerPolicy tripperware.BreakerPolicy
+	breakerScope  string
+
+	debugHTTP    bool
+	debugHARPath string
+	debugOptions tripperware.DebugOptions
+	debugRedact  report.PIIFields
+
+	pageSize int
+}
+
+func newClientFlags() *clientFlags {
+	f := &clientFlags{
+		retryPolicy:   tripperware.DefaultRetryPolicy(),
+		breakerPolicy: tripperware.DefaultBreakerPolicy(),
+		debugOptions: tripperware.DebugOptions{
+			MaxBodyBytes: tripperware.DefaultDebugBodyBytes,
+		},
+		debugRedact: report.PIIFields{},
+		pageSize:    rostering.DefaultPageSize,
+	}
+	f.breakerScope = string(f.breakerPolicy.Scope)
+	for _, field := range report.AllPIIFields {
+		f.debugRedact[field] = true
+	}
+	return f
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(
+		&f.metricsAddr,
+		"metrics-addr",
+		"",
+		"Serve Prometheus metrics on this address at /metrics, e.g. :9090",
+	)
+	fs.StringVar(
+		&f.metricsPushURL,
+		"metrics-push",
+		"",
+		"Push Prometheus metrics to this Pushgateway URL when the run ends",
+	)
+	fs.StringVar(
+		&f.recordPath,
+		"record",
+		"",
+		"Record every Clever API request and response to this cassette file",
+	)
+	fs.StringVar(
+		&f.replayPath,
+		"replay",
+		"",
+		"Replay Clever API responses from this cassette file, without network",
+	)
+	fs.IntVar(
+		&f.retryPolicy.MaxAttempts,
+		"retry-attempts",
+		f.retryPolicy.MaxAttempts,
+		"Most times to send each Clever API request, including the first",
+	)
+	fs.DurationVar(
+		&f.retryPolicy.MaxElapsed,
+		"retry-max-elapsed",
+		f.retryPolicy.MaxElapsed,
+		"Longest to keep retrying each Clever API request, 0 for no limit",
+	)
+	fs.Var(
+		(*tripperware.StatusList)(&f.retryPolicy.RetryStatuses),
+		"retry-statuses",
+		"Response statuses to retry: comma separated list, or none",
+	)
+	fs.DurationVar(
+		&f.retryPolicy.BaseDelay,
+		"retry-base-delay",
+		f.retryPolicy.BaseDelay,
+		"Wait before the first retry, doubling for each retry after it",
+	)
+	fs.DurationVar(
+		&f.retryPolicy.MaxDelay,
+		"retry-max-delay",
+		f.retryPolicy.MaxDelay,
+		"Longest wait between retries, 0 for no limit",
+	)
+	fs.StringVar(
+		(*string)(&f.retryPolicy.Jitter),
+		"retry-jitter",
+		string(f.retryPolicy.Jitter),
+		"Randomness added to retry waits: none, full or equal",
+	)
+	fs.StringVar(
+		&f.breakerScope,
+		"breaker",
+		f.breakerScope,
+		"Circuit breaker per host or endpoint, or none",
+	)
+	fs.Float64Var(
+		&f.breakerPolicy.FailureRate,
+		"breaker-failure-rate",
+		f.breakerPolicy.FailureRate,
+		"Share of recent Clever API requests, 0 to 1, that have to fail "+
+			"to open the circuit",
+	)
+	fs.DurationVar(
+		&f.breakerPolicy.OpenFor,
+		"breaker-open-for",
+		f.breakerPolicy.OpenFor,
+		"How long an open circuit fails requests before probing again",
+	)
+	fs.BoolVar(
+		&f.debugHTTP,
+		"debug-http",
+		false,
+		"Log every Clever API request and response in full, with "+
+			"credentials and PII redacted",
+	)
+	fs.IntVar(
+		&f.debugOptions.MaxBodyBytes,
+		"debug-http-body",
+		f.debugOptions.MaxBodyBytes,
+		"Bytes of each body logged by -debug-http, 0 for all",
+	)
+	fs.Var(
+		f.debugRedact,
+		"debug-http-redact",
+		"PII fields redacted by -debug-http and -debug-http-har: comma "+
+			"separated list of "+
+			"name, sis_id, number, email and dob, or all or none",
+	)
+	fs.StringVar(
+		&f.debugHARPath,
+		"debug-http-har",
+		"",
+		"Write every Clever API request and response to this HAR file, "+
+			"redacted as for -debug-http, which it doesn't need",
+	)
+	fs.IntVar(
+		&f.pageSize,
+		"page-size",
+		f.pageSize,
+		"Records to ask Clever for in each list request",
+	)
+}
+
+// validate reports every problem with the flags as one error
+func (f *clientFlags) validate() error {
+	problems := f.problems()
+	if len(problems) == 0 {
+		return nil
+	}
+	messages := make([]string, len(problems))
+	for i, problem := range problems {
+		messages[i] = problem.Error()
+	}
+	return fmt.Errorf("%s", strings.Join(messages, "; "))
+}
+
+// problems are every problem with the flags
+func (f *clientFlags) problems() []error {
+	problems := f.retryPolicy.Validate()
+	if f.breakerScope != "none" {
+		problems = append(problems, f.breaker().Validate()...)
+	}
+	if f.pageSize < 1 || f.pageSize > rostering.MaxPageSize {
+		problems = append(problems, fmt.Errorf(
+			"-page-size must be from 1 to %d",
+			rostering.MaxPageSize,
+		))
+	}
+	if f.recordPath != "" && f.replayPath != "" {
+		problems = append(
+			problems,
+			fmt.Errorf("-record and -replay can't be used together"),
+		)
+	}
+	return problems
+}
+
+// breaker is the circuit breaker policy with the -breaker scope. The flags
+// are left as they are, since runs in one process share them.
+func (f *clientFlags) breaker() tripperware.BreakerPolicy {
+	policy := f.breakerPolicy
+	policy.Scope = tripperware.BreakerScope(f.breakerScope)
+	return policy
+}
+
+// validateManyRuns reports a flag naming one address or file, which runs
+// that share the flags in one process would fight over
+func (f *clientFlags) validateManyRuns() error {
+	switch {
+	case f.metricsAddr != "":
+		return fmt.Errorf("-metrics-addr can't be used, use -metrics-push")
+	case f.recordPath != "":
+		return fmt.Errorf("-record can't be used")
+	case f.debugHARPath != "":
+		return fmt.Errorf("-debug-http-har can't be used")
+	}
+	return nil
+}
+
+// clientSession is what one run builds from the clientFlags
+type clientSession struct {
+	// Options are passed to every Clever client of the run
+	Options []tripperware.Option
+	// Metrics is nil unless metrics were asked for
+	Metrics *metrics.Metrics
+	// Breaker is nil with -breaker=none
+	Breaker *tripperware.Breaker
+	// PageSize is how many records each list request asks for
+	PageSize int
+
+	logger   *zap.Logger
+	flags    *clientFlags
+	began    time.Time
+	district string
+	cassette *tripperware.Cassette
+	har      *tripperware.HAR
+}
+
+// start builds the client options for one run. district labels pushed
+// metrics and may be empty.
+func (f *clientFlags) start(
+	logger *zap.Logger,
+	district string,
+) (*clientSession, error) {
+	s := &clientSession{
+		logger:   logger,
+		flags:    f,
+		began:    time.Now(),
+		district: district,
+		PageSize: f.pageSize,
+	}
+	if f.metricsAddr != "" || f.metricsPushURL != "" {
+		s.Metrics = newRunMetrics(logger, f.metricsAddr)
+	}
+	s.Options = []tripperware.Option{
+		tripperware.WithMetrics(s.Metrics),
+		tripperware.WithRetryPolicy(f.retryPolicy),
+	}
+
+	if f.debugHTTP || f.debugHARPath != "" {
+		debugOptions := f.debugOptions
+		debugOptions.Log = f.debugHTTP
+		debugOptions.RedactFields = f.debugRedact.CleverJSONKeys()
+		if f.debugHARPath != "" {
+			s.har = tripperware.NewHAR("clever-repartee", version.HumanVersion)
+			debugOptions.HAR = s.har
+		}
+		s.Options = append(s.Options, tripperware.WithDebug(debugOptions))
+	}
+
+	if f.breakerScope != "none" {
+		s.Breaker = tripperware.NewBreaker(f.breaker(), logger)
+		s.Options = append(s.Options, tripperware.WithBreaker(s.Breaker))
+	}
+
+	switch {
+	case f.recordPath != "":
+		s.cassette = tripperware.NewRecordingCassette(f.recordPath)
+		s.Options = append(s.Options, tripperware.WithCassette(s.cassette))
+	case f.replayPath != "":
+		cassette, cassetteErr := tripperware.LoadCassette(f.replayPath)
+		if cassetteErr != nil {
+			return nil, cassetteErr
+		}
+		s.Options = append(s.Options, tripperware.WithCassette(cassette))
+		logger.Info("Replaying cassette", zap.String("path", f.replayPath))
+	}
+	return s, nil
+}
+
+// finish saves the cassette and HAR file, summarises the circuit breaker and
+// records and pushes the run's metrics. Everything is saved even if the run
+// failed, since a failed run is the one worth replaying.
+func (s *clientSession) finish(err error) {
+	if s.cassette != nil {
+		path := s.flags.recordPath
+		if saveErr := s.cassette.Save(); saveErr != nil {
+			s.logger.Error(
+				"Unable to save cassette",
+				zap.String("path", path),
+				zap.Error(saveErr),
+			)
+		} else {
+			s.logger.Info("Recorded cassette", zap.String("path", path))
+		}
+	}
+	if s.har != nil {
+		path := s.flags.debugHARPath
+		if harErr := s.har.Save(path); harErr != nil {
+			s.logger.Error(
+				"Unable to write HAR file",
+				zap.String("path", path),
+				zap.Error(harErr),
+			)
+		} else {
+			s.logger.Info("Wrote HAR file", zap.String("path", path))
+		}
+	}
+	if s.Breaker != nil {
+		logBreakerStates(s.logger, s.Breaker)
+	}
+	if s.Metrics != nil {
+		s.Metrics.RunFinished(s.began, err)
+		pushMetrics(s.logger, s.Metrics, s.flags.metricsPushURL, s.district)
+	}
+}
+
+// replaying is true when Clever's responses come from a cassette, so no
+// credentials are needed
+func (s *clientSession) replaying() bool {
+	return tripperware.Replaying(s.Options...)
+}
+
+// trippedBreakers are the circuits that opened at least once
+func (s *clientSession) trippedBreakers() []tripperware.BreakerState {
+	if s.Breaker == nil {
+		return nil
+	}
+	var tripped []tripperware.BreakerState
+	for _, state := range s.Breaker.States() {
+		if state.Trips > 0 {
+			tripped = append(tripped, state)
+		}
+	}
+	return tripped
+}
+
+// logBreakerStates summarises the circuit breaker at the end of a run, as a
+// warning if any circuit opened
+func logBreakerStates(logger *zap.Logger, breaker *tripperware.Breaker) {
+	for _, state := range breaker.States() {
+		fields := []zap.Field{
+			zap.String("circuit", state.Circuit),
+			zap.String("state", state.State),
+			zap.Int("requests", state.Requests),
+			zap.Int("failures", state.Failures),
+			zap.Int("trips", state.Trips),
+		}
+		if state.Trips > 0 {
+			logger.Warn("Circuit breaker opened during the run", fields...)
+		} else {
+			logger.Info("Circuit breaker", fields...)
+		}
+	}
+}
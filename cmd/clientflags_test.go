@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"flag"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/tripperware"
+)
+
+func TestClientFlagsBreaker(t *testing.T) {
+	tests := []struct {
+		scope       string
+		wantProblem bool
+		wantBreaker bool
+	}{
+		{scope: "host", wantBreaker: true},
+		{scope: "endpoint", wantBreaker: true},
+		{scope: "none"},
+		{scope: "district", wantProblem: true, wantBreaker: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			f := newClientFlags()
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			f.register(fs)
+			if err := fs.Parse([]string{"-breaker", tt.scope}); err != nil {
+				t.Fatal(err)
+			}
+
+			problems := f.problems()
+			if (len(problems) > 0) != tt.wantProblem {
+				t.Errorf(
+					"problems() = %v, want a problem %v",
+					problems,
+					tt.wantProblem,
+				)
+			}
+			// Checking the flags doesn't change them
+			if f.breakerPolicy != tripperware.DefaultBreakerPolicy() {
+				t.Errorf(
+					"breaker policy = %+v after problems()",
+					f.breakerPolicy,
+				)
+			}
+			if got := f.breaker().Scope; string(got) != tt.scope {
+				t.Errorf("breaker() scope = %s, want %s", got, tt.scope)
+			}
+
+			session, err := f.start(zap.NewNop(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (session.Breaker != nil) != tt.wantBreaker {
+				t.Errorf(
+					"start() breaker = %v, want one %v",
+					session.Breaker,
+					tt.wantBreaker,
+				)
+			}
+		})
+	}
+}
@@ -2,53 +2,239 @@ package cmd
 
 import (
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 
 	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/config"
+	"github.com/Khan/clever-repartee/pkg/logging"
 )
 
 type Command struct {
-	// Run runs the command. The args are the arguments after the command
-	// name.
+	// Run runs the command. The args are the arguments left after the
+	// command name and its flags.
 	Run func(cmd *Command, args []string) error
 
-	// UsageLine is the one-line usage message.
+	// UsageLine is the one-line usage message. Its first word is the
+	// command name.
 	UsageLine string
 
 	// Short is the short description shown in the 'help' output.
 	Short string
 
-	// Long is the long message shown in the 'go help <this-command>' output.
+	// Long is the long message shown in the 'help <this-command>' output.
 	Long string
 
-	// Logger
+	// Flag is the set of flags specific to this command.
+	Flag flag.FlagSet
+
+	// Logger is scoped to the run once the flags are parsed, with the run
+	// ID and command name on every line
 	Logger *zap.Logger
+
+	// ManyRuns is true for a command that runs many diffs, each logged with
+	// its own run ID, so that its Logger has no run ID of its own
+	ManyRuns bool
+
+	// ConfigFlags picks the config file settings that fill in the
+	// command's flags. It is nil for a command that takes no -config.
+	ConfigFlags func(c *config.Config) []config.Setting
+
+	// Config is the config file the command is run with, nil if none
+	Config *config.Config
+
+	configPath string
+
+	logFormat, logLevel string
+}
+
+// registerLogFlags adds the flags every command has
+func (c *Command) registerLogFlags() {
+	c.Flag.StringVar(
+		&c.logFormat,
+		"log-format",
+		logging.FormatConsole,
+		"Log as "+logging.FormatConsole+" output or as "+logging.FormatJSON+
+			" for Stackdriver",
+	)
+	c.Flag.StringVar(
+		&c.logLevel,
+		"log-level",
+		logging.DefaultLevel,
+		"Least severe level logged: debug (every Clever API request), "+
+			"info, warn or error",
+	)
+}
+
+// scopeLogger builds the logger of the -log-format and -log-level flags,
+// with the run ID and command name on every line
+func (c *Command) scopeLogger() error {
+	logger, loggerErr := logging.New(c.logFormat, c.logLevel)
+	if loggerErr != nil {
+		return &UsageError{Command: c, Err: loggerErr}
+	}
+	if !c.ManyRuns {
+		logger = logger.With(zap.String(logging.FieldRunID, logging.NewRunID()))
+	}
+	c.Logger = logger.With(zap.String(logging.FieldCommand, c.Name()))
+	return nil
+}
+
+// takeConfig adds the -config flag, whose file fills in the settings pick
+// chooses
+func (c *Command) takeConfig(pick func(c *config.Config) []config.Setting) {
+	c.ConfigFlags = pick
+	c.Flag.StringVar(
+		&c.configPath,
+		"config",
+		"",
+		"YAML or JSON config file, $"+config.PathEnv+" if not given. "+
+			"Flags and environment variables override it.",
+	)
+}
+
+// loadConfig reads the config file, if there is one, and fills in the
+// environment variables and flags that weren't set from it
+func (c *Command) loadConfig() error {
+	path := c.configPath
+	if path == "" {
+		path = os.Getenv(config.PathEnv)
+	}
+	if path == "" {
+		return nil
+	}
+	loaded, loadErr := config.Load(path)
+	if loadErr != nil {
+		return loadErr
+	}
+	problems := append(
+		loaded.Validate(),
+		config.SetFlags(&c.Flag, c.ConfigFlags(loaded))...,
+	)
+	if len(problems) > 0 {
+		return fmt.Errorf(
+			"invalid config %s: %w, run 'clever-repartee config validate' "+
+				"for every problem",
+			path,
+			problems[0],
+		)
+	}
+	if envErr := loaded.SetEnv(); envErr != nil {
+		return envErr
+	}
+	c.Config = loaded
+	return nil
+}
+
+// Name is the command name, the first word of the usage line
+func (c *Command) Name() string {
+	name := c.UsageLine
+	if i := strings.Index(name, " "); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// Usage writes the usage line, long description and flag defaults to w
+func (c *Command) Usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: clever-repartee %s\n\n", c.UsageLine)
+	fmt.Fprintf(w, "%s\n", c.Long)
+	if hasFlags(&c.Flag) {
+		fmt.Fprintf(w, "\nFlags:\n")
+		c.Flag.SetOutput(w)
+		c.Flag.PrintDefaults()
+		c.Flag.SetOutput(ioutil.Discard)
+	}
 }
 
-// Arguments without the executable name
+func hasFlags(fs *flag.FlagSet) bool {
+	has := false
+	fs.VisitAll(func(*flag.Flag) { has = true })
+	return has
+}
+
+// UsageError is returned for a command line that can't be run, e.g. an
+// unknown command or flag, as opposed to a run that failed
+type UsageError struct {
+	// Command is nil if the command itself was unknown
+	Command *Command
+	Err     error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// HelpHint tells the user where to find the usage for the command line
+func (e *UsageError) HelpHint() string {
+	if e.Command == nil {
+		return "Run 'clever-repartee help' for usage."
+	}
+	return fmt.Sprintf(
+		"Run 'clever-repartee help %s' for usage.",
+		e.Command.Name(),
+	)
+}
+
+// districtLogger logs with the district
+func districtLogger(logger *zap.Logger, district string) *zap.Logger {
+	return logger.With(zap.String(logging.FieldDistrict, district))
+}
+
+func usageErrorf(cmd *Command, format string, a ...interface{}) error {
+	return &UsageError{Command: cmd, Err: fmt.Errorf(format, a...)}
+}
+
+// Run runs the command line, without the executable name. The logger is
+// replaced by one scoped to the run once the command's flags are parsed. A
+// failed run is logged, so only a usage error is left for the caller to
+// report.
 func Run(args []string, logger *zap.Logger) error {
 	commands := []*Command{
 		VersionCommand(logger),
 		DiffCommand(logger),
+		RevealCommand(logger),
+		ExportCommand(logger),
+		InspectCommand(logger),
+		ValidateCommand(logger),
+		DistrictsCommand(logger),
+		ConnectionsCommand(logger),
+		ServeCommand(logger),
+		ScheduleCommand(logger),
+		ConfigCommand(logger),
 	}
 
 	var m = make(map[string]*Command)
 	for i := range commands {
 		cmd := commands[i]
-		m[cmd.UsageLine] = cmd
+		cmd.registerLogFlags()
+		m[cmd.Name()] = cmd
 	}
 
 	// if they don't pass a command or only pass "help"
 	if len(args) == 0 || (len(args) == 1 && args[0] == "help") {
-		logger.Info(
-			"clever - Tool for interacting with the Clever API. Available Commands:",
-		)
-		for i := range commands {
-			cmd := *commands[i]
-			logger.Info(fmt.Sprintf("%s - %s", cmd.UsageLine, cmd.Short))
+		printCommands(os.Stdout, commands)
+		return nil
+	}
+
+	if args[0] == "help" {
+		if len(args) > 2 {
+			return usageErrorf(nil, "help takes at most one command")
 		}
+		cmd := m[args[1]]
+		if cmd == nil {
+			return usageErrorf(nil, "%s: unknown help topic", args[1])
+		}
+		cmd.Usage(os.Stdout)
 		return nil
 	}
 
@@ -56,18 +242,65 @@ func Run(args []string, logger *zap.Logger) error {
 
 	var cmd = m[arg]
 	if cmd == nil {
-		return errors.New(arg + ": invalid command")
+		return usageErrorf(nil, "%s: invalid command", arg)
+	}
+
+	// Parse errors are returned rather than printed, so that main reports
+	// them once
+	cmd.Flag.Init(cmd.Name(), flag.ContinueOnError)
+	cmd.Flag.SetOutput(ioutil.Discard)
+	if parseErr := cmd.Flag.Parse(args[1:]); parseErr != nil {
+		if errors.Is(parseErr, flag.ErrHelp) {
+			cmd.Usage(os.Stdout)
+			return nil
+		}
+		return &UsageError{Command: cmd, Err: parseErr}
+	}
+	if loggerErr := cmd.scopeLogger(); loggerErr != nil {
+		return loggerErr
+	}
+	defer func() {
+		_ = cmd.Logger.Sync()
+	}()
+
+	var runErr error
+	if cmd.ConfigFlags != nil {
+		runErr = cmd.loadConfig()
 	}
-	// pass arguments without the executable and without the command itself
-	return cmd.Run(cmd, args[1:])
+	if runErr == nil {
+		// pass the arguments left after the command and its flags
+		runErr = cmd.Run(cmd, cmd.Flag.Args())
+	}
+	// A usage error is reported by main, before the help hint
+	var usageErr *UsageError
+	switch {
+	case errors.As(runErr, &usageErr):
+	case runErr != nil:
+		cmd.Logger.Error("Command failed", zap.Error(runErr))
+	default:
+		cmd.Logger.Info("Successful completion")
+	}
+	return runErr
 }
 
-func getFlags() []string {
-	var args []string
-	for _, arg := range os.Args[1:] {
-		if strings.HasPrefix(arg, "--") || strings.HasPrefix(arg, "-") {
-			args = append(args, arg)
+func printCommands(w io.Writer, commands []*Command) {
+	fmt.Fprintf(
+		w,
+		"clever-repartee - Tool for interacting with the Clever API.\n\n",
+	)
+	fmt.Fprintf(w, "Usage:\n\n\tclever-repartee <command> [flags]\n\n")
+	fmt.Fprintf(w, "Commands:\n\n")
+	width := 0
+	for _, cmd := range commands {
+		if len(cmd.Name()) > width {
+			width = len(cmd.Name())
 		}
 	}
-	return args
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "\t%-*s  %s\n", width, cmd.Name(), cmd.Short)
+	}
+	fmt.Fprintf(
+		w,
+		"\nRun 'clever-repartee help <command>' for more about a command.\n",
+	)
 }
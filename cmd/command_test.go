@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintCommands(t *testing.T) {
+	commands := []*Command{
+		{UsageLine: "diff -district=${ID}", Short: "Compare"},
+		{UsageLine: "connections [flags]", Short: "List"},
+	}
+	var buf bytes.Buffer
+	printCommands(&buf, commands)
+	for _, want := range []string{
+		"\tdiff         Compare\n",
+		"\tconnections  List\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("commands %q, want a line %q", buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/config"
+	"github.com/Khan/clever-repartee/pkg/mail"
+)
+
+func ConfigCommand(logger *zap.Logger) *Command {
+	flags := &configFlags{}
+	cmd := &Command{
+		UsageLine: "config validate [-config=${PATH}]",
+		Short:     "Check a config file",
+		Long: "Check the config file given by -config, or by $" +
+			config.PathEnv + ", and report every problem with it at once: " +
+			"unknown keys, credentials without a secret, bad email " +
+			"addresses, SMTP settings, output options and HTTP client " +
+			"settings, and templates that don't parse.",
+		Logger: logger,
+	}
+	flags.register(&cmd.Flag)
+	cmd.Run = func(cmd *Command, args []string) error {
+		if len(args) == 0 {
+			return usageErrorf(cmd, "config needs a subcommand: validate")
+		}
+		if args[0] != "validate" {
+			return usageErrorf(cmd, "%s: unknown config subcommand", args[0])
+		}
+		// Flags can come after the subcommand too
+		if parseErr := cmd.Flag.Parse(args[1:]); parseErr != nil {
+			if errors.Is(parseErr, flag.ErrHelp) {
+				cmd.Usage(os.Stdout)
+				return nil
+			}
+			return &UsageError{Command: cmd, Err: parseErr}
+		}
+		if cmd.Flag.NArg() > 0 {
+			return usageErrorf(
+				cmd,
+				"unexpected arguments: %s",
+				cmd.Flag.Args(),
+			)
+		}
+		if flags.path == "" {
+			flags.path = os.Getenv(config.PathEnv)
+		}
+		if flags.path == "" {
+			return usageErrorf(
+				cmd,
+				"-config ${PATH} or $%s is required",
+				config.PathEnv,
+			)
+		}
+		return ValidateConfig(cmd.Logger, flags)
+	}
+	return cmd
+}
+
+// configFlags are the flags of the config command
+type configFlags struct {
+	path string
+}
+
+func (f *configFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.path, "config", "", "YAML or JSON config file")
+}
+
+// ValidateConfig prints every problem with the config file, one per line
+func ValidateConfig(logger *zap.Logger, f *configFlags) error {
+	loaded, loadErr := config.Load(f.path)
+	if loadErr != nil {
+		return loadErr
+	}
+	problems := loaded.Validate()
+
+	// The output and HTTP settings are checked as the flags of a diff,
+	// which has all of them
+	diff := newDiffFlags()
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	diff.register(fs)
+	problems = append(
+		problems,
+		config.SetFlags(fs, diffConfigFlags(loaded))...,
+	)
+	if notifyErr := validateNotifyMode(diff.notifyMode); notifyErr != nil {
+		problems = append(problems, notifyErr)
+	}
+	problems = append(problems, diff.client.problems()...)
+	_, templatesErr := mail.LoadTemplates(
+		diff.subjectTemplate,
+		diff.textTemplate,
+		diff.htmlTemplate,
+	)
+	if templatesErr != nil {
+		problems = append(problems, templatesErr)
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("%s is valid\n", f.path)
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", f.path, problem)
+	}
+	return fmt.Errorf("%d problems in config %s", len(problems), f.path)
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/config"
+	"github.com/Khan/clever-repartee/pkg/districts"
+	"github.com/Khan/clever-repartee/pkg/logging"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+func ConnectionsCommand(logger *zap.Logger) *Command {
+	flags := &connectionsFlags{client: newClientFlags()}
+	cmd := &Command{
+		UsageLine: "connections [flags]",
+		Short:     "List districts connected to only one of the two Clever apps",
+		Long: "List the district tokens of both Clever apps, MAP Accelerator " +
+			"and MAP Growth, and report the districts that only one app is " +
+			"connected to, with their names and launch dates. These are " +
+			"discrepancies diff can't find, since it compares one district " +
+			"at a time.",
+		Logger: logger,
+	}
+	flags.register(&cmd.Flag)
+	cmd.takeConfig((*config.Config).HTTPFlags)
+	cmd.Run = func(cmd *Command, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf(cmd, "unexpected arguments: %s", args)
+		}
+		switch flags.format {
+		case districtsFormatTable, districtsFormatJSON:
+		default:
+			return usageErrorf(
+				cmd,
+				"invalid -format %q, must be table or json",
+				flags.format,
+			)
+		}
+		return Connections(cmd.Logger, flags)
+	}
+	return cmd
+}
+
+// connectionsFlags are the flags of the connections command
+type connectionsFlags struct {
+	format string
+
+	client *clientFlags
+}
+
+func (f *connectionsFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.format, "format", districtsFormatTable, "table or json")
+	f.client.register(fs)
+}
+
+func Connections(logger *zap.Logger, f *connectionsFlags) (err error) {
+	if clientErr := f.client.validate(); clientErr != nil {
+		return clientErr
+	}
+
+	ctx := context.Background() //nolint:ka-context // GKE ≠ AppEngine
+	session, sessionErr := f.client.start(logger, "")
+	if sessionErr != nil {
+		return sessionErr
+	}
+	defer func() {
+		session.finish(err)
+	}()
+
+	apps := [2]string{appMAPAccelerator, appMAPGrowth}
+	var ids [2][]string
+	// Each app's token for each district, to look the district up with
+	tokens := [2]map[string]string{{}, {}}
+	for i, app := range apps {
+		isMAP, _ := parseApp(app)
+		appTokens, tokensErr := rostering.GetCleverTokens(
+			ctx,
+			logger,
+			"",
+			isMAP,
+			session.Options...,
+		)
+		if tokensErr != nil {
+			return fmt.Errorf(
+				"unable to list %s district tokens: %w",
+				app,
+				tokensErr,
+			)
+		}
+		for _, token := range appTokens {
+			ids[i] = append(ids[i], token.Owner.ID)
+			tokens[i][token.Owner.ID] = token.AccessToken
+		}
+	}
+
+	connections := districts.CompareConnections(apps, ids)
+	for i := range connections.OneApp {
+		d := &connections.OneApp[i]
+		app := 0
+		if d.ConnectedTo == apps[1] {
+			app = 1
+		}
+		fetched, fetchErr := fetchDistricts(
+			ctx,
+			logger.With(zap.String(logging.FieldApp, d.ConnectedTo)),
+			session,
+			tokens[app][d.ID],
+		)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if fetchErr != nil {
+			logger.Error(
+				"Unable to fetch district",
+				zap.String("app", d.ConnectedTo),
+				zap.String("district", d.ID),
+				zap.Error(fetchErr),
+			)
+		}
+		for _, district := range fetched {
+			if district.Name != nil {
+				d.Name = *district.Name
+			}
+			if district.LaunchDate != nil {
+				d.LaunchDate = *district.LaunchDate
+			}
+		}
+		logger.Warn(
+			"District connected to only one app",
+			zap.String("district", d.ID),
+			zap.String("name", d.Name),
+			zap.String("connected_to", d.ConnectedTo),
+			zap.String("missing_from", d.MissingFrom),
+		)
+	}
+	connections.Sort()
+
+	if f.format == districtsFormatJSON {
+		return connections.WriteJSON(os.Stdout)
+	}
+	return connections.WriteTable(os.Stdout)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// connections.cassette.json lists the district tokens of MAP Accelerator,
+// then MAP Growth, which share one district and have one each of their own
+const testConnectionsCassette = "testdata/connections.cassette.json"
+
+func TestConnectionsReplay(t *testing.T) {
+	f := &connectionsFlags{
+		format: districtsFormatJSON,
+		client: newClientFlags(),
+	}
+	f.client.replayPath = testConnectionsCassette
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	if err := Connections(zap.New(core), f); err != nil {
+		t.Fatalf("Connections() error = %v", err)
+	}
+
+	type oneApp struct{ district, name, connectedTo, missingFrom string }
+	var got []oneApp
+	warnings := logs.FilterMessage("District connected to only one app")
+	for _, entry := range warnings.All() {
+		fields := entry.ContextMap()
+		got = append(got, oneApp{
+			district:    fields["district"].(string),
+			name:        fields["name"].(string),
+			connectedTo: fields["connected_to"].(string),
+			missingFrom: fields["missing_from"].(string),
+		})
+	}
+	want := []oneApp{
+		{
+			district:    "5f1e2d3c4b5a69788796a5b5",
+			name:        "Shelbyville",
+			connectedTo: appMAPAccelerator,
+			missingFrom: appMAPGrowth,
+		},
+		{
+			district:    "5f1e2d3c4b5a69788796a5b6",
+			name:        "Capital City",
+			connectedTo: appMAPGrowth,
+			missingFrom: appMAPAccelerator,
+		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("districts on one app = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("district %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestConnectionsCommandFormat(t *testing.T) {
+	cmd := ConnectionsCommand(zap.NewNop())
+	if err := cmd.Flag.Parse([]string{"-format=xml"}); err != nil {
+		t.Fatal(err)
+	}
+	err := cmd.Run(cmd, cmd.Flag.Args())
+	if _, ok := err.(*UsageError); !ok {
+		t.Errorf("Run() error = %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/config"
+	"github.com/Khan/clever-repartee/pkg/districts"
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+const (
+	districtsFormatTable = "table"
+	districtsFormatJSON  = "json"
+	// appAll is every app with credentials set
+	appAll = "all"
+)
+
+func DistrictsCommand(logger *zap.Logger) *Command {
+	flags := &districtsFlags{client: newClientFlags()}
+	cmd := &Command{
+		UsageLine: "districts [flags]",
+		Short:     "List the districts each Clever app is connected to",
+		Long: "List every district each Clever app with credentials set is " +
+			"connected to, with its SIS, login methods, launch date, last " +
+			"sync, pause and error. Districts that are paused, report an " +
+			"error, or last synced longer ago than -max-sync-age are " +
+			"flagged. -max-sync-age=0 doesn't flag any as stale.",
+		Logger: logger,
+	}
+	flags.register(&cmd.Flag)
+	cmd.takeConfig((*config.Config).HTTPFlags)
+	cmd.Run = func(cmd *Command, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf(cmd, "unexpected arguments: %s", args)
+		}
+		if flags.app != appAll {
+			if _, appErr := parseApp(flags.app); appErr != nil {
+				return &UsageError{Command: cmd, Err: appErr}
+			}
+		}
+		if flags.maxSyncAge < 0 {
+			return usageErrorf(
+				cmd,
+				"invalid -max-sync-age %s, must not be negative",
+				flags.maxSyncAge,
+			)
+		}
+		switch flags.format {
+		case districtsFormatTable, districtsFormatJSON:
+		default:
+			return usageErrorf(
+				cmd,
+				"invalid -format %q, must be table or json",
+				flags.format,
+			)
+		}
+		return Districts(cmd.Logger, flags)
+	}
+	return cmd
+}
+
+// districtsFlags are the flags of the districts command
+type districtsFlags struct {
+	app string
+
+	format string
+
+	maxSyncAge time.Duration
+
+	flaggedOnly bool
+
+	client *clientFlags
+}
+
+func (f *districtsFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(
+		&f.app,
+		"app",
+		appAll,
+		"Clever app to list the districts of: "+appMAPAccelerator+", "+
+			appMAPGrowth+" or "+appAll,
+	)
+	fs.StringVar(&f.format, "format", districtsFormatTable, "table or json")
+	fs.DurationVar(
+		&f.maxSyncAge,
+		"max-sync-age",
+		districts.DefaultMaxSyncAge,
+		"Flag districts that last synced longer ago than this, or 0 "+
+			"not to",
+	)
+	fs.BoolVar(
+		&f.flaggedOnly,
+		"flagged",
+		false,
+		"Only list districts that are flagged",
+	)
+	f.client.register(fs)
+}
+
+func Districts(logger *zap.Logger, f *districtsFlags) (err error) {
+	if clientErr := f.client.validate(); clientErr != nil {
+		return clientErr
+	}
+
+	ctx := context.Background() //nolint:ka-context // GKE ≠ AppEngine
+	session, sessionErr := f.client.start(logger, "")
+	if sessionErr != nil {
+		return sessionErr
+	}
+	defer func() {
+		session.finish(err)
+	}()
+
+	apps := []string{f.app}
+	if f.app == appAll {
+		apps = configuredApps(session)
+		if len(apps) == 0 {
+			return fmt.Errorf("no Clever app has credentials set")
+		}
+	}
+
+	statuses, statusesErr := allDistricts(
+		ctx,
+		logger,
+		session,
+		apps,
+		time.Now(),
+		f.maxSyncAge,
+	)
+	if statusesErr != nil {
+		return statusesErr
+	}
+
+	for _, s := range districts.Flagged(statuses) {
+		logger.Warn(
+			"District flagged",
+			zap.String("app", s.App),
+			zap.String("district", s.ID),
+			zap.String("name", s.Name),
+			zap.Strings("flags", s.Flags),
+		)
+	}
+	if f.flaggedOnly {
+		statuses = districts.Flagged(statuses)
+	}
+
+	if f.format == districtsFormatJSON {
+		return districts.WriteJSON(os.Stdout, statuses)
+	}
+	return districts.WriteTable(os.Stdout, statuses)
+}
+
+// configuredApps are the apps with credentials set, or every app when
+// replaying
+func configuredApps(session *clientSession) []string {
+	var apps []string
+	for _, app := range []string{appMAPAccelerator, appMAPGrowth} {
+		isMAP, _ := parseApp(app)
+		if rostering.CredentialsSet(isMAP) || session.replaying() {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
+
+// allDistricts fetches every district of each app, app by app
+func allDistricts(
+	ctx context.Context,
+	logger *zap.Logger,
+	session *clientSession,
+	apps []string,
+	now time.Time,
+	maxSyncAge time.Duration,
+) ([]districts.Status, error) {
+	var statuses []districts.Status
+	for _, app := range apps {
+		appStatuses, appErr := appDistricts(
+			ctx,
+			logger,
+			session,
+			app,
+			now,
+			maxSyncAge,
+		)
+		if appErr != nil {
+			return nil, appErr
+		}
+		statuses = append(statuses, appStatuses...)
+	}
+	return statuses, nil
+}
+
+// appDistricts fetches every district the app is connected to, sorted by
+// name. A district that can't be fetched is listed with the error, unless
+// ctx is done.
+func appDistricts(
+	ctx context.Context,
+	logger *zap.Logger,
+	session *clientSession,
+	app string,
+	now time.Time,
+	maxSyncAge time.Duration,
+) ([]districts.Status, error) {
+	isMAP, _ := parseApp(app)
+	tokens, tokensErr := rostering.GetCleverTokens(
+		ctx,
+		logger,
+		"",
+		isMAP,
+		session.Options...,
+	)
+	if tokensErr != nil {
+		return nil, tokensErr
+	}
+
+	appLogger := rostering.AppLogger(logger, isMAP)
+	var statuses []districts.Status
+	for _, token := range tokens {
+		fetched, fetchErr := fetchDistricts(
+			ctx,
+			appLogger,
+			session,
+			token.AccessToken,
+		)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if fetchErr != nil {
+			appLogger.Error(
+				"Unable to fetch district",
+				zap.String("district", token.Owner.ID),
+				zap.Error(fetchErr),
+			)
+			statuses = append(statuses, districts.Status{
+				App:   app,
+				ID:    token.Owner.ID,
+				Error: fetchErr.Error(),
+				Flags: []string{districts.FlagError},
+			})
+			continue
+		}
+		for _, district := range fetched {
+			statuses = append(
+				statuses,
+				districts.NewStatus(app, district, now, maxSyncAge),
+			)
+		}
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, nil
+}
+
+func fetchDistricts(
+	ctx context.Context,
+	logger *zap.Logger,
+	session *clientSession,
+	token string,
+) ([]generated.District, error) {
+	cleverClient, clientErr := rostering.NewCleverClient(
+		logger,
+		token,
+		session.Options...,
+	)
+	if clientErr != nil {
+		return nil, clientErr
+	}
+	fetched, fetchErr := rostering.GetCleverDistricts(ctx, cleverClient)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return *fetched, nil
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestDistrictsCommandUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "negative max sync age", args: []string{"-max-sync-age=-1h"}},
+		{name: "unknown format", args: []string{"-format=xml"}},
+		{name: "unknown app", args: []string{"-app=map"}},
+		{name: "arguments", args: []string{"extra"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := DistrictsCommand(zap.NewNop())
+			if err := cmd.Flag.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			err := cmd.Run(cmd, cmd.Flag.Args())
+			if _, ok := err.(*UsageError); !ok {
+				t.Errorf("Run() error = %v, want a usage error", err)
+			}
+		})
+	}
+}
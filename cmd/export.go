@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/config"
+	"github.com/Khan/clever-repartee/pkg/export"
+	"github.com/Khan/clever-repartee/pkg/logging"
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+	"github.com/Khan/clever-repartee/pkg/version"
+)
+
+func ExportCommand(logger *zap.Logger) *Command {
+	flags := newExportFlags()
+	cmd := &Command{
+		UsageLine: "export -district=${ID} [flags]",
+		Short:     "Write a district's whole roster via one Clever app to files",
+		Long: "Fetch the roster of the district with the Clever ID given by " +
+			"-district via one Clever app, and write every entity type to " +
+			"its own file in -out, with a manifest listing the app, " +
+			"district, time, tool version and record counts. PII fields " +
+			"not given by -pii are left out of student, teacher and admin " +
+			"records. Contacts aren't exported, since their phone " +
+			"numbers can't be left out with -pii.",
+		Logger: logger,
+	}
+	flags.register(&cmd.Flag)
+	cmd.takeConfig((*config.Config).HTTPFlags)
+	cmd.Run = func(cmd *Command, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf(cmd, "unexpected arguments: %s", args)
+		}
+		if flags.districtCleverID == "" {
+			return usageErrorf(cmd, "-district ${ID} is a required argument")
+		}
+		if _, appErr := parseApp(flags.app); appErr != nil {
+			return &UsageError{Command: cmd, Err: appErr}
+		}
+		if formatErr := export.ValidateFormat(flags.format); formatErr != nil {
+			return &UsageError{Command: cmd, Err: formatErr}
+		}
+		return Export(cmd.Logger, flags)
+	}
+	return cmd
+}
+
+// exportFlags are the flags of the export command
+type exportFlags struct {
+	districtCleverID string
+
+	app string
+
+	format string
+
+	outDir string
+
+	piiFields report.PIIFields
+
+	client *clientFlags
+}
+
+func newExportFlags() *exportFlags {
+	f := &exportFlags{piiFields: report.PIIFields{}, client: newClientFlags()}
+	for _, field := range report.DefaultPIIFields {
+		f.piiFields[field] = true
+	}
+	return f
+}
+
+func (f *exportFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.districtCleverID, "district", "", "District Clever ID")
+	fs.StringVar(
+		&f.app,
+		"app",
+		appMAPAccelerator,
+		"Clever app to export the roster of: "+appMAPAccelerator+" or "+
+			appMAPGrowth,
+	)
+	fs.StringVar(
+		&f.format,
+		"format",
+		"json",
+		"Format of the files: "+strings.Join(export.Formats, ", "),
+	)
+	fs.StringVar(&f.outDir, "out", ".", "Directory to write the files to")
+	fs.Var(
+		f.piiFields,
+		"pii",
+		"PII fields to include: comma separated list of "+
+			"name, sis_id, number, email and dob, or all or none",
+	)
+	f.client.register(fs)
+}
+
+// parseApp checks an app name, and tells whether it is MAP Growth
+func parseApp(app string) (bool, error) {
+	switch app {
+	case appMAPAccelerator:
+		return false, nil
+	case appMAPGrowth:
+		return true, nil
+	}
+	return false, fmt.Errorf(
+		"invalid app %q, must be %s or %s",
+		app,
+		appMAPAccelerator,
+		appMAPGrowth,
+	)
+}
+
+func Export(logger *zap.Logger, f *exportFlags) (err error) {
+	logger = districtLogger(logger, f.districtCleverID)
+	districtCleverID := f.districtCleverID
+
+	isMAP, appErr := parseApp(f.app)
+	if appErr != nil {
+		return appErr
+	}
+	if clientErr := f.client.validate(); clientErr != nil {
+		return clientErr
+	}
+	// Check the format before spending time fetching the roster
+	if formatErr := export.ValidateFormat(f.format); formatErr != nil {
+		return formatErr
+	}
+
+	exportedAt := time.Now()
+
+	ctx := context.Background() //nolint:ka-context // GKE ≠ AppEngine
+	session, sessionErr := f.client.start(logger, districtCleverID)
+	if sessionErr != nil {
+		return sessionErr
+	}
+	defer func() {
+		session.finish(err)
+	}()
+
+	// The logger already has the district, and only the client's requests
+	// are logged with the app
+	logger.Info(
+		"Exporting district roster",
+		zap.String(logging.FieldApp, f.app),
+	)
+
+	cleverClient, clientErr := rostering.GetCleverClient(
+		ctx,
+		logger,
+		districtCleverID,
+		isMAP,
+		session.Options...,
+	)
+	if clientErr != nil {
+		return clientErr
+	}
+	roster, rosterErr := rostering.GetRoster(
+		ctx,
+		logger,
+		cleverClient,
+		session.PageSize,
+	)
+	if rosterErr != nil {
+		return rosterErr
+	}
+	observeRoster(session.Metrics, districtCleverID, f.app, roster)
+
+	terms, termErr := rostering.GetCleverTerms(
+		ctx,
+		cleverClient,
+		session.PageSize,
+	)
+	if termErr != nil {
+		return termErr
+	}
+	roster.Terms = terms
+	courses, courseErr := rostering.GetCleverCourses(
+		ctx,
+		cleverClient,
+		session.PageSize,
+	)
+	if courseErr != nil {
+		return courseErr
+	}
+	roster.Courses = courses
+
+	var districtName string
+	if roster.Districts != nil {
+		for _, district := range *roster.Districts {
+			if district.Name != nil {
+				districtName = *district.Name
+			}
+		}
+	}
+
+	paths, writeErr := export.Write(
+		f.outDir,
+		report.FilePrefix(districtCleverID, exportedAt)+"-"+f.app,
+		f.format,
+		roster,
+		f.piiFields,
+		export.Manifest{
+			Tool:             "clever-repartee",
+			ToolVersion:      version.HumanVersion,
+			App:              f.app,
+			DistrictCleverID: districtCleverID,
+			DistrictName:     districtName,
+			ExportedAt:       exportedAt.UTC(),
+		},
+	)
+	for _, path := range paths {
+		logger.Info("Wrote export file", zap.String("path", path))
+	}
+	return writeErr
+}
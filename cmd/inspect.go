@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/config"
+	"github.com/Khan/clever-repartee/pkg/inspect"
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+func InspectCommand(logger *zap.Logger) *Command {
+	flags := newInspectFlags()
+	cmd := &Command{
+		UsageLine: "inspect -district=${ID} -type=student -id=${ID} [flags]",
+		Short:     "Compare one student, teacher, section or school across apps",
+		Long: "Look up one entity by Clever ID, and everything related to it " +
+			"(e.g. a student's schools, sections, teachers and contacts), " +
+			"via every Clever app with credentials set. Prints what each " +
+			"app sees side by side, marking rows that differ with !. PII " +
+			"fields not given by -pii are left out.",
+		Logger: logger,
+	}
+	flags.register(&cmd.Flag)
+	cmd.takeConfig((*config.Config).HTTPFlags)
+	cmd.Run = func(cmd *Command, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf(cmd, "unexpected arguments: %s", args)
+		}
+		if flags.districtCleverID == "" {
+			return usageErrorf(cmd, "-district ${ID} is a required argument")
+		}
+		if flags.id == "" {
+			return usageErrorf(cmd, "-id ${ID} is a required argument")
+		}
+		if typeErr := inspect.ValidateType(flags.entityType); typeErr != nil {
+			return &UsageError{Command: cmd, Err: typeErr}
+		}
+		return Inspect(cmd.Logger, flags)
+	}
+	return cmd
+}
+
+// inspectFlags are the flags of the inspect command
+type inspectFlags struct {
+	districtCleverID string
+
+	entityType string
+
+	id string
+
+	color bool
+
+	piiFields report.PIIFields
+
+	client *clientFlags
+}
+
+func newInspectFlags() *inspectFlags {
+	f := &inspectFlags{piiFields: report.PIIFields{}, client: newClientFlags()}
+	for _, field := range report.DefaultPIIFields {
+		f.piiFields[field] = true
+	}
+	return f
+}
+
+func (f *inspectFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.districtCleverID, "district", "", "District Clever ID")
+	fs.StringVar(
+		&f.entityType,
+		"type",
+		"student",
+		"Type of entity: "+strings.Join(inspect.Types, ", "),
+	)
+	fs.StringVar(&f.id, "id", "", "Clever ID of the entity")
+	fs.BoolVar(&f.color, "color", false, "Show rows that differ in red")
+	fs.Var(
+		f.piiFields,
+		"pii",
+		"PII fields to show: comma separated list of "+
+			"name, sis_id, number, email and dob, or all or none",
+	)
+	f.client.register(fs)
+}
+
+func Inspect(logger *zap.Logger, f *inspectFlags) (err error) {
+	logger = districtLogger(logger, f.districtCleverID)
+	if clientErr := f.client.validate(); clientErr != nil {
+		return clientErr
+	}
+
+	ctx := context.Background() //nolint:ka-context // GKE ≠ AppEngine
+	session, sessionErr := f.client.start(logger, f.districtCleverID)
+	if sessionErr != nil {
+		return sessionErr
+	}
+	defer func() {
+		session.finish(err)
+	}()
+
+	var apps []string
+	var inspections []*inspect.Inspection
+	for _, app := range configuredApps(session) {
+		isMAP, _ := parseApp(app)
+		cleverClient, clientErr := rostering.GetCleverClient(
+			ctx,
+			logger,
+			f.districtCleverID,
+			isMAP,
+			session.Options...,
+		)
+		if clientErr != nil {
+			return clientErr
+		}
+		inspection, inspectErr := inspect.Inspect(
+			ctx,
+			cleverClient,
+			f.entityType,
+			f.id,
+			session.PageSize,
+		)
+		if inspectErr != nil {
+			return fmt.Errorf("unable to inspect via %s: %w", app, inspectErr)
+		}
+		omitPII(f.piiFields, inspection)
+		apps = append(apps, app)
+		inspections = append(inspections, inspection)
+	}
+	if len(inspections) == 0 {
+		return fmt.Errorf("no Clever app has credentials set")
+	}
+
+	differences, printErr := inspect.Print(
+		os.Stdout,
+		apps,
+		inspections,
+		f.color,
+	)
+	if printErr != nil {
+		return printErr
+	}
+	logger.Info(
+		"Inspected entity",
+		zap.String("type", f.entityType),
+		zap.String("id", f.id),
+		zap.Int("differences", differences),
+	)
+	return nil
+}
+
+// omitPII leaves the PII fields that are not included out of the records of
+// people
+func omitPII(pii report.PIIFields, inspection *inspect.Inspection) {
+	people := map[string]bool{
+		"student":  true,
+		"teacher":  true,
+		"students": true,
+		"teachers": true,
+		"contacts": true,
+	}
+	if inspection.Record != nil && people[inspection.Type] {
+		pii.Omit(inspection.Record)
+	}
+	for _, r := range inspection.Relationships {
+		if !people[r.Name] {
+			continue
+		}
+		for _, record := range r.Records {
+			pii.Omit(record)
+			// Contacts have their whole name in one field
+			if r.Name == "contacts" && !pii[report.PIIName] {
+				delete(record, "name")
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/metrics"
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// App labels for metrics and logs
+const (
+	appMAPAccelerator = rostering.AppMAPAccelerator
+	appMAPGrowth      = rostering.AppMAPGrowth
+)
+
+// newRunMetrics creates the run's metrics and, if addr is set, serves them
+// at /metrics for as long as the process runs
+func newRunMetrics(logger *zap.Logger, addr string) *metrics.Metrics {
+	m := metrics.New()
+	if addr != "" {
+		go func() {
+			serveErr := m.Serve(addr)
+			logger.Error(
+				"Unable to serve metrics",
+				zap.String("addr", addr),
+				zap.Error(serveErr),
+			)
+		}()
+		logger.Info("Serving metrics", zap.String("addr", addr))
+	}
+	return m
+}
+
+// pushMetrics pushes to the Pushgateway, if there is one. Failing to push is
+// logged rather than failing a run that otherwise succeeded.
+func pushMetrics(
+	logger *zap.Logger,
+	m *metrics.Metrics,
+	pushURL, districtCleverID string,
+) {
+	if pushURL == "" {
+		return
+	}
+	pushErr := m.Push(pushURL, districtCleverID)
+	if pushErr != nil {
+		logger.Error(
+			"Unable to push metrics",
+			zap.String("url", pushURL),
+			zap.Error(pushErr),
+		)
+		return
+	}
+	logger.Info("Pushed metrics", zap.String("url", pushURL))
+}
+
+func observeRoster(
+	m *metrics.Metrics,
+	districtCleverID, app string,
+	roster *rostering.Roster,
+) {
+	counts := map[string]int{}
+	if roster.Schools != nil {
+		counts["school"] = len(*roster.Schools)
+	}
+	if roster.Students != nil {
+		counts["student"] = len(*roster.Students)
+	}
+	if roster.Teachers != nil {
+		counts["teacher"] = len(*roster.Teachers)
+	}
+	if roster.Sections != nil {
+		counts["section"] = len(*roster.Sections)
+	}
+	if roster.DistrictAdmins != nil {
+		counts["district_admin"] = len(*roster.DistrictAdmins)
+	}
+	if roster.SchoolAdmins != nil {
+		counts["school_admin"] = len(*roster.SchoolAdmins)
+	}
+	for entity, n := range counts {
+		m.SetRecordsFetched(districtCleverID, app, entity, n)
+	}
+}
+
+func observeReport(m *metrics.Metrics, missingReport *report.MissingReport) {
+	district := missingReport.DistrictCleverID
+	m.SetDiscrepancies(district, "student", len(missingReport.MissingStudents))
+	m.SetDiscrepancies(district, "teacher", len(missingReport.MissingTeachers))
+	m.SetDiscrepancies(district, "school", len(missingReport.MissingSchools))
+	m.SetDiscrepancies(district, "section", len(missingReport.MissingSections))
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/config"
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+func RevealCommand(logger *zap.Logger) *Command {
+	flags := &revealFlags{client: newClientFlags()}
+	cmd := &Command{
+		UsageLine: "reveal -district=${ID} [flags] [pseudonym ...]",
+		Short:     "Map report pseudonyms back to Clever IDs",
+		Long: "Map pseudonyms from a report redacted with hash back to the " +
+			"Clever IDs of the students and teachers they were made from. " +
+			"Pseudonyms are given by -pseudonym or as arguments. Needs the " +
+			"district's Clever credentials and the same " +
+			report.PseudonymKeyEnv + " the report was made with.",
+		Logger: logger,
+	}
+	flags.register(&cmd.Flag)
+	cmd.takeConfig((*config.Config).HTTPFlags)
+	cmd.Run = func(cmd *Command, args []string) error {
+		for _, arg := range args {
+			_ = flags.pseudonyms.Set(arg)
+		}
+		if flags.districtCleverID == "" {
+			return usageErrorf(cmd, "-district ${ID} is a required argument")
+		}
+		if len(flags.pseudonyms) == 0 {
+			return usageErrorf(cmd, "at least one pseudonym is required")
+		}
+		return Reveal(cmd.Logger, flags)
+	}
+	return cmd
+}
+
+// revealFlags are the flags of the reveal command
+type revealFlags struct {
+	districtCleverID string
+
+	pseudonyms stringList
+
+	client *clientFlags
+}
+
+func (f *revealFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.districtCleverID, "district", "", "District Clever ID")
+	fs.Var(
+		&f.pseudonyms,
+		"pseudonym",
+		"Pseudonym to reveal, e.g. sis_id:9f86d081884c7d65, repeatable",
+	)
+	f.client.register(fs)
+}
+
+func Reveal(logger *zap.Logger, f *revealFlags) (err error) {
+	logger = districtLogger(logger, f.districtCleverID)
+	districtCleverID := f.districtCleverID
+	pseudonyms := f.pseudonyms
+
+	if clientErr := f.client.validate(); clientErr != nil {
+		return clientErr
+	}
+	key := report.PseudonymKeyFromEnv()
+	if len(key) == 0 {
+		return fmt.Errorf("%s is required", report.PseudonymKeyEnv)
+	}
+
+	ctx := context.Background() //nolint:ka-context // GKE ≠ AppEngine
+	session, sessionErr := f.client.start(logger, districtCleverID)
+	if sessionErr != nil {
+		return sessionErr
+	}
+	defer func() {
+		session.finish(err)
+	}()
+
+	// Report details come from the MAP Accelerator roster, so pseudonyms do
+	// too
+	cleverClient, clientErr := rostering.GetCleverClient(
+		ctx,
+		logger,
+		districtCleverID,
+		false,
+		session.Options...,
+	)
+	if clientErr != nil {
+		return clientErr
+	}
+	roster, rosterErr := rostering.GetRoster(
+		ctx,
+		logger,
+		cleverClient,
+		session.PageSize,
+	)
+	if rosterErr != nil {
+		return rosterErr
+	}
+
+	matches := report.RevealPseudonyms(roster, key, pseudonyms)
+	found := map[string]bool{}
+	for _, match := range matches {
+		found[match.Pseudonym] = true
+		fmt.Fprintf(
+			os.Stdout,
+			"%s\t%s\t%s\t%s\n",
+			match.Pseudonym,
+			match.Type,
+			match.Field,
+			match.CleverID,
+		)
+	}
+	for _, pseudonym := range pseudonyms {
+		if !found[pseudonym] {
+			logger.Warn(
+				"No student or teacher has this pseudonym",
+				zap.String("pseudonym", pseudonym),
+			)
+		}
+	}
+	return nil
+}
+
+// stringList is a repeatable flag.Value, which also accepts comma separated
+// lists
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
@@ -1,296 +1,533 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/Khan/clever-repartee/pkg/config"
+	"github.com/Khan/clever-repartee/pkg/districts"
 	"github.com/Khan/clever-repartee/pkg/generated"
 	"github.com/Khan/clever-repartee/pkg/mail"
+	"github.com/Khan/clever-repartee/pkg/report"
 	"github.com/Khan/clever-repartee/pkg/rostering"
 )
 
 func DiffCommand(logger *zap.Logger) *Command {
+	flags := newDiffFlags()
 	cmd := &Command{
-		UsageLine: "diff",
+		UsageLine: "diff -district=${ID} [flags]",
 		Short:     "Compare a district's roster via two different Clever apps",
-		Long:      "Compare a district's roster with Clever ID for -district flag via two different Clever apps",
-		Run:       Diff,
-		Logger:    logger,
+		Long: "Compare the roster of the district with the Clever ID given " +
+			"by -district via two different Clever apps, MAP Accelerator " +
+			"and MAP Growth. Emails a summary of the records missing from " +
+			"MAP Growth and optionally writes the full report to local disk.",
+		Logger: logger,
+	}
+	flags.register(&cmd.Flag)
+	cmd.takeConfig(diffConfigFlags)
+	cmd.Run = func(cmd *Command, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf(cmd, "unexpected arguments: %s", args)
+		}
+		if flags.districtCleverID == "" {
+			return usageErrorf(cmd, "-district ${ID} is a required argument")
+		}
+		if notifyErr := validateNotifyMode(flags.notifyMode); notifyErr != nil {
+			return &UsageError{Command: cmd, Err: notifyErr}
+		}
+		return Diff(cmd.Logger, flags)
 	}
 	return cmd
 }
 
-func Diff(cmd *Command, _ []string) error {
-	var districtCleverID string
+// diffConfigFlags are the config file settings of commands that run diffs
+func diffConfigFlags(c *config.Config) []config.Setting {
+	return append(c.OutputFlags(), c.HTTPFlags()...)
+}
+
+// diffFlags are the flags of the diff command
+type diffFlags struct {
+	districtCleverID string
+
+	writeJson bool
+
+	formats report.FormatList
+
+	outDir string
+
+	summaryRows int
+
+	highlightPercent float64
+
+	maxSyncAge time.Duration
+
+	subjectTemplate, textTemplate, htmlTemplate string
+
+	notifyMode string
+
+	piiFields report.PIIFields
+
+	redactEmail report.RedactionPolicy
+	redactFiles report.RedactionPolicy
+
+	client *clientFlags
+}
+
+func newDiffFlags() *diffFlags {
+	f := &diffFlags{
+		piiFields:   report.PIIFields{},
+		redactEmail: report.DefaultEmailRedaction(),
+		redactFiles: report.RedactionPolicy{},
+		client:      newClientFlags(),
+	}
+	for _, field := range report.DefaultPIIFields {
+		f.piiFields[field] = true
+	}
+	return f
+}
+
+func (f *diffFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.districtCleverID, "district", "", "District Clever ID")
+	f.registerRun(fs)
+}
+
+// registerRun registers every flag but -district, for commands that run
+// diffs of districts they choose themselves
+func (f *diffFlags) registerRun(fs *flag.FlagSet) {
+	fs.BoolVar(&f.writeJson, "json", false, "Same as -format=json")
+	fs.Var(
+		&f.formats,
+		"format",
+		"Write the report to local disk in this format, repeatable: "+
+			strings.Join(report.Formats(), ", "),
+	)
+	fs.StringVar(&f.outDir, "out", ".", "Directory to write report files to")
+	fs.IntVar(
+		&f.summaryRows,
+		"summary-rows",
+		mail.DefaultSummaryRows,
+		"Max records of each type listed in the email body, -1 for all",
+	)
+	fs.Float64Var(
+		&f.highlightPercent,
+		"highlight-percent",
+		report.DefaultHighlightPercent,
+		"Highlight schools missing at least this percent of their roster",
+	)
+	fs.DurationVar(
+		&f.maxSyncAge,
+		"max-sync-age",
+		districts.DefaultMaxSyncAge,
+		"Fail the sync health check of a district that last synced longer "+
+			"ago than this, 0 to skip the health checks",
+	)
+	fs.Var(
+		f.piiFields,
+		"pii",
+		"PII fields to collect for every output: comma separated list of "+
+			"name, sis_id, number, email and dob, or all or none",
+	)
+	fs.Var(
+		f.redactEmail,
+		"redact-email",
+		"Redaction of the -pii fields in the summary email and its "+
+			"attachments: comma separated field=keep|drop|mask|hash in "+
+			"order, e.g. mask,name=hash, or keep for full details",
+	)
+	fs.Var(
+		f.redactFiles,
+		"redact-files",
+		"Redaction of the -pii fields in the -format report files, as for "+
+			"-redact-email",
+	)
+
+	fs.StringVar(
+		&f.subjectTemplate,
+		"subject-template",
+		"",
+		"text/template file for the email subject",
+	)
+	fs.StringVar(
+		&f.textTemplate,
+		"text-template",
+		"",
+		"text/template file for the plain text email body",
+	)
+	fs.StringVar(
+		&f.htmlTemplate,
+		"html-template",
+		"",
+		"html/template file for the HTML email body",
+	)
+
+	fs.StringVar(
+		&f.notifyMode,
+		"notify",
+		notifyEmail,
+		"How to deliver the summary email: email sends it, preview writes "+
+			"it to an .eml file in -out instead, none skips it",
+	)
+
+	f.client.register(fs)
+}
+
+func Diff(logger *zap.Logger, f *diffFlags) error {
+	plan, planErr := f.prepare()
+	if planErr != nil {
+		return planErr
+	}
+	_, err := f.run(
+		context.Background(), //nolint:ka-context // GKE ≠ AppEngine
+		districtLogger(logger, f.districtCleverID),
+		plan,
+		f.districtCleverID,
+	)
+	return err
+}
 
-	var writeJson bool
+// diffPlan is what every run of the diff flags needs, checked once up front
+type diffPlan struct {
+	templates    *mail.Templates
+	pseudonymKey []byte
+	formats      report.FormatList
+}
 
-	flag.StringVar(&districtCleverID, "district", "", "District Clever ID")
-	flag.BoolVar(&writeJson, "json", false, "Write JSON files to local disk")
+// prepare checks the flags and loads the templates, before any time is
+// spent fetching rosters
+func (f *diffFlags) prepare() (*diffPlan, error) {
+	if notifyErr := validateNotifyMode(f.notifyMode); notifyErr != nil {
+		return nil, notifyErr
+	}
 
-	flagErr := flag.CommandLine.Parse(getFlags())
-	if flagErr != nil {
-		return flagErr
+	if clientErr := f.client.validate(); clientErr != nil {
+		return nil, clientErr
 	}
 
-	if districtCleverID == "" {
-		return fmt.Errorf("-district ${ID} is a required argument")
+	templates, templatesErr := mail.LoadTemplates(
+		f.subjectTemplate,
+		f.textTemplate,
+		f.htmlTemplate,
+	)
+	if templatesErr != nil {
+		return nil, templatesErr
 	}
 
-	logger := cmd.Logger
+	pseudonymKey := report.PseudonymKeyFromEnv()
+	keyErr := checkPseudonymKey(pseudonymKey, f.redactEmail, f.redactFiles)
+	if keyErr != nil {
+		return nil, keyErr
+	}
 
-	logger.Info(
-		fmt.Sprintf(
-			"Processing district with clever ID %s!\n",
-			districtCleverID,
-		))
+	formats := f.formats
+	if f.writeJson {
+		formats = append(report.FormatList{}, formats...)
+		_ = formats.Set("json")
+	}
+	return &diffPlan{
+		templates:    templates,
+		pseudonymKey: pseudonymKey,
+		formats:      formats,
+	}, nil
+}
 
-	var districtName string
+// checkPseudonymKey fails if any of the policies makes pseudonyms without a
+// key to make them with
+func checkPseudonymKey(key []byte, policies ...report.RedactionPolicy) error {
+	for _, policy := range policies {
+		if policy.NeedsKey() && len(key) == 0 {
+			return fmt.Errorf(
+				"redaction %s needs a key in %s",
+				policy,
+				report.PseudonymKeyEnv,
+			)
+		}
+	}
+	return nil
+}
+
+// run diffs one district, returning the full report before any redaction.
+// The flags are only read, so runs of different districts can share them.
+// The logger should already log with the district.
+func (f *diffFlags) run(
+	ctx context.Context,
+	logger *zap.Logger,
+	plan *diffPlan,
+	districtCleverID string,
+) (result *report.MissingReport, err error) {
+	runAt := time.Now()
+
+	session, sessionErr := f.client.start(logger, districtCleverID)
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	defer func() {
+		session.finish(err)
+	}()
+	runMetrics := session.Metrics
+	clientOpts := session.Options
+
+	logger.Info("Processing district")
+
+	var district generated.District
 	mapAcceleratorCleverClient, mapAcceleratorClientErr := rostering.GetCleverClient(
+		ctx,
 		logger,
 		districtCleverID,
 		false,
+		clientOpts...,
 	)
 	if mapAcceleratorClientErr != nil {
-		return mapAcceleratorClientErr
+		return nil, mapAcceleratorClientErr
 	}
 
-	mapAcceleratorRoster, mapAcceleratorRosterErr := GetRoster(
+	mapAcceleratorRoster, mapAcceleratorRosterErr := rostering.GetRoster(
+		ctx,
 		logger,
 		mapAcceleratorCleverClient,
+		session.PageSize,
 	)
 	if mapAcceleratorRosterErr != nil {
-		return mapAcceleratorRosterErr
+		return nil, mapAcceleratorRosterErr
 	}
+	observeRoster(
+		runMetrics,
+		districtCleverID,
+		appMAPAccelerator,
+		mapAcceleratorRoster,
+	)
 
 	mapGrowthCleverClient, mapGrowthClientErr := rostering.GetCleverClient(
+		ctx,
 		logger,
 		districtCleverID,
 		true,
+		clientOpts...,
 	)
 	if mapGrowthClientErr != nil {
-		return mapGrowthClientErr
+		return nil, mapGrowthClientErr
 	}
 
-	mapGrowthRoster, mapGrowthRosterErr := GetRoster(
+	mapGrowthRoster, mapGrowthRosterErr := rostering.GetRoster(
+		ctx,
 		logger,
 		mapGrowthCleverClient,
+		session.PageSize,
 	)
 	if mapGrowthRosterErr != nil {
-		return mapGrowthRosterErr
+		return nil, mapGrowthRosterErr
 	}
-	for i := range *mapAcceleratorRoster.districts {
-		if mapAcceleratorRoster.districts != nil {
-			if (*mapAcceleratorRoster.districts)[i].Name != nil {
-				districtName = *(*mapAcceleratorRoster.districts)[i].Name
+	observeRoster(runMetrics, districtCleverID, appMAPGrowth, mapGrowthRoster)
+	var districtName string
+	for i := range *mapAcceleratorRoster.Districts {
+		if mapAcceleratorRoster.Districts != nil {
+			district = (*mapAcceleratorRoster.Districts)[i]
+			if district.Name != nil {
+				districtName = *district.Name
 			}
 		}
 	}
 
-	missingStudents := findMissingStudents(
-		mapGrowthRoster,
-		mapAcceleratorRoster,
-	)
-	missingTeachers := findMissingTeachers(
+	missingReport := report.NewMissingReport(
+		districtName,
+		districtCleverID,
 		mapGrowthRoster,
 		mapAcceleratorRoster,
+		report.Options{
+			PII:              f.piiFields,
+			HighlightPercent: f.highlightPercent,
+			MaxSyncAge:       f.maxSyncAge,
+			Now:              runAt,
+		},
 	)
-	missingSchools := findMissingSchools(mapGrowthRoster, mapAcceleratorRoster)
-
-	missingReport := mail.MissingReport{
-		DistrictName:            districtName,
-		DistrictCleverID:        districtCleverID,
-		MissingStudentCleverIDs: missingStudents,
-		MissingTeacherCleverIDs: missingTeachers,
-		MissingSchoolCleverIDs:  missingSchools,
+	for _, check := range missingReport.FailedHealthChecks() {
+		logger.Warn(
+			"Sync health check failed",
+			zap.String("check", check.Check),
+			zap.String("app", check.App),
+			zap.String("detail", check.Detail),
+		)
 	}
 
-	fromEmail := os.Getenv("FROM_EMAIL") //gmail account
-	toEmail := os.Getenv("TO_EMAIL")
-	password := os.Getenv("GMAIL_PASSWORD")
-	host := "smtp.gmail.com"
-	port := "587"
-	subject := "=?utf-8?Q?=F0=9F=95=B5=EF=B8=8F?= Clever Discrepancy Report"
+	missingReport.CircuitBreakers = session.trippedBreakers()
+	observeReport(runMetrics, missingReport)
 
-	bodyMessage, bodyErr := mail.NewSummaryMailBody(&missingReport)
-	if bodyErr != nil {
-		logger.Error(
-			"Unable to compose summary email message body",
-			zap.Error(bodyErr),
-		)
+	emailReport, emailReportErr := f.redactEmail.Apply(
+		missingReport,
+		plan.pseudonymKey,
+	)
+	if emailReportErr != nil {
+		return nil, emailReportErr
 	}
-	mailErr := mail.Mail(
-		fromEmail,
-		toEmail,
-		password,
-		host,
-		port,
-		subject,
-		bodyMessage,
+	fileReport, fileReportErr := f.redactFiles.Apply(
+		missingReport,
+		plan.pseudonymKey,
 	)
-	if mailErr != nil {
-		logger.Error(
-			"Unable to send summary email message",
-			zap.Error(bodyErr),
-		)
+	if fileReportErr != nil {
+		return nil, fileReportErr
 	}
 
+	prefix := report.FilePrefix(districtCleverID, runAt)
 	// For local testing/debugging since transient files will be lost in
 	// GKE job
-	if writeJson {
-		jsonWriteErr := writeDistrictToJSON(
-			&missingReport,
+	if len(plan.formats) > 0 {
+		paths, writeErr := report.WriteAll(
+			f.outDir,
+			prefix,
+			fileReport,
+			plan.formats,
 		)
-		if jsonWriteErr != nil {
-			return jsonWriteErr
+		for _, path := range paths {
+			logger.Info("Wrote report file", zap.String("path", path))
 		}
-	}
-
-	return nil
-}
-
-// note not defensively nil safe, but in practice probably ok?
-func findMissingStudents(
-	mapGrowthRoster *Roster,
-	mapAcceleratorRoster *Roster,
-) []string {
-	mapGrowthStudentIDmap := map[string]bool{}
-	for i := range *mapGrowthRoster.students {
-		mapGrowthStudent := (*mapGrowthRoster.students)[i]
-		mapGrowthStudentIDmap[*mapGrowthStudent.Id] = true
-	}
-	var missingStudents []string
-	for i := range *mapAcceleratorRoster.students {
-		mapAcceleratorStudent := (*mapAcceleratorRoster.students)[i]
-		if _, ok := mapGrowthStudentIDmap[*mapAcceleratorStudent.Id]; !ok {
-			missingStudents = append(missingStudents, *mapAcceleratorStudent.Id)
+		if writeErr != nil {
+			return nil, writeErr
 		}
 	}
-	return missingStudents
-}
 
-// note not defensively nil safe, but in practice probably ok?
-func findMissingTeachers(
-	mapGrowthRoster *Roster,
-	mapAcceleratorRoster *Roster,
-) []string {
-	mapGrowthTeacherIDmap := map[string]bool{}
-	for i := range *mapGrowthRoster.teachers {
-		mapGrowthTeacher := (*mapGrowthRoster.teachers)[i]
-		mapGrowthTeacherIDmap[*mapGrowthTeacher.Id] = true
-	}
-	var missing []string
-	for i := range *mapAcceleratorRoster.teachers {
-		mapAcceleratorTeacher := (*mapAcceleratorRoster.teachers)[i]
-		if _, ok := mapGrowthTeacherIDmap[*mapAcceleratorTeacher.Id]; !ok {
-			missing = append(missing, *mapAcceleratorTeacher.Id)
-		}
+	notifyErr := notify(
+		logger,
+		f.notifyMode,
+		district,
+		emailReport,
+		plan.templates,
+		f.summaryRows,
+		filepath.Join(f.outDir, prefix+".eml"),
+	)
+	if notifyErr != nil {
+		return nil, notifyErr
 	}
-	return missing
-}
 
-// note not defensively nil safe, but in practice probably ok?
-func findMissingSchools(
-	mapGrowthRoster *Roster,
-	mapAcceleratorRoster *Roster,
-) []string {
-	mapGrowthSchoolIDmap := map[string]bool{}
-	for i := range *mapGrowthRoster.schools {
-		mapGrowthSchool := (*mapGrowthRoster.schools)[i]
-		mapGrowthSchoolIDmap[*mapGrowthSchool.Id] = true
-	}
-	var missing []string
-	for i := range *mapAcceleratorRoster.schools {
-		mapAcceleratorSchool := (*mapAcceleratorRoster.schools)[i]
-		if _, ok := mapGrowthSchoolIDmap[*mapAcceleratorSchool.Id]; !ok {
-			missing = append(missing, *mapAcceleratorSchool.Id)
-		}
-	}
-	return missing
+	return missingReport, nil
 }
 
-func writeDistrictToJSON(report *mail.MissingReport) error {
-	file, err := json.MarshalIndent(*report, "", " ")
-	if err != nil {
-		return err
-	}
+const (
+	notifyEmail   = "email"
+	notifyPreview = "preview"
+	notifyNone    = "none"
+)
 
-	err = ioutil.WriteFile((*report).DistrictCleverID+".json", file, 0644)
-	if err != nil {
-		return err
+func validateNotifyMode(mode string) error {
+	switch mode {
+	case notifyEmail, notifyPreview, notifyNone:
+		return nil
 	}
-
-	return nil
+	return fmt.Errorf("invalid -notify %q, must be email, preview or none", mode)
 }
 
-func GetRoster(
+// notify sends the summary email, or in preview mode writes it to
+// previewPath. Failing to send is logged rather than returned, but a preview
+// is asked for explicitly, so failing to write one is an error, as is a body
+// that can't be composed, which is neither sent nor previewed.
+func notify(
 	logger *zap.Logger,
-	clientClever *generated.Client,
-) (*Roster, error) {
-	roster := Roster{}
-
-	districts, distErr := rostering.GetCleverDistricts(clientClever)
-	if distErr != nil {
-		return nil, distErr
+	mode string,
+	district generated.District,
+	missingReport *report.MissingReport,
+	templates *mail.Templates,
+	summaryRows int,
+	previewPath string,
+) error {
+	if mode == notifyNone {
+		return nil
 	}
-	roster.districts = districts
 
-	schools, schoolErr := rostering.GetCleverSchools(clientClever, 1000)
-	if schoolErr != nil {
-		return nil, schoolErr
+	subject, textBody, htmlBody, bodyErr := mail.NewSummaryMail(
+		templates,
+		missingReport,
+		summaryRows,
+	)
+	if bodyErr != nil {
+		return fmt.Errorf(
+			"unable to compose summary email message body: %w",
+			bodyErr,
+		)
 	}
-	roster.schools = schools
-
-	students, studentErr := rostering.GetCleverStudents(clientClever, 1000)
-	if studentErr != nil {
-		return nil, studentErr
+	attachments, attachmentsErr := mail.NewMissingReportAttachments(
+		missingReport,
+	)
+	if attachmentsErr != nil {
+		logger.Error(
+			"Unable to compose summary email attachments",
+			zap.Error(attachmentsErr),
+		)
 	}
-	roster.students = students
 
-	teachers, teachErr := rostering.GetCleverTeachers(clientClever, 1000)
-	if teachErr != nil {
-		return nil, teachErr
+	routes, routesErr := mail.RoutingTableFromEnv()
+	if routesErr != nil {
+		logger.Error(
+			"Unable to configure summary email recipients",
+			zap.Error(routesErr),
+		)
+		if mode == notifyPreview {
+			return routesErr
+		}
+		return nil
 	}
-	roster.teachers = teachers
+	recipients := routes.Resolve(district)
 
-	districtAdmins, distAdmErr := rostering.GetCleverDistrictAdmins(
-		clientClever,
-		1000,
-	)
-	if distAdmErr != nil {
-		return nil, distAdmErr
+	if mode == notifyPreview {
+		mailConfig, mailConfigErr := mail.SenderConfigFromEnv()
+		if mailConfigErr != nil {
+			return mailConfigErr
+		}
+		message := mail.NewMessage(
+			mailConfig,
+			recipients,
+			subject,
+			textBody,
+			htmlBody,
+		)
+		message.Attachments = attachments
+		dirErr := os.MkdirAll(filepath.Dir(previewPath), 0755)
+		if dirErr != nil {
+			return dirErr
+		}
+		previewErr := mail.Preview(message, previewPath, os.Stdout)
+		if previewErr != nil {
+			return previewErr
+		}
+		logger.Info(
+			"Wrote summary email preview",
+			zap.String("path", previewPath),
+		)
+		return nil
 	}
-	roster.districtAdmins = districtAdmins
 
-	schoolAdmins, schoolAdminErr := rostering.GetCleverSchoolAdmins(
-		clientClever,
-		1000,
-	)
-	if schoolAdminErr != nil {
-		return nil, schoolAdminErr
+	mailConfig, mailConfigErr := mail.ConfigFromEnv()
+	if mailConfigErr != nil {
+		logger.Error(
+			"Unable to configure summary email",
+			zap.Error(mailConfigErr),
+		)
+		return nil
 	}
-	roster.schoolAdmins = schoolAdmins
-
-	sections, sectionErr := rostering.GetCleverSections(clientClever, 1000)
-	if sectionErr != nil {
-		return nil, sectionErr
+	logger.Info(
+		"Sending summary email",
+		zap.Strings("to", recipients.To),
+		zap.Strings("cc", recipients.Cc),
+		zap.Int("bcc", len(recipients.Bcc)),
+	)
+	message := mail.NewMessage(
+		mailConfig,
+		recipients,
+		subject,
+		textBody,
+		htmlBody,
+	)
+	message.Attachments = attachments
+	mailErr := mail.Mail(mailConfig, message)
+	if mailErr != nil {
+		logger.Error(
+			"Unable to send summary email message",
+			zap.Error(mailErr),
+		)
 	}
-	roster.sections = sections
-
-	return &roster, nil
-}
-
-type Roster struct {
-	districts      *[]generated.District
-	schools        *[]generated.School
-	students       *[]generated.Student
-	teachers       *[]generated.Teacher
-	districtAdmins *[]generated.DistrictAdmin
-	schoolAdmins   *[]generated.SchoolAdmin
-	sections       *[]generated.Section
+	return nil
 }
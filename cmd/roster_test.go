@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"text/template"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/mail"
+	"github.com/Khan/clever-repartee/pkg/report"
+)
+
+// diff.cassette.json is a run against a district whose MAP Growth roster is
+// missing one of MAP Accelerator's students, which is on the second page of
+// students, along with one teacher and one section
+const (
+	testDistrictID = "5f1e2d3c4b5a69788796a5b4"
+	testCassette   = "testdata/diff.cassette.json"
+)
+
+func TestDiffReplay(t *testing.T) {
+	outDir, dirErr := ioutil.TempDir("", "diff")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(outDir)
+
+	f := newDiffFlags()
+	f.districtCleverID = testDistrictID
+	f.notifyMode = notifyNone
+	f.outDir = outDir
+	f.maxSyncAge = 0
+	f.client.replayPath = testCassette
+	if setErr := f.formats.Set("json"); setErr != nil {
+		t.Fatal(setErr)
+	}
+
+	if diffErr := Diff(zap.NewNop(), f); diffErr != nil {
+		t.Fatalf("Diff() error = %v", diffErr)
+	}
+
+	paths, globErr := filepath.Glob(filepath.Join(outDir, "*.json"))
+	if globErr != nil {
+		t.Fatal(globErr)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("Diff() wrote %d JSON reports, want 1", len(paths))
+	}
+	content, readErr := ioutil.ReadFile(paths[0])
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	var got report.MissingReport
+	if jsonErr := json.Unmarshal(content, &got); jsonErr != nil {
+		t.Fatalf("unable to read report: %v", jsonErr)
+	}
+
+	if got.DistrictName != "Springfield Unified" ||
+		got.DistrictCleverID != testDistrictID {
+		t.Errorf(
+			"district = %q %q, want Springfield Unified %s",
+			got.DistrictName,
+			got.DistrictCleverID,
+			testDistrictID,
+		)
+	}
+	tests := []struct {
+		name string
+		got  []report.Discrepancy
+		want []report.Discrepancy
+	}{
+		{
+			name: "students",
+			got:  got.MissingStudents,
+			want: []report.Discrepancy{{
+				CleverID: "5f1e2d3c4b5a69788796b003",
+				Name:     "Grace Hopper",
+				SisID:    "1003",
+				Number:   "S1003",
+				SchoolID: "5f1e2d3c4b5a69788796a002",
+				School:   "Roosevelt Middle",
+				Grade:    "7",
+			}},
+		},
+		{
+			name: "teachers",
+			got:  got.MissingTeachers,
+			want: []report.Discrepancy{{
+				CleverID: "5f1e2d3c4b5a69788796c002",
+				Name:     "Maria Montessori",
+				SisID:    "2002",
+				Number:   "T2002",
+				SchoolID: "5f1e2d3c4b5a69788796a002",
+				School:   "Roosevelt Middle",
+			}},
+		},
+		{
+			name: "schools",
+			got:  got.MissingSchools,
+		},
+		{
+			name: "sections",
+			got:  got.MissingSections,
+			want: []report.Discrepancy{{
+				CleverID: "5f1e2d3c4b5a69788796f002",
+				Name:     "Science 7",
+				SisID:    "3002",
+				Number:   "S7",
+				SchoolID: "5f1e2d3c4b5a69788796a002",
+				School:   "Roosevelt Middle",
+				Grade:    "7",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("missing %s = %+v, want %+v", tt.name, tt.got, tt.want)
+		}
+	}
+	if len(got.CircuitBreakers) != 0 {
+		t.Errorf("circuit breakers = %+v, want none", got.CircuitBreakers)
+	}
+}
+
+func TestDiffReplayExhausted(t *testing.T) {
+	f := newDiffFlags()
+	f.districtCleverID = "5f1e2d3c4b5a69788796a5b5"
+	f.notifyMode = notifyNone
+	f.client.replayPath = testCassette
+
+	if diffErr := Diff(zap.NewNop(), f); diffErr == nil {
+		t.Error("Diff() of a district not in the cassette succeeded")
+	}
+}
+
+func TestNotifyBodyError(t *testing.T) {
+	outDir, dirErr := ioutil.TempDir("", "notify")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(outDir)
+
+	// The template refers to a field the summary doesn't have
+	templates := mail.DefaultTemplates()
+	templates.Subject = template.Must(
+		template.New("subject").Parse("{{.Nope}}"),
+	)
+	previewPath := filepath.Join(outDir, "summary.eml")
+	notifyErr := notify(
+		zap.NewNop(),
+		notifyPreview,
+		generated.District{},
+		&report.MissingReport{},
+		templates,
+		mail.DefaultSummaryRows,
+		previewPath,
+	)
+	if notifyErr == nil {
+		t.Error("notify() with a broken template succeeded")
+	}
+	if _, statErr := os.Stat(previewPath); !os.IsNotExist(statErr) {
+		t.Errorf("preview written with a broken template: %v", statErr)
+	}
+}
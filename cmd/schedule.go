@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/districts"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+	"github.com/Khan/clever-repartee/pkg/schedule"
+)
+
+func ScheduleCommand(logger *zap.Logger) *Command {
+	flags := &scheduleFlags{diff: newDiffFlags()}
+	cmd := &Command{
+		UsageLine: "schedule -file=${PATH} [flags]",
+		Short:     "Run diffs of districts on cron schedules",
+		Long: "Run diffs of districts in-process on the cron schedules of the " +
+			"YAML file given by -file, until SIGTERM or SIGINT. Each run is " +
+			"delayed by up to the schedule's jitter, at most the schedule's " +
+			"concurrency run at once, a run is skipped if the district's " +
+			"last one is still going, and a run is skipped while the " +
+			"district's sync is paused in either app. Schedules can name " +
+			"the district lists of the -config file as groups. Each diff is " +
+			"run as the diff command would with the flags given here.",
+		Logger:   logger,
+		ManyRuns: true,
+	}
+	flags.register(&cmd.Flag)
+	cmd.takeConfig(diffConfigFlags)
+	cmd.Run = func(cmd *Command, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf(cmd, "unexpected arguments: %s", args)
+		}
+		if flags.file == "" {
+			return usageErrorf(cmd, "-file ${PATH} is a required argument")
+		}
+		if runsErr := flags.diff.client.validateManyRuns(); runsErr != nil {
+			return &UsageError{Command: cmd, Err: runsErr}
+		}
+		if notifyErr := validateNotifyMode(flags.diff.notifyMode); notifyErr != nil {
+			return &UsageError{Command: cmd, Err: notifyErr}
+		}
+		if cmd.Config != nil {
+			flags.groups = cmd.Config.Districts
+		}
+		return Schedule(cmd.Logger, flags)
+	}
+	return cmd
+}
+
+// scheduleFlags are the flags of the schedule command
+type scheduleFlags struct {
+	file string
+
+	// groups are the config file's district lists
+	groups map[string][]string
+
+	shutdownTimeout time.Duration
+
+	diff *diffFlags
+}
+
+func (f *scheduleFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.file, "file", "", "YAML schedule file")
+	fs.DurationVar(
+		&f.shutdownTimeout,
+		"shutdown-timeout",
+		defaultShutdownTimeout,
+		"How long to wait for running diffs when shutting down, before "+
+			"cancelling them",
+	)
+	f.diff.registerRun(fs)
+}
+
+func Schedule(logger *zap.Logger, f *scheduleFlags) error {
+	file, fileErr := schedule.Load(f.file, f.groups)
+	if fileErr != nil {
+		return fileErr
+	}
+	plan, planErr := f.diff.prepare()
+	if planErr != nil {
+		return planErr
+	}
+
+	scheduler, schedulerErr := schedule.New(logger, file, schedule.Config{
+		Diff: func(
+			ctx context.Context,
+			logger *zap.Logger,
+			district string,
+		) error {
+			_, err := f.diff.run(ctx, logger, plan, district)
+			return err
+		},
+		Paused: func(
+			ctx context.Context,
+			logger *zap.Logger,
+			district string,
+		) (bool, error) {
+			return districtPaused(ctx, logger, f.diff.client, district)
+		},
+	})
+	if schedulerErr != nil {
+		return schedulerErr
+	}
+	scheduler.Start()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	sig := <-signals
+	logger.Info("Shutting down", zap.String("signal", sig.String()))
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		f.shutdownTimeout,
+	)
+	defer cancel()
+	return scheduler.Stop(ctx)
+}
+
+// districtPaused is true if the district's sync is paused in either app
+func districtPaused(
+	ctx context.Context,
+	logger *zap.Logger,
+	f *clientFlags,
+	district string,
+) (bool, error) {
+	// The check isn't a run of its own, so it pushes no metrics that would
+	// replace the district's last run
+	checkFlags := *f
+	checkFlags.metricsPushURL = ""
+	session, sessionErr := checkFlags.start(logger, district)
+	if sessionErr != nil {
+		return false, sessionErr
+	}
+	defer session.finish(nil)
+	now := time.Now()
+	for _, app := range []string{appMAPAccelerator, appMAPGrowth} {
+		isMAP, _ := parseApp(app)
+		cleverClient, clientErr := rostering.GetCleverClient(
+			ctx,
+			logger,
+			district,
+			isMAP,
+			session.Options...,
+		)
+		if clientErr != nil {
+			return false, clientErr
+		}
+		fetched, fetchErr := rostering.GetCleverDistricts(ctx, cleverClient)
+		if fetchErr != nil {
+			return false, fetchErr
+		}
+		for _, d := range *fetched {
+			if districts.Paused(d, now) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/districts"
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/server"
+)
+
+// serveTokenEnv holds the bearer token API requests must carry
+const serveTokenEnv = "SERVE_TOKEN"
+
+// defaultShutdownTimeout is how long a shutdown waits for running diffs,
+// within the 30s Kubernetes gives a pod by default
+const defaultShutdownTimeout = 25 * time.Second
+
+func ServeCommand(logger *zap.Logger) *Command {
+	flags := &serveFlags{
+		redactAPI: report.DefaultEmailRedaction(),
+		diff:      newDiffFlags(),
+	}
+	cmd := &Command{
+		UsageLine: "serve [flags]",
+		Short:     "Serve an HTTP API that runs diffs on demand",
+		Long: "Serve an HTTP API on -addr, by default the port in $PORT. " +
+			"POST /diffs with {\"district\": ID} queues a diff of the " +
+			"district and returns its job, GET /diffs/{id} is the job's " +
+			"status and GET /diffs/{id}/report its report, redacted by " +
+			"-redact-api. GET /districts lists the connected districts. " +
+			"/healthz and /readyz are for Kubernetes probes. Each diff is " +
+			"run as the diff command would with the flags given here. On " +
+			"SIGTERM or SIGINT the server stops taking diffs and waits up " +
+			"to -shutdown-timeout for the running ones, then cancels them.",
+		Logger:   logger,
+		ManyRuns: true,
+	}
+	flags.register(&cmd.Flag)
+	cmd.takeConfig(diffConfigFlags)
+	cmd.Run = func(cmd *Command, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf(cmd, "unexpected arguments: %s", args)
+		}
+		if runsErr := flags.diff.client.validateManyRuns(); runsErr != nil {
+			return &UsageError{Command: cmd, Err: runsErr}
+		}
+		if notifyErr := validateNotifyMode(flags.diff.notifyMode); notifyErr != nil {
+			return &UsageError{Command: cmd, Err: notifyErr}
+		}
+		flags.token = os.Getenv(serveTokenEnv)
+		if flags.token == "" {
+			return usageErrorf(
+				cmd,
+				"$%s must be set to the bearer token API requests carry",
+				serveTokenEnv,
+			)
+		}
+		return Serve(cmd.Logger, flags)
+	}
+	return cmd
+}
+
+// serveFlags are the flags of the serve command
+type serveFlags struct {
+	addr string
+
+	concurrency int
+
+	maxJobs int
+
+	shutdownTimeout time.Duration
+
+	// redactAPI redacts the reports the API returns
+	redactAPI report.RedactionPolicy
+
+	// token is read from $SERVE_TOKEN rather than a flag, so that it isn't
+	// in the process list
+	token string
+
+	diff *diffFlags
+}
+
+func (f *serveFlags) register(fs *flag.FlagSet) {
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	fs.StringVar(&f.addr, "addr", addr, "Address to listen on")
+	fs.IntVar(
+		&f.concurrency,
+		"concurrency",
+		server.DefaultConcurrency,
+		"Most diffs to run at once, the rest are queued",
+	)
+	fs.IntVar(
+		&f.maxJobs,
+		"max-jobs",
+		server.DefaultMaxJobs,
+		"Most finished jobs to remember, the oldest are forgotten first",
+	)
+	fs.DurationVar(
+		&f.shutdownTimeout,
+		"shutdown-timeout",
+		defaultShutdownTimeout,
+		"How long to wait for running diffs when shutting down, before "+
+			"cancelling them",
+	)
+	fs.Var(
+		f.redactAPI,
+		"redact-api",
+		"Redaction of the -pii fields in the reports the API returns, as "+
+			"for -redact-email",
+	)
+	f.diff.registerRun(fs)
+}
+
+func Serve(logger *zap.Logger, f *serveFlags) error {
+	plan, planErr := f.diff.prepare()
+	if planErr != nil {
+		return planErr
+	}
+	if keyErr := checkPseudonymKey(plan.pseudonymKey, f.redactAPI); keyErr != nil {
+		return keyErr
+	}
+
+	srv := server.New(logger, server.Config{
+		Diff: func(
+			ctx context.Context,
+			logger *zap.Logger,
+			district string,
+		) (*report.MissingReport, error) {
+			missingReport, err := f.diff.run(ctx, logger, plan, district)
+			if err != nil {
+				return nil, err
+			}
+			return f.redactAPI.Apply(missingReport, plan.pseudonymKey)
+		},
+		Districts: func(ctx context.Context) ([]districts.Status, error) {
+			return listDistricts(ctx, logger, f.diff)
+		},
+		Concurrency: f.concurrency,
+		MaxJobs:     f.maxJobs,
+		Token:       f.token,
+	})
+	httpServer := &http.Server{
+		Addr:    f.addr,
+		Handler: srv.Handler(),
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	logger.Info("Serving", zap.String("addr", f.addr))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		logger.Info("Shutting down", zap.String("signal", sig.String()))
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		f.shutdownTimeout,
+	)
+	defer cancel()
+	// Stop taking diffs first, so /readyz fails while the last requests are
+	// answered
+	drainErr := srv.Drain(ctx)
+	shutdownErr := httpServer.Shutdown(ctx)
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if drainErr != nil {
+		return drainErr
+	}
+	return shutdownErr
+}
+
+// listDistricts lists the districts of every app with credentials set, as
+// the districts command does
+func listDistricts(
+	ctx context.Context,
+	logger *zap.Logger,
+	f *diffFlags,
+) (statuses []districts.Status, err error) {
+	session, sessionErr := f.client.start(logger, "")
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	defer func() {
+		session.finish(err)
+	}()
+
+	apps := configuredApps(session)
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("no Clever app has credentials set")
+	}
+	return allDistricts(
+		ctx,
+		logger,
+		session,
+		apps,
+		time.Now(),
+		f.maxSyncAge,
+	)
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/config"
+	"github.com/Khan/clever-repartee/pkg/integrity"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+func ValidateCommand(logger *zap.Logger) *Command {
+	flags := &validateFlags{client: newClientFlags()}
+	cmd := &Command{
+		UsageLine: "validate -district=${ID} [flags]",
+		Short:     "Find broken references within a district's roster",
+		Long: "Fetch the roster of the district with the Clever ID given by " +
+			"-district via one Clever app, along with its terms and " +
+			"courses, and report every reference between its records that " +
+			"is to a record not in it, such as a section's teacher or term, " +
+			"a student's school or a school admin's schools, by type and " +
+			"school. Fails if any are found.",
+		Logger: logger,
+	}
+	flags.register(&cmd.Flag)
+	cmd.takeConfig((*config.Config).HTTPFlags)
+	cmd.Run = func(cmd *Command, args []string) error {
+		if len(args) > 0 {
+			return usageErrorf(cmd, "unexpected arguments: %s", args)
+		}
+		if flags.districtCleverID == "" {
+			return usageErrorf(cmd, "-district ${ID} is a required argument")
+		}
+		if _, appErr := parseApp(flags.app); appErr != nil {
+			return &UsageError{Command: cmd, Err: appErr}
+		}
+		switch flags.format {
+		case districtsFormatTable, districtsFormatJSON:
+		default:
+			return usageErrorf(
+				cmd,
+				"invalid -format %q, must be table or json",
+				flags.format,
+			)
+		}
+		return Validate(cmd.Logger, flags)
+	}
+	return cmd
+}
+
+// validateFlags are the flags of the validate command
+type validateFlags struct {
+	districtCleverID string
+
+	app string
+
+	format string
+
+	client *clientFlags
+}
+
+func (f *validateFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.districtCleverID, "district", "", "District Clever ID")
+	fs.StringVar(
+		&f.app,
+		"app",
+		appMAPAccelerator,
+		"Clever app to check the roster of: "+appMAPAccelerator+" or "+
+			appMAPGrowth,
+	)
+	fs.StringVar(&f.format, "format", districtsFormatTable, "table or json")
+	f.client.register(fs)
+}
+
+func Validate(logger *zap.Logger, f *validateFlags) (err error) {
+	logger = districtLogger(logger, f.districtCleverID)
+	districtCleverID := f.districtCleverID
+
+	isMAP, appErr := parseApp(f.app)
+	if appErr != nil {
+		return appErr
+	}
+	if clientErr := f.client.validate(); clientErr != nil {
+		return clientErr
+	}
+
+	ctx := context.Background() //nolint:ka-context // GKE ≠ AppEngine
+	session, sessionErr := f.client.start(logger, districtCleverID)
+	if sessionErr != nil {
+		return sessionErr
+	}
+	defer func() {
+		session.finish(err)
+	}()
+
+	logger.Info("Validating district roster")
+
+	cleverClient, clientErr := rostering.GetCleverClient(
+		ctx,
+		logger,
+		districtCleverID,
+		isMAP,
+		session.Options...,
+	)
+	if clientErr != nil {
+		return clientErr
+	}
+	roster, rosterErr := rostering.GetRoster(
+		ctx,
+		logger,
+		cleverClient,
+		session.PageSize,
+	)
+	if rosterErr != nil {
+		return rosterErr
+	}
+	observeRoster(session.Metrics, districtCleverID, f.app, roster)
+
+	// Without terms or courses, the references to them go unchecked rather
+	// than failing the run
+	terms, termErr := rostering.GetCleverTerms(
+		ctx,
+		cleverClient,
+		session.PageSize,
+	)
+	if termErr != nil {
+		logger.Warn("Unable to fetch terms", zap.Error(termErr))
+	} else {
+		roster.Terms = terms
+	}
+	courses, courseErr := rostering.GetCleverCourses(
+		ctx,
+		cleverClient,
+		session.PageSize,
+	)
+	if courseErr != nil {
+		logger.Warn("Unable to fetch courses", zap.Error(courseErr))
+	} else {
+		roster.Courses = courses
+	}
+
+	report := integrity.Check(roster)
+	for _, s := range report.BySchool {
+		logger.Warn(
+			"Dangling references",
+			zap.String("school", s.School),
+			zap.String("school_name", s.SchoolName),
+			zap.Int("dangling", s.Dangling),
+		)
+	}
+
+	var writeErr error
+	if f.format == districtsFormatJSON {
+		writeErr = report.WriteJSON(os.Stdout)
+	} else {
+		writeErr = report.WriteTable(os.Stdout)
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if len(report.Dangling) > 0 {
+		return fmt.Errorf(
+			"%d dangling references in district %s",
+			len(report.Dangling),
+			districtCleverID,
+		)
+	}
+	return nil
+}
@@ -6,6 +6,9 @@ require (
 	github.com/deepmap/oapi-codegen v1.3.12
 	github.com/golangci/golangci-lint v1.30.0
 	github.com/pkg/errors v0.9.1
-	github.com/sethgrid/pester v1.1.0
+	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/robfig/cron/v3 v3.0.1
 	go.uber.org/zap v1.15.0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/Khan/clever-repartee/cmd"
-
-	"go.uber.org/zap"
+	"github.com/Khan/clever-repartee/pkg/logging"
 )
 
 const (
@@ -14,12 +15,15 @@ const (
 	exitFail = 1
 	// exitSuccess is the exit code if the program succeeds
 	exitSuccess = 0
+	// exitUsage is the exit code if the command line can't be run, e.g.
+	// for an unknown flag
+	exitUsage = 2
 )
 
 // https://pace.dev/blog/2020/02/12/why-you-shouldnt-use-func-main-in-golang-by-mat-ryer
 func main() {
 
-	logger, err := zap.NewDevelopment()
+	logger, err := logging.New(logging.FormatConsole, logging.DefaultLevel)
 
 	if err != nil {
 		panic(err)
@@ -27,10 +31,13 @@ func main() {
 
 	// pass all arguments without the executable name
 	if err := cmd.Run(os.Args[1:], logger); err != nil {
-		logger.Error("%s\n", zap.Error(err))
+		var usageErr *cmd.UsageError
+		if errors.As(err, &usageErr) {
+			fmt.Fprintf(os.Stderr, "%s\n%s\n", usageErr, usageErr.HelpHint())
+			os.Exit(exitUsage)
+		}
+		// cmd.Run logged the failure with the run's logger
 		os.Exit(exitFail)
-	} else {
-		logger.Info("Successful completion")
-		os.Exit(exitSuccess)
 	}
+	os.Exit(exitSuccess)
 }
@@ -0,0 +1,368 @@
+// Package config reads a YAML or JSON file of Clever app credentials,
+// district lists, email settings, output options and HTTP client settings.
+//
+// A setting is taken from, in order: a command line flag, an environment
+// variable, the config file, and the built-in default. The file's
+// credentials and email settings fill in environment variables that aren't
+// set, and its output and HTTP settings fill in flags that aren't given.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	netmail "net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/Khan/clever-repartee/pkg/mail"
+	"github.com/Khan/clever-repartee/pkg/tripperware"
+)
+
+// PathEnv names the config file when no -config flag is given
+const PathEnv = "CLEVER_REPARTEE_CONFIG"
+
+// Config is the config file. Every setting is optional.
+type Config struct {
+	Apps struct {
+		MAPAccelerator App `yaml:"map_accelerator"`
+		MAPGrowth      App `yaml:"map_growth"`
+	} `yaml:"apps"`
+	// Districts are named lists of district Clever IDs, which schedule
+	// files can refer to as groups
+	Districts map[string][]string `yaml:"districts"`
+	Email     Email               `yaml:"email"`
+	Output    Output              `yaml:"output"`
+	HTTP      HTTP                `yaml:"http"`
+}
+
+// App is a Clever app's credentials
+type App struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+// Email is who the summary email is from and to, and how it is sent
+type Email struct {
+	From     string   `yaml:"from"`
+	FromName string   `yaml:"from_name"`
+	ReplyTo  string   `yaml:"reply_to"`
+	To       []string `yaml:"to"`
+	Cc       []string `yaml:"cc"`
+	Bcc      []string `yaml:"bcc"`
+	// Routes is a mail routing table file
+	Routes string `yaml:"routes"`
+	SMTP   SMTP   `yaml:"smtp"`
+}
+
+// SMTP is how to reach the SMTP server
+type SMTP struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	TLS      string `yaml:"tls"`
+	Auth     string `yaml:"auth"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Helo     string `yaml:"helo"`
+}
+
+// Output is what a diff writes and sends
+type Output struct {
+	Formats          []string       `yaml:"formats"`
+	Dir              string         `yaml:"dir"`
+	PII              []string       `yaml:"pii"`
+	SummaryRows      *int           `yaml:"summary_rows"`
+	HighlightPercent *float64       `yaml:"highlight_percent"`
+	MaxSyncAge       *time.Duration `yaml:"max_sync_age"`
+	Notify           string         `yaml:"notify"`
+	RedactEmail      string         `yaml:"redact_email"`
+	RedactFiles      string         `yaml:"redact_files"`
+	Templates        struct {
+		Subject string `yaml:"subject"`
+		Text    string `yaml:"text"`
+		HTML    string `yaml:"html"`
+	} `yaml:"templates"`
+}
+
+// HTTP is how the Clever API is called
+type HTTP struct {
+	PageSize           *int           `yaml:"page_size"`
+	RetryAttempts      *int           `yaml:"retry_attempts"`
+	RetryMaxElapsed    *time.Duration `yaml:"retry_max_elapsed"`
+	RetryStatuses      []int          `yaml:"retry_statuses"`
+	RetryBaseDelay     *time.Duration `yaml:"retry_base_delay"`
+	RetryMaxDelay      *time.Duration `yaml:"retry_max_delay"`
+	RetryJitter        string         `yaml:"retry_jitter"`
+	Breaker            string         `yaml:"breaker"`
+	BreakerFailureRate *float64       `yaml:"breaker_failure_rate"`
+	BreakerOpenFor     *time.Duration `yaml:"breaker_open_for"`
+	MetricsPush        string         `yaml:"metrics_push"`
+}
+
+// Load reads a config file. YAML is a superset of JSON, so either works.
+// Unknown keys are an error, so that typos don't go unnoticed.
+func Load(path string) (*Config, error) {
+	content, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+	c := &Config{}
+	if yamlErr := yaml.UnmarshalStrict(content, c); yamlErr != nil {
+		return nil, fmt.Errorf("unable to read config %s: %w", path, yamlErr)
+	}
+	return c, nil
+}
+
+// Setting is one config file setting, as the environment variable or flag
+// it fills in
+type Setting struct {
+	// Key is where the setting is in the file
+	Key   string
+	Name  string
+	Value string
+}
+
+// Env are the environment variables the file sets
+func (c *Config) Env() []Setting {
+	var env []Setting
+	add := func(name, value string) {
+		if value != "" {
+			env = append(env, Setting{Name: name, Value: value})
+		}
+	}
+	add("CLEVER_ID", c.Apps.MAPAccelerator.ClientID)
+	add("CLEVER_SECRET", c.Apps.MAPAccelerator.ClientSecret)
+	add("MAP_CLEVER_ID", c.Apps.MAPGrowth.ClientID)
+	add("MAP_CLEVER_SECRET", c.Apps.MAPGrowth.ClientSecret)
+
+	e := c.Email
+	add("FROM_EMAIL", e.From)
+	add("FROM_NAME", e.FromName)
+	add("REPLY_TO_EMAIL", e.ReplyTo)
+	add("TO_EMAIL", strings.Join(e.To, ","))
+	add("CC_EMAIL", strings.Join(e.Cc, ","))
+	add("BCC_EMAIL", strings.Join(e.Bcc, ","))
+	add("MAIL_ROUTES", e.Routes)
+	add("SMTP_HOST", e.SMTP.Host)
+	add("SMTP_PORT", e.SMTP.Port)
+	add("SMTP_TLS", e.SMTP.TLS)
+	add("SMTP_AUTH", e.SMTP.Auth)
+	add("SMTP_USERNAME", e.SMTP.Username)
+	add("SMTP_PASSWORD", e.SMTP.Password)
+	add("SMTP_HELO", e.SMTP.Helo)
+	return env
+}
+
+// OutputFlags are the flags of the commands that run diffs the file sets
+func (c *Config) OutputFlags() []Setting {
+	var flags []Setting
+	add := flagAdder(&flags)
+	o := c.Output
+	add("output.formats", "format", strings.Join(o.Formats, ","))
+	add("output.dir", "out", o.Dir)
+	if o.PII != nil {
+		// An empty list is no PII, rather than unset
+		pii := strings.Join(o.PII, ",")
+		if pii == "" {
+			pii = "none"
+		}
+		add("output.pii", "pii", pii)
+	}
+	if o.SummaryRows != nil {
+		add("output.summary_rows", "summary-rows", strconv.Itoa(*o.SummaryRows))
+	}
+	if o.HighlightPercent != nil {
+		add(
+			"output.highlight_percent",
+			"highlight-percent",
+			formatFloat(*o.HighlightPercent),
+		)
+	}
+	if o.MaxSyncAge != nil {
+		add("output.max_sync_age", "max-sync-age", o.MaxSyncAge.String())
+	}
+	add("output.notify", "notify", o.Notify)
+	add("output.redact_email", "redact-email", o.RedactEmail)
+	add("output.redact_files", "redact-files", o.RedactFiles)
+	add("output.templates.subject", "subject-template", o.Templates.Subject)
+	add("output.templates.text", "text-template", o.Templates.Text)
+	add("output.templates.html", "html-template", o.Templates.HTML)
+	return flags
+}
+
+// HTTPFlags are the flags of every command that calls Clever the file sets
+func (c *Config) HTTPFlags() []Setting {
+	var flags []Setting
+	add := flagAdder(&flags)
+	h := c.HTTP
+	if h.PageSize != nil {
+		add("http.page_size", "page-size", strconv.Itoa(*h.PageSize))
+	}
+	if h.RetryAttempts != nil {
+		add("http.retry_attempts", "retry-attempts", strconv.Itoa(*h.RetryAttempts))
+	}
+	if h.RetryMaxElapsed != nil {
+		add("http.retry_max_elapsed", "retry-max-elapsed", h.RetryMaxElapsed.String())
+	}
+	if h.RetryStatuses != nil {
+		// An empty list is no statuses, rather than unset
+		statuses := tripperware.StatusList(h.RetryStatuses)
+		add("http.retry_statuses", "retry-statuses", statuses.String())
+	}
+	if h.RetryBaseDelay != nil {
+		add("http.retry_base_delay", "retry-base-delay", h.RetryBaseDelay.String())
+	}
+	if h.RetryMaxDelay != nil {
+		add("http.retry_max_delay", "retry-max-delay", h.RetryMaxDelay.String())
+	}
+	add("http.retry_jitter", "retry-jitter", h.RetryJitter)
+	add("http.breaker", "breaker", h.Breaker)
+	if h.BreakerFailureRate != nil {
+		add(
+			"http.breaker_failure_rate",
+			"breaker-failure-rate",
+			formatFloat(*h.BreakerFailureRate),
+		)
+	}
+	if h.BreakerOpenFor != nil {
+		add("http.breaker_open_for", "breaker-open-for", h.BreakerOpenFor.String())
+	}
+	add("http.metrics_push", "metrics-push", h.MetricsPush)
+	return flags
+}
+
+func flagAdder(flags *[]Setting) func(key, name, value string) {
+	return func(key, name, value string) {
+		if value != "" {
+			*flags = append(*flags, Setting{Key: key, Name: name, Value: value})
+		}
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// SetEnv sets the environment variables of the file that aren't set already
+func (c *Config) SetEnv() error {
+	for _, s := range c.Env() {
+		if _, set := os.LookupEnv(s.Name); set {
+			continue
+		}
+		if err := os.Setenv(s.Name, s.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetFlags sets the flags that fs has and that weren't given on the command
+// line. It returns a problem for each value fs rejects.
+func SetFlags(fs *flag.FlagSet, settings []Setting) []error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var problems []error
+	for _, s := range settings {
+		if fs.Lookup(s.Name) == nil || given[s.Name] {
+			continue
+		}
+		if err := fs.Set(s.Name, s.Value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", s.Key, err))
+		}
+	}
+	return problems
+}
+
+// Validate reports the problems that can be found in the file alone.
+// Settings that become flags are checked by setting them.
+func (c *Config) Validate() []error {
+	var problems []error
+	apps := []struct {
+		name string
+		app  App
+	}{
+		{"map_accelerator", c.Apps.MAPAccelerator},
+		{"map_growth", c.Apps.MAPGrowth},
+	}
+	for _, a := range apps {
+		if (a.app.ClientID == "") != (a.app.ClientSecret == "") {
+			problems = append(problems, fmt.Errorf(
+				"apps.%s: client_id and client_secret must be set together",
+				a.name,
+			))
+		}
+	}
+	problems = append(problems, c.Email.validate()...)
+	for name, ids := range c.Districts {
+		if len(ids) == 0 {
+			problems = append(
+				problems,
+				fmt.Errorf("districts.%s: no districts", name),
+			)
+		}
+		for _, id := range ids {
+			if id == "" {
+				problems = append(
+					problems,
+					fmt.Errorf("districts.%s: empty district", name),
+				)
+			}
+		}
+	}
+	return problems
+}
+
+func (e Email) validate() []error {
+	var problems []error
+	addresses := map[string][]string{
+		"from":     {e.From},
+		"reply_to": {e.ReplyTo},
+		"to":       e.To,
+		"cc":       e.Cc,
+		"bcc":      e.Bcc,
+	}
+	for _, key := range []string{"from", "reply_to", "to", "cc", "bcc"} {
+		for _, address := range addresses[key] {
+			if address == "" {
+				continue
+			}
+			if _, err := netmail.ParseAddress(address); err != nil {
+				problems = append(
+					problems,
+					fmt.Errorf("email.%s: %q: %w", key, address, err),
+				)
+			}
+		}
+	}
+	switch mail.TLSMode(strings.ToLower(e.SMTP.TLS)) {
+	case "", mail.TLSModeStartTLS, mail.TLSModeImplicit, mail.TLSModeNone:
+	default:
+		problems = append(problems, fmt.Errorf(
+			"email.smtp.tls: %q must be starttls, tls or none",
+			e.SMTP.TLS,
+		))
+	}
+	switch mail.AuthMechanism(strings.ToLower(e.SMTP.Auth)) {
+	case "", mail.AuthPlain, mail.AuthLogin, mail.AuthCRAMMD5, mail.AuthNone:
+	default:
+		problems = append(problems, fmt.Errorf(
+			"email.smtp.auth: %q must be plain, login, cram-md5 or none",
+			e.SMTP.Auth,
+		))
+	}
+	if e.SMTP.Port != "" {
+		if _, err := strconv.Atoi(e.SMTP.Port); err != nil {
+			problems = append(problems, fmt.Errorf(
+				"email.smtp.port: %q is not a number",
+				e.SMTP.Port,
+			))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testYAML = `
+apps:
+  map_accelerator:
+    client_id: accelerator-id
+    client_secret: accelerator-secret
+districts:
+  pilot: [d1, d2]
+email:
+  from: repartee@example.org
+  to: [a@example.org, b@example.org]
+  smtp:
+    host: smtp.example.org
+    port: "587"
+output:
+  formats: [csv, md]
+  pii: []
+  summary_rows: 0
+  highlight_percent: 12.5
+  max_sync_age: 36h
+http:
+  retry_statuses: []
+  retry_max_delay: 30s
+  breaker: district
+`
+
+// writeConfig writes content to a file in a new directory, which the
+// caller removes
+func writeConfig(t *testing.T, name, content string) (string, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return dir, path
+}
+
+func TestLoad(t *testing.T) {
+	dir, path := writeConfig(t, "config.yaml", testYAML)
+	defer os.RemoveAll(dir)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	wantEnv := []Setting{
+		{Name: "CLEVER_ID", Value: "accelerator-id"},
+		{Name: "CLEVER_SECRET", Value: "accelerator-secret"},
+		{Name: "FROM_EMAIL", Value: "repartee@example.org"},
+		{Name: "TO_EMAIL", Value: "a@example.org,b@example.org"},
+		{Name: "SMTP_HOST", Value: "smtp.example.org"},
+		{Name: "SMTP_PORT", Value: "587"},
+	}
+	if got := c.Env(); !reflect.DeepEqual(got, wantEnv) {
+		t.Errorf("Env() = %+v, want %+v", got, wantEnv)
+	}
+
+	// Empty lists and zeroes are set, not left to the flag defaults
+	wantOutput := []Setting{
+		{Key: "output.formats", Name: "format", Value: "csv,md"},
+		{Key: "output.pii", Name: "pii", Value: "none"},
+		{Key: "output.summary_rows", Name: "summary-rows", Value: "0"},
+		{
+			Key:   "output.highlight_percent",
+			Name:  "highlight-percent",
+			Value: "12.5",
+		},
+		{Key: "output.max_sync_age", Name: "max-sync-age", Value: "36h0m0s"},
+	}
+	if got := c.OutputFlags(); !reflect.DeepEqual(got, wantOutput) {
+		t.Errorf("OutputFlags() = %+v, want %+v", got, wantOutput)
+	}
+	wantHTTP := []Setting{
+		{Key: "http.retry_statuses", Name: "retry-statuses", Value: "none"},
+		{Key: "http.retry_max_delay", Name: "retry-max-delay", Value: "30s"},
+		{Key: "http.breaker", Name: "breaker", Value: "district"},
+	}
+	if got := c.HTTPFlags(); !reflect.DeepEqual(got, wantHTTP) {
+		t.Errorf("HTTPFlags() = %+v, want %+v", got, wantHTTP)
+	}
+	if problems := c.Validate(); problems != nil {
+		t.Errorf("Validate() = %v", problems)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	dir, path := writeConfig(
+		t,
+		"config.json",
+		`{"districts": {"pilot": ["d1"]}, "http": {"page_size": 50}}`,
+	)
+	defer os.RemoveAll(dir)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !reflect.DeepEqual(c.Districts, map[string][]string{"pilot": {"d1"}}) {
+		t.Errorf("districts = %v", c.Districts)
+	}
+	if c.HTTP.PageSize == nil || *c.HTTP.PageSize != 50 {
+		t.Errorf("page size = %v, want 50", c.HTTP.PageSize)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, path := writeConfig(t, "config.yaml", "output:\n  format: [csv]\n")
+	defer os.RemoveAll(dir)
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "field format not found") {
+		t.Errorf("Load() of a typo error = %v", err)
+	}
+	if _, err = Load(filepath.Join(dir, "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Load() of a missing file error = %v", err)
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	format := fs.String("format", "json", "")
+	out := fs.String("out", ".", "")
+	fs.Int("summary-rows", 10, "")
+	if err := fs.Parse([]string{"-out", "reports"}); err != nil {
+		t.Fatal(err)
+	}
+	problems := SetFlags(fs, []Setting{
+		{Key: "output.formats", Name: "format", Value: "csv"},
+		{Key: "output.dir", Name: "out", Value: "elsewhere"},
+		{Key: "output.summary_rows", Name: "summary-rows", Value: "many"},
+		{Key: "http.breaker", Name: "breaker", Value: "district"},
+	})
+	if *format != "csv" {
+		t.Errorf("format = %q, want the file's csv", *format)
+	}
+	if *out != "reports" {
+		t.Errorf("out = %q, want the command line's reports", *out)
+	}
+	if len(problems) != 1 ||
+		!strings.HasPrefix(problems[0].Error(), "output.summary_rows: ") {
+		t.Errorf("SetFlags() = %v, want the summary rows rejected", problems)
+	}
+}
+
+func TestSetEnv(t *testing.T) {
+	for _, name := range []string{"CLEVER_ID", "CLEVER_SECRET"} {
+		if old, set := os.LookupEnv(name); set {
+			defer os.Setenv(name, old)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+	if err := os.Setenv("CLEVER_ID", "from-env"); err != nil {
+		t.Fatal(err)
+	}
+	os.Unsetenv("CLEVER_SECRET")
+
+	c := &Config{}
+	c.Apps.MAPAccelerator = App{ClientID: "from-file", ClientSecret: "secret"}
+	if err := c.SetEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("CLEVER_ID"); got != "from-env" {
+		t.Errorf("CLEVER_ID = %q, want the environment's", got)
+	}
+	if got := os.Getenv("CLEVER_SECRET"); got != "secret" {
+		t.Errorf("CLEVER_SECRET = %q, want the file's", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(c *Config)
+		want   []string
+	}{
+		{name: "empty", config: func(c *Config) {}},
+		{
+			name: "half an app",
+			config: func(c *Config) {
+				c.Apps.MAPGrowth.ClientID = "growth-id"
+			},
+			want: []string{
+				"apps.map_growth: client_id and client_secret must be set " +
+					"together",
+			},
+		},
+		{
+			name: "email",
+			config: func(c *Config) {
+				c.Email = Email{
+					From: "Repartee <repartee@example.org>",
+					To:   []string{"a@example.org", "not an address"},
+					SMTP: SMTP{TLS: "STARTTLS", Auth: "xoauth2", Port: "smtp"},
+				}
+			},
+			want: []string{
+				`email.to: "not an address": mail: no angle-addr`,
+				`email.smtp.auth: "xoauth2" must be plain, login, cram-md5 ` +
+					"or none",
+				`email.smtp.port: "smtp" is not a number`,
+			},
+		},
+		{
+			name: "districts",
+			config: func(c *Config) {
+				c.Districts = map[string][]string{"pilot": {"d1", ""}}
+			},
+			want: []string{"districts.pilot: empty district"},
+		},
+		{
+			name: "no districts",
+			config: func(c *Config) {
+				c.Districts = map[string][]string{"pilot": {}}
+			},
+			want: []string{"districts.pilot: no districts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			tt.config(c)
+			var got []string
+			for _, problem := range c.Validate() {
+				got = append(got, problem.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDurations(t *testing.T) {
+	dir, path := writeConfig(t, "config.yaml", "output:\n  max_sync_age: 1d\n")
+	defer os.RemoveAll(dir)
+	if _, err := Load(path); err == nil {
+		t.Error("Load() of a duration in days succeeded")
+	}
+
+	c := &Config{}
+	openFor := 90 * time.Second
+	c.HTTP.BreakerOpenFor = &openFor
+	want := []Setting{{
+		Key:   "http.breaker_open_for",
+		Name:  "breaker-open-for",
+		Value: "1m30s",
+	}}
+	if got := c.HTTPFlags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("HTTPFlags() = %+v, want %+v", got, want)
+	}
+}
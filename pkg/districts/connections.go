@@ -0,0 +1,110 @@
+package districts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Connection is a district connected to one app but not the other
+type Connection struct {
+	ID         string
+	Name       string `json:",omitempty"`
+	LaunchDate string `json:",omitempty"`
+	// ConnectedTo is the app the district is connected to
+	ConnectedTo string
+	// MissingFrom is the app the district is not connected to
+	MissingFrom string
+}
+
+// Connections compares the districts connected to two apps
+type Connections struct {
+	Apps [2]string
+	// Both is how many districts are connected to both apps
+	Both int
+	// OneApp are the districts connected to only one app, by app and name
+	OneApp []Connection
+}
+
+// CompareConnections finds the district IDs connected to only one of two
+// apps. Names and launch dates are left for the caller to fill in.
+func CompareConnections(apps [2]string, ids [2][]string) *Connections {
+	c := &Connections{Apps: apps, OneApp: []Connection{}}
+	sets := [2]map[string]bool{{}, {}}
+	for i := range ids {
+		for _, id := range ids[i] {
+			sets[i][id] = true
+		}
+	}
+	for i := range ids {
+		other := 1 - i
+		for id := range sets[i] {
+			if sets[other][id] {
+				if i == 0 {
+					c.Both++
+				}
+				continue
+			}
+			c.OneApp = append(c.OneApp, Connection{
+				ID:          id,
+				ConnectedTo: apps[i],
+				MissingFrom: apps[other],
+			})
+		}
+	}
+	c.Sort()
+	return c
+}
+
+// Sort orders the districts by the app they are connected to, then name
+func (c *Connections) Sort() {
+	sort.Slice(c.OneApp, func(i, j int) bool {
+		a, b := c.OneApp[i], c.OneApp[j]
+		if a.ConnectedTo != b.ConnectedTo {
+			return a.ConnectedTo < b.ConnectedTo
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+}
+
+// WriteTable writes a summary line and one aligned row per district
+// connected to only one app
+func (c *Connections) WriteTable(w io.Writer) error {
+	fmt.Fprintf(
+		w,
+		"%d districts connected to both %s and %s, %d to only one\n\n",
+		c.Both,
+		c.Apps[0],
+		c.Apps[1],
+		len(c.OneApp),
+	)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tLAUNCHED\tCONNECTED TO\tMISSING FROM")
+	for _, d := range c.OneApp {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\n",
+			d.ID,
+			d.Name,
+			d.LaunchDate,
+			d.ConnectedTo,
+			d.MissingFrom,
+		)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the comparison as one indented JSON document
+func (c *Connections) WriteJSON(w io.Writer) error {
+	content, err := json.MarshalIndent(c, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", content)
+	return err
+}
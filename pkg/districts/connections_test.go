@@ -0,0 +1,144 @@
+package districts
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareConnections(t *testing.T) {
+	apps := [2]string{"map_accelerator", "map_growth"}
+	tests := []struct {
+		name     string
+		ids      [2][]string
+		wantBoth int
+		want     []Connection
+	}{
+		{
+			name: "none",
+			want: []Connection{},
+		},
+		{
+			name:     "the same districts",
+			ids:      [2][]string{{"d1", "d2"}, {"d2", "d1"}},
+			wantBoth: 2,
+			want:     []Connection{},
+		},
+		{
+			name:     "some on only one, with duplicates",
+			ids:      [2][]string{{"d3", "d1", "d2", "d3"}, {"d2", "d4"}},
+			wantBoth: 1,
+			want: []Connection{
+				{
+					ID:          "d1",
+					ConnectedTo: "map_accelerator",
+					MissingFrom: "map_growth",
+				},
+				{
+					ID:          "d3",
+					ConnectedTo: "map_accelerator",
+					MissingFrom: "map_growth",
+				},
+				{
+					ID:          "d4",
+					ConnectedTo: "map_growth",
+					MissingFrom: "map_accelerator",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CompareConnections(apps, tt.ids)
+			if got.Apps != apps || got.Both != tt.wantBoth {
+				t.Errorf(
+					"CompareConnections() = %v, %d both, want %v, %d both",
+					got.Apps,
+					got.Both,
+					apps,
+					tt.wantBoth,
+				)
+			}
+			if !reflect.DeepEqual(got.OneApp, tt.want) {
+				t.Errorf("one app = %+v, want %+v", got.OneApp, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectionsSort(t *testing.T) {
+	c := &Connections{OneApp: []Connection{
+		{ID: "d4", Name: "Adams", ConnectedTo: "map_growth"},
+		{ID: "d3", Name: "Lincoln", ConnectedTo: "map_accelerator"},
+		{ID: "d2", Name: "Adams", ConnectedTo: "map_accelerator"},
+		{ID: "d1", Name: "Adams", ConnectedTo: "map_accelerator"},
+	}}
+	c.Sort()
+	var ids []string
+	for _, d := range c.OneApp {
+		ids = append(ids, d.ID)
+	}
+	if want := []string{"d1", "d2", "d3", "d4"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Sort() = %v, want %v", ids, want)
+	}
+}
+
+// testConnections has one district connected to both apps and two to one
+func testConnections() *Connections {
+	c := CompareConnections(
+		[2]string{"map_accelerator", "map_growth"},
+		[2][]string{{"d1", "d2"}, {"d2", "d3"}},
+	)
+	c.OneApp[0].Name = "Springfield Unified"
+	c.OneApp[0].LaunchDate = "2020-08-01"
+	return c
+}
+
+func TestConnectionsWriteTable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testConnections().WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"1 districts connected to both map_accelerator and map_growth, " +
+			"2 to only one",
+		"",
+		"ID  NAME                 LAUNCHED    CONNECTED TO     MISSING FROM",
+		"d1  Springfield Unified  2020-08-01  map_accelerator  map_growth",
+		"d3                                   map_growth       " +
+			"map_accelerator",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("WriteTable() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestConnectionsWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testConnections().WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got Connections
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, testConnections()) {
+		t.Errorf("WriteJSON() = %+v, want %+v", got, testConnections())
+	}
+	// Names and launch dates not filled in are left out
+	if strings.Count(buf.String(), `"Name"`) != 1 {
+		t.Errorf("WriteJSON() =\n%s\nwant one name", &buf)
+	}
+
+	empty := CompareConnections([2]string{"a", "b"}, [2][]string{})
+	buf.Reset()
+	if err := empty.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"OneApp": []`) {
+		t.Errorf("WriteJSON() =\n%s\nwant an empty list, not null", &buf)
+	}
+}
@@ -0,0 +1,185 @@
+// Package districts describes the districts a Clever app is connected to,
+// and flags the ones whose rosters can't be trusted to be current
+package districts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+)
+
+// DefaultMaxSyncAge is how long ago a district can have last synced before
+// it is stale
+const DefaultMaxSyncAge = 24 * time.Hour
+
+// Flags raised on a district
+const (
+	// FlagPaused is a district whose syncing is paused now
+	FlagPaused = "paused"
+	// FlagError is a district that reports an error
+	FlagError = "error"
+	// FlagStale is a district that hasn't synced for longer than allowed, or
+	// has never synced
+	FlagStale = "stale"
+)
+
+// Status is one district as one app sees it
+type Status struct {
+	App          string
+	ID           string
+	Name         string
+	State        string   `json:",omitempty"`
+	SisType      string   `json:",omitempty"`
+	LoginMethods []string `json:",omitempty"`
+	LaunchDate   string   `json:",omitempty"`
+	LastSync     string   `json:",omitempty"`
+	PauseStart   string   `json:",omitempty"`
+	PauseEnd     string   `json:",omitempty"`
+	Error        string   `json:",omitempty"`
+	// Flags are raised by Check, e.g. paused
+	Flags []string `json:",omitempty"`
+}
+
+// NewStatus checks a district as of now, flagging it as stale if it last
+// synced longer than maxSyncAge ago, unless maxSyncAge is 0
+func NewStatus(
+	app string,
+	district generated.District,
+	now time.Time,
+	maxSyncAge time.Duration,
+) Status {
+	s := Status{
+		App:        app,
+		ID:         value(district.Id),
+		Name:       value(district.Name),
+		State:      value(district.State),
+		SisType:    value(district.SisType),
+		LaunchDate: value(district.LaunchDate),
+		LastSync:   value(district.LastSync),
+		PauseStart: value(district.PauseStart),
+		PauseEnd:   value(district.PauseEnd),
+		Error:      value(district.Error),
+	}
+	if district.LoginMethods != nil {
+		s.LoginMethods = *district.LoginMethods
+	}
+	s.Flags = s.Check(now, maxSyncAge)
+	return s
+}
+
+// Check returns the flags raised on the district as of now. A maxSyncAge of
+// 0 doesn't check how long ago the district synced.
+func (s Status) Check(now time.Time, maxSyncAge time.Duration) []string {
+	var flags []string
+	if s.Paused(now) {
+		flags = append(flags, FlagPaused)
+	}
+	if s.Error != "" {
+		flags = append(flags, FlagError)
+	}
+	if maxSyncAge > 0 {
+		lastSync, ok := parseTime(s.LastSync)
+		if !ok || now.Sub(lastSync) > maxSyncAge {
+			flags = append(flags, FlagStale)
+		}
+	}
+	return flags
+}
+
+// Paused is true between the pause start and end. A pause with no end lasts
+// until it is lifted.
+func (s Status) Paused(now time.Time) bool {
+	start, ok := parseTime(s.PauseStart)
+	if !ok || now.Before(start) {
+		return false
+	}
+	end, ok := parseTime(s.PauseEnd)
+	return !ok || now.Before(end)
+}
+
+// Paused is true if the district's syncing is paused now
+func Paused(district generated.District, now time.Time) bool {
+	s := Status{
+		PauseStart: value(district.PauseStart),
+		PauseEnd:   value(district.PauseEnd),
+	}
+	return s.Paused(now)
+}
+
+// parseTime reads Clever's timestamps, and the plain dates it uses for some
+// fields
+func parseTime(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Flagged are the statuses with at least one flag
+func Flagged(statuses []Status) []Status {
+	var flagged []Status
+	for _, s := range statuses {
+		if len(s.Flags) > 0 {
+			flagged = append(flagged, s)
+		}
+	}
+	return flagged
+}
+
+// WriteTable writes one aligned row per district
+func WriteTable(w io.Writer, statuses []Status) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(
+		tw,
+		"APP\tID\tNAME\tSIS\tLOGIN METHODS\tLAUNCHED\tLAST SYNC\t"+
+			"PAUSED\tERROR\tFLAGS",
+	)
+	for _, s := range statuses {
+		var pause string
+		if s.PauseStart != "" || s.PauseEnd != "" {
+			pause = s.PauseStart + " to " + s.PauseEnd
+		}
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.App,
+			s.ID,
+			s.Name,
+			s.SisType,
+			strings.Join(s.LoginMethods, ","),
+			s.LaunchDate,
+			s.LastSync,
+			pause,
+			s.Error,
+			strings.Join(s.Flags, ","),
+		)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the statuses as one indented JSON array
+func WriteJSON(w io.Writer, statuses []Status) error {
+	if statuses == nil {
+		statuses = []Status{}
+	}
+	content, err := json.MarshalIndent(statuses, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", content)
+	return err
+}
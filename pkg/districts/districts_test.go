@@ -0,0 +1,117 @@
+package districts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+)
+
+var testNow = time.Date(2020, 9, 2, 12, 0, 0, 0, time.UTC)
+
+func TestStatusPaused(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		want  bool
+	}{
+		{name: "no pause"},
+		{
+			name:  "during the pause",
+			start: "2020-09-01",
+			end:   "2020-09-03",
+			want:  true,
+		},
+		{name: "before the pause", start: "2020-09-03", end: "2020-09-04"},
+		{name: "after the pause", start: "2020-08-01", end: "2020-09-01"},
+		{name: "no end", start: "2020-09-01T00:00:00Z", want: true},
+		{name: "unreadable start", start: "soon", end: "2020-09-03"},
+		{name: "only an end", end: "2020-09-03"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Status{PauseStart: tt.start, PauseEnd: tt.end}
+			if got := s.Paused(testNow); got != tt.want {
+				t.Errorf("Status.Paused() = %v, want %v", got, tt.want)
+			}
+			district := generated.District{}
+			if tt.start != "" {
+				district.PauseStart = &tt.start
+			}
+			if tt.end != "" {
+				district.PauseEnd = &tt.end
+			}
+			if got := Paused(district, testNow); got != tt.want {
+				t.Errorf("Paused() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     Status
+		maxSyncAge time.Duration
+		want       []string
+	}{
+		{
+			name:       "synced recently",
+			status:     Status{LastSync: "2020-09-02T06:00:00.000Z"},
+			maxSyncAge: DefaultMaxSyncAge,
+		},
+		{
+			name:       "stale",
+			status:     Status{LastSync: "2020-08-30T06:00:00.000Z"},
+			maxSyncAge: DefaultMaxSyncAge,
+			want:       []string{FlagStale},
+		},
+		{
+			name:       "never synced",
+			maxSyncAge: DefaultMaxSyncAge,
+			want:       []string{FlagStale},
+		},
+		{
+			name:   "sync age not checked",
+			status: Status{LastSync: "2020-08-30T06:00:00.000Z"},
+		},
+		{
+			name: "everything",
+			status: Status{
+				PauseStart: "2020-09-01",
+				Error:      "sync failed",
+			},
+			maxSyncAge: time.Hour,
+			want:       []string{FlagPaused, FlagError, FlagStale},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.status.Check(testNow, tt.maxSyncAge)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagged(t *testing.T) {
+	statuses := []Status{
+		{ID: "d1"},
+		{ID: "d2", Flags: []string{FlagStale}},
+		{ID: "d3", Flags: []string{}},
+		{ID: "d4", Flags: []string{FlagPaused, FlagError}},
+	}
+	var got []string
+	for _, s := range Flagged(statuses) {
+		got = append(got, s.ID)
+	}
+	if want := []string{"d2", "d4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Flagged() = %v, want %v", got, want)
+	}
+	if got := Flagged([]Status{{ID: "d1"}}); got != nil {
+		t.Errorf("Flagged() = %v, want none", got)
+	}
+}
@@ -0,0 +1,265 @@
+// Package export writes a Clever app's whole roster for a district to files,
+// one per entity type, with a manifest describing what was written
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// Formats are the formats an export can be written in
+var Formats = []string{"json", "ndjson", "csv"}
+
+// ValidateFormat checks that format is one of Formats
+func ValidateFormat(format string) error {
+	if !contains(Formats, format) {
+		return fmt.Errorf(
+			"unknown export format %q, must be one of %s",
+			format,
+			strings.Join(Formats, ", "),
+		)
+	}
+	return nil
+}
+
+// Manifest describes one export, and is written next to its files
+type Manifest struct {
+	Tool             string
+	ToolVersion      string
+	App              string
+	DistrictCleverID string
+	DistrictName     string
+	ExportedAt       time.Time
+	Format           string
+	// PII are the PII fields included. The others were left out of every
+	// student, teacher and admin record.
+	PII      []report.PIIField
+	Entities []EntityFile
+}
+
+// EntityFile is the file holding one entity type's records
+type EntityFile struct {
+	// Entity is the plural, lower case entity type, e.g. "students"
+	Entity  string
+	Records int
+	// File is the file name, relative to the manifest
+	File string
+}
+
+// entity is one entity type of a roster
+type entity struct {
+	name string
+	// people are records of people, which can hold PII
+	people  bool
+	records interface{}
+}
+
+func entities(roster *rostering.Roster) []entity {
+	return []entity{
+		{"districts", false, roster.Districts},
+		{"schools", false, roster.Schools},
+		{"students", true, roster.Students},
+		{"teachers", true, roster.Teachers},
+		{"district_admins", true, roster.DistrictAdmins},
+		{"school_admins", true, roster.SchoolAdmins},
+		{"sections", false, roster.Sections},
+		{"terms", false, roster.Terms},
+		{"courses", false, roster.Courses},
+	}
+}
+
+// Write writes every entity type of the roster to a file in dir, creating
+// it if needed, with names starting with prefix. PII fields not in pii are
+// left out. It fills in the manifest's format, PII and entities, writes it
+// too, and returns the paths of every file written.
+func Write(
+	dir, prefix, format string,
+	roster *rostering.Roster,
+	pii report.PIIFields,
+	manifest Manifest,
+) ([]string, error) {
+	if err := ValidateFormat(format); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	manifest.Format = format
+	manifest.PII = nil
+	for _, field := range report.AllPIIFields {
+		if pii[field] {
+			manifest.PII = append(manifest.PII, field)
+		}
+	}
+
+	var paths []string
+	manifest.Entities = nil
+	for _, e := range entities(roster) {
+		records, err := toRecords(e.records)
+		if err != nil {
+			return paths, fmt.Errorf("unable to export %s: %w", e.name, err)
+		}
+		if e.people {
+			for _, record := range records {
+				pii.Omit(record)
+			}
+		}
+
+		var content []byte
+		switch format {
+		case "json":
+			content, err = json.MarshalIndent(records, "", " ")
+		case "ndjson":
+			content, err = encodeNDJSON(records)
+		case "csv":
+			content, err = encodeCSV(records)
+		}
+		if err != nil {
+			return paths, fmt.Errorf("unable to export %s: %w", e.name, err)
+		}
+
+		name := prefix + "-" + e.name + "." + format
+		path := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(path, content, 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+		manifest.Entities = append(manifest.Entities, EntityFile{
+			Entity:  e.name,
+			Records: len(records),
+			File:    name,
+		})
+	}
+
+	content, err := json.MarshalIndent(manifest, "", " ")
+	if err != nil {
+		return paths, err
+	}
+	path := filepath.Join(dir, prefix+"-manifest.json")
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return paths, err
+	}
+	return append(paths, path), nil
+}
+
+// toRecords turns a slice of Clever objects into generic JSON objects, so
+// that every entity type can be written the same way. Keys are the ones the
+// Clever API uses.
+func toRecords(list interface{}) ([]map[string]interface{}, error) {
+	content, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	// Keep numbers as Clever sent them rather than as float64
+	dec.UseNumber()
+	if err = dec.Decode(&records); err != nil {
+		return nil, err
+	}
+	if records == nil {
+		records = []map[string]interface{}{}
+	}
+	return records, nil
+}
+
+func encodeNDJSON(records []map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeCSV writes one row per record, with nested objects flattened into
+// dotted columns, e.g. name.first, and lists as JSON. The columns are every
+// key seen in any record, id first and the rest sorted.
+func encodeCSV(records []map[string]interface{}) ([]byte, error) {
+	var rows []map[string]string
+	seen := map[string]bool{}
+	for _, record := range records {
+		row := Flatten(record)
+		for column := range row {
+			seen[column] = true
+		}
+		rows = append(rows, row)
+	}
+	var columns []string
+	for column := range seen {
+		if column != "id" {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+	columns = append([]string{"id"}, columns...)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(columns); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = row[column]
+		}
+		if err := w.Write(values); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Flatten turns a Clever API object decoded as JSON into one string per
+// field, with nested objects flattened into dotted keys, e.g. name.first, and
+// numbers, booleans and lists as JSON
+func Flatten(record map[string]interface{}) map[string]string {
+	row := map[string]string{}
+	flatten("", record, row)
+	return row
+}
+
+func flatten(prefix string, value interface{}, row map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flatten(key, nested, row)
+		}
+	case string:
+		row[prefix] = v
+	case nil:
+		row[prefix] = ""
+	default:
+		// Numbers, booleans and lists
+		content, _ := json.Marshal(v)
+		row[prefix] = string(content)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
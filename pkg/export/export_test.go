@@ -0,0 +1,190 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+func str(s string) *string { return &s }
+
+func testRoster() *rostering.Roster {
+	return &rostering.Roster{
+		Districts: &[]generated.District{{Id: str("d1"), Name: str("Test")}},
+		Schools:   &[]generated.School{{Id: str("s1"), Name: str("Adams")}},
+		Students: &[]generated.Student{{
+			Id:    str("st1"),
+			Email: str("grace@example.org"),
+			Name: &generated.Name{
+				First: str("Grace"),
+				Last:  str("Hopper"),
+			},
+		}},
+		Terms:   &[]generated.Term{{Id: str("term1"), Name: str("Fall")}},
+		Courses: &[]generated.Course{},
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	for _, format := range Formats {
+		if err := ValidateFormat(format); err != nil {
+			t.Errorf("ValidateFormat(%q) error = %v", format, err)
+		}
+	}
+	if err := ValidateFormat("xml"); err == nil {
+		t.Error("ValidateFormat(\"xml\") = nil, want an error")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pii, err := report.ParsePIIFields("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := Write(dir, "test", "json", testRoster(), pii, Manifest{
+		App:              "map_growth",
+		DistrictCleverID: "d1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 10 {
+		t.Errorf("wrote %d files, want 9 entities and a manifest", len(paths))
+	}
+
+	var manifest Manifest
+	readJSON(t, filepath.Join(dir, "test-manifest.json"), &manifest)
+	if manifest.Format != "json" || manifest.App != "map_growth" ||
+		!reflect.DeepEqual(manifest.PII, []report.PIIField{report.PIIName}) {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	counts := map[string]int{}
+	for _, e := range manifest.Entities {
+		counts[e.Entity] = e.Records
+		if e.File != "test-"+e.Entity+".json" {
+			t.Errorf("%s written to %s", e.Entity, e.File)
+		}
+	}
+	wantCounts := map[string]int{
+		"districts":       1,
+		"schools":         1,
+		"students":        1,
+		"teachers":        0,
+		"district_admins": 0,
+		"school_admins":   0,
+		"sections":        0,
+		"terms":           1,
+		"courses":         0,
+	}
+	if !reflect.DeepEqual(counts, wantCounts) {
+		t.Errorf("record counts = %v, want %v", counts, wantCounts)
+	}
+
+	var students []map[string]interface{}
+	readJSON(t, filepath.Join(dir, "test-students.json"), &students)
+	want := []map[string]interface{}{{
+		"id":   "st1",
+		"name": map[string]interface{}{"first": "Grace", "last": "Hopper"},
+	}}
+	if !reflect.DeepEqual(students, want) {
+		t.Errorf("students = %v, want %v", students, want)
+	}
+	var teachers []map[string]interface{}
+	readJSON(t, filepath.Join(dir, "test-teachers.json"), &teachers)
+	if teachers == nil || len(teachers) != 0 {
+		t.Errorf("teachers = %v, want an empty list", teachers)
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "ndjson", want: `{"id":"term1","name":"Fall"}` + "\n"},
+		{format: "csv", want: "id,name\nterm1,Fall\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "export")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			_, err = Write(
+				dir,
+				"test",
+				tt.format,
+				testRoster(),
+				report.PIIFields{},
+				Manifest{},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(
+				filepath.Join(dir, "test-terms."+tt.format),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("terms = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeCSV(t *testing.T) {
+	records := []map[string]interface{}{
+		{
+			"name":    map[string]interface{}{"first": "Grace"},
+			"id":      "st1",
+			"schools": []interface{}{"s1", "s2"},
+		},
+		{"id": "st2", "grade": json.Number("7"), "hispanic": nil},
+	}
+	content, err := encodeCSV(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"id", "grade", "hispanic", "name.first", "schools"},
+		{"st1", "", "", "Grace", `["s1","s2"]`},
+		{"st2", "7", "", "", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func readJSON(t *testing.T, path string, out interface{}) {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, out); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+}
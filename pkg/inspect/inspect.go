@@ -0,0 +1,437 @@
+// Package inspect looks up one Clever entity and everything related to it,
+// so that what two apps see of it can be compared side by side
+package inspect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// Types are the entity types that can be inspected
+var Types = []string{"student", "teacher", "section", "school"}
+
+// ValidateType checks that entityType is one of Types
+func ValidateType(entityType string) error {
+	for _, t := range Types {
+		if t == entityType {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"unknown type %q, must be one of %s",
+		entityType,
+		strings.Join(Types, ", "),
+	)
+}
+
+// Inspection is one entity and its relationships, as one app sees them
+type Inspection struct {
+	Type string
+	ID   string
+	// Record is nil if the app can't see the entity
+	Record map[string]interface{}
+	// Relationships are the related entities of each kind, e.g. sections
+	Relationships []Relationship
+}
+
+// Relationship is the entities of one type related to the inspected one
+type Relationship struct {
+	Name    string
+	Records []map[string]interface{}
+}
+
+// list fetches one page of a relationship
+type list func(
+	ctx context.Context,
+	limit int,
+	startingAfter *string,
+) (*http.Response, error)
+
+// one fetches a relationship with a single entity, e.g. a section's school
+type one func(ctx context.Context) (*http.Response, error)
+
+type relationship struct {
+	name string
+	list list
+	one  one
+}
+
+// Inspect fetches the entity of the given type and ID, and every entity
+// related to it, pageSize at a time. An entity the app can't see is not an
+// error: it has a nil Record and no relationships.
+func Inspect(
+	ctx context.Context,
+	client *generated.Client,
+	entityType, id string,
+	pageSize int,
+) (*Inspection, error) {
+	if err := ValidateType(entityType); err != nil {
+		return nil, err
+	}
+	inspection := &Inspection{Type: entityType, ID: id}
+
+	var get one
+	var relationships []relationship
+	switch entityType {
+	case "student":
+		get = func(ctx context.Context) (*http.Response, error) {
+			return client.GetStudent(ctx, id)
+		}
+		relationships = studentRelationships(client, id)
+	case "teacher":
+		get = func(ctx context.Context) (*http.Response, error) {
+			return client.GetTeacher(ctx, id)
+		}
+		relationships = teacherRelationships(client, id)
+	case "section":
+		get = func(ctx context.Context) (*http.Response, error) {
+			return client.GetSection(ctx, id)
+		}
+		relationships = sectionRelationships(client, id)
+	case "school":
+		get = func(ctx context.Context) (*http.Response, error) {
+			return client.GetSchool(ctx, id)
+		}
+		relationships = schoolRelationships(client, id)
+	}
+
+	record, err := fetchOne(ctx, entityType+" "+id, get)
+	if err != nil || record == nil {
+		return inspection, err
+	}
+	inspection.Record = record
+
+	for _, r := range relationships {
+		var records []map[string]interface{}
+		path := entityType + " " + id + " " + r.name
+		if r.one != nil {
+			related, oneErr := fetchOne(ctx, path, r.one)
+			if oneErr != nil {
+				return inspection, oneErr
+			}
+			if related != nil {
+				records = append(records, related)
+			}
+		} else {
+			records, err = fetchAll(ctx, path, r.list, pageSize)
+			if err != nil {
+				return inspection, err
+			}
+		}
+		sort.Slice(records, func(i, j int) bool {
+			return Label(records[i]) < Label(records[j])
+		})
+		inspection.Relationships = append(
+			inspection.Relationships,
+			Relationship{Name: r.name, Records: records},
+		)
+	}
+	return inspection, nil
+}
+
+func studentRelationships(
+	client *generated.Client,
+	id string,
+) []relationship {
+	return []relationship{
+		{name: "schools", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetSchoolsForStudent(
+				ctx,
+				id,
+				&generated.GetSchoolsForStudentParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+		{name: "sections", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetSectionsForStudent(
+				ctx,
+				id,
+				&generated.GetSectionsForStudentParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+		{name: "teachers", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetTeachersForStudent(
+				ctx,
+				id,
+				&generated.GetTeachersForStudentParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+		{name: "contacts", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetContactsForStudent(
+				ctx,
+				id,
+				&generated.GetContactsForStudentParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+	}
+}
+
+func teacherRelationships(
+	client *generated.Client,
+	id string,
+) []relationship {
+	return []relationship{
+		{name: "schools", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetSchoolsForTeacher(
+				ctx,
+				id,
+				&generated.GetSchoolsForTeacherParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+		{name: "sections", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetSectionsForTeacher(
+				ctx,
+				id,
+				&generated.GetSectionsForTeacherParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+		{name: "students", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetStudentsForTeacher(
+				ctx,
+				id,
+				&generated.GetStudentsForTeacherParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+	}
+}
+
+func sectionRelationships(
+	client *generated.Client,
+	id string,
+) []relationship {
+	return []relationship{
+		{name: "school", one: func(
+			ctx context.Context,
+		) (*http.Response, error) {
+			return client.GetSchoolForSection(ctx, id)
+		}},
+		{name: "teachers", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetTeachersForSection(
+				ctx,
+				id,
+				&generated.GetTeachersForSectionParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+		{name: "students", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetStudentsForSection(
+				ctx,
+				id,
+				&generated.GetStudentsForSectionParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+	}
+}
+
+func schoolRelationships(
+	client *generated.Client,
+	id string,
+) []relationship {
+	return []relationship{
+		{name: "sections", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetSectionsForSchool(
+				ctx,
+				id,
+				&generated.GetSectionsForSchoolParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+		{name: "teachers", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetTeachersForSchool(
+				ctx,
+				id,
+				&generated.GetTeachersForSchoolParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+		{name: "students", list: func(
+			ctx context.Context, limit int, startingAfter *string,
+		) (*http.Response, error) {
+			return client.GetStudentsForSchool(
+				ctx,
+				id,
+				&generated.GetStudentsForSchoolParams{
+					Limit:         &limit,
+					StartingAfter: startingAfter,
+				},
+			)
+		}},
+	}
+}
+
+// objectResponse is the body of every Clever API response for one entity
+type objectResponse struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+// listResponse is the body of every Clever API response for a page of
+// entities
+type listResponse struct {
+	Data  []objectResponse `json:"data"`
+	Links []generated.Link `json:"links"`
+}
+
+// fetchOne returns nil for an entity the app can't see
+func fetchOne(
+	ctx context.Context,
+	path string,
+	get one,
+) (map[string]interface{}, error) {
+	resp, err := get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if !rostering.IsHTTPSuccess(resp.StatusCode) {
+		return nil, fmt.Errorf(
+			"HTTP %d Error for Clever Request %s",
+			resp.StatusCode,
+			path,
+		)
+	}
+	body := objectResponse{}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err = dec.Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+func fetchAll(
+	ctx context.Context,
+	path string,
+	get list,
+	pageSize int,
+) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	var startingAfter *string
+	for {
+		resp, err := get(ctx, pageSize, startingAfter)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return records, nil
+		}
+		if !rostering.IsHTTPSuccess(resp.StatusCode) {
+			resp.Body.Close()
+			return nil, fmt.Errorf(
+				"HTTP %d Error for Clever Request %s",
+				resp.StatusCode,
+				path,
+			)
+		}
+		page := listResponse{}
+		dec := json.NewDecoder(resp.Body)
+		dec.UseNumber()
+		err = dec.Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Data {
+			if object.Data != nil {
+				records = append(records, object.Data)
+			}
+		}
+
+		startingAfter = nil
+		for _, link := range page.Links {
+			if link.Rel != nil && *link.Rel == "next" && link.Uri != nil {
+				sa := rostering.ParseLinkStartingAfter(*link.Uri)
+				startingAfter = &sa
+			}
+		}
+		if startingAfter == nil {
+			return records, nil
+		}
+	}
+}
+
+// Label names an entity for people: its name if it has one, else its ID
+func Label(record map[string]interface{}) string {
+	var name string
+	switch n := record["name"].(type) {
+	case string:
+		name = n
+	case map[string]interface{}:
+		var parts []string
+		for _, key := range []string{"first", "middle", "last"} {
+			if part, ok := n[key].(string); ok && part != "" {
+				parts = append(parts, part)
+			}
+		}
+		name = strings.Join(parts, " ")
+	}
+	if name == "" {
+		name, _ = record["section_number"].(string)
+	}
+	id, _ := record["id"].(string)
+	if name == "" {
+		return id
+	}
+	return name + " (" + id + ")"
+}
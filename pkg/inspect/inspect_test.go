@@ -0,0 +1,277 @@
+package inspect
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+)
+
+// fakeClever answers requests by path and query, and 404s everything else
+func fakeClever(
+	t *testing.T,
+	bodies map[string]string,
+) (*generated.Client, func()) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, ok := bodies[r.URL.RequestURI()]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(body))
+		},
+	))
+	client, err := generated.NewClient(server.URL + "/")
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return client, server.Close
+}
+
+func TestInspect(t *testing.T) {
+	client, closeServer := fakeClever(t, map[string]string{
+		"/sections/sec1": `{"data":{"id":"sec1","name":"Science 7",` +
+			`"grade":"7","period":3}}`,
+		"/sections/sec1/school": `{"data":` +
+			`{"id":"s1","name":"Adams"}}`,
+		"/sections/sec1/teachers?limit=2": `{"data":[]}`,
+		// Two pages of students, out of order
+		"/sections/sec1/students?limit=2": `{"data":[` +
+			`{"data":{"id":"st2","name":{"first":"Grace","last":"Hopper"}}},` +
+			`{"data":{"id":"st1","name":{"first":"Alan","last":"Turing"}}}` +
+			`],"links":[{"rel":"next",` +
+			`"uri":"/v2.1/sections/sec1/students?starting_after=st1"}]}`,
+		"/sections/sec1/students?limit=2&starting_after=st1": `{"data":[` +
+			`{"data":{"id":"st3","name":{"first":"Ada","last":"Lovelace"}}}` +
+			`]}`,
+	})
+	defer closeServer()
+
+	inspection, err := Inspect(
+		context.Background(),
+		client,
+		"section",
+		"sec1",
+		2,
+	)
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	if inspection.Record["name"] != "Science 7" {
+		t.Errorf("record = %v", inspection.Record)
+	}
+	got := map[string][]string{}
+	var names []string
+	for _, r := range inspection.Relationships {
+		names = append(names, r.Name)
+		got[r.Name] = []string{}
+		for _, record := range r.Records {
+			got[r.Name] = append(got[r.Name], Label(record))
+		}
+	}
+	if want := []string{"school", "teachers", "students"}; !reflect.DeepEqual(
+		names,
+		want,
+	) {
+		t.Errorf("relationships = %v, want %v", names, want)
+	}
+	want := map[string][]string{
+		"school":   {"Adams (s1)"},
+		"teachers": {},
+		"students": {
+			"Ada Lovelace (st3)",
+			"Alan Turing (st1)",
+			"Grace Hopper (st2)",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("related = %v, want %v", got, want)
+	}
+}
+
+func TestInspectNotVisible(t *testing.T) {
+	client, closeServer := fakeClever(t, map[string]string{})
+	defer closeServer()
+	inspection, err := Inspect(
+		context.Background(),
+		client,
+		"student",
+		"st9",
+		2,
+	)
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	if inspection.Record != nil || inspection.Relationships != nil {
+		t.Errorf("inspection = %+v, want nothing visible", inspection)
+	}
+}
+
+func TestInspectErrors(t *testing.T) {
+	client, closeServer := fakeClever(t, map[string]string{
+		"/teachers/t1": `{"data":{"id":"t1"}}`,
+	})
+	defer closeServer()
+	if _, err := Inspect(
+		context.Background(),
+		client,
+		"admin",
+		"a1",
+		2,
+	); err == nil {
+		t.Error("Inspect() of an unknown type succeeded")
+	}
+
+	// Every relationship 404s, which is as good as empty
+	inspection, err := Inspect(
+		context.Background(),
+		client,
+		"teacher",
+		"t1",
+		2,
+	)
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	for _, r := range inspection.Relationships {
+		if len(r.Records) != 0 {
+			t.Errorf("%s = %v, want none", r.Name, r.Records)
+		}
+	}
+}
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		record map[string]interface{}
+		want   string
+	}{
+		{
+			name:   "a name",
+			record: map[string]interface{}{"id": "s1", "name": "Adams"},
+			want:   "Adams (s1)",
+		},
+		{
+			name: "a person's name",
+			record: map[string]interface{}{
+				"id": "st1",
+				"name": map[string]interface{}{
+					"first":  "Grace",
+					"middle": "",
+					"last":   "Hopper",
+				},
+			},
+			want: "Grace Hopper (st1)",
+		},
+		{
+			name: "a section number",
+			record: map[string]interface{}{
+				"id":             "sec1",
+				"section_number": "7B",
+			},
+			want: "7B (sec1)",
+		},
+		{
+			name:   "no name, as with PII left out",
+			record: map[string]interface{}{"id": "st1"},
+			want:   "st1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Label(tt.record); got != tt.want {
+				t.Errorf("Label() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	grace := map[string]interface{}{
+		"id":   "st1",
+		"name": map[string]interface{}{"first": "Grace"},
+	}
+	inspections := []*Inspection{
+		{
+			Type: "section",
+			ID:   "sec1",
+			Record: map[string]interface{}{
+				"id":    "sec1",
+				"grade": "7",
+				"name":  strings.Repeat("x", 100),
+			},
+			Relationships: []Relationship{
+				{Name: "students", Records: []map[string]interface{}{grace}},
+			},
+		},
+		{
+			Type: "section",
+			ID:   "sec1",
+			Record: map[string]interface{}{
+				"id":    "sec1",
+				"grade": "8",
+				"name":  strings.Repeat("x", 100),
+			},
+			Relationships: []Relationship{{Name: "students"}},
+		},
+	}
+
+	var buf bytes.Buffer
+	differences, err := Print(
+		&buf,
+		[]string{"map_accelerator", "map_growth"},
+		inspections,
+		false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The grade, the student count and the student
+	if differences != 3 {
+		t.Errorf("Print() = %d differences, want 3:\n%s", differences, &buf)
+	}
+	truncated := strings.Repeat("x", maxCellWidth-3) + "..."
+	header := strings.SplitN(buf.String(), "\n", 2)[0]
+	if !strings.HasPrefix(strings.TrimSpace(header), "map_accelerator") ||
+		!strings.HasSuffix(header, "map_growth") {
+		t.Errorf("Print() header = %q, want the apps", header)
+	}
+	for _, want := range []string{
+		"\nsection sec1\n",
+		"  id       sec1",
+		"! grade    7",
+		"  name     " + truncated + "  " + truncated + "\n",
+		"\nstudents\n",
+		"! count    1",
+		"!          Grace (st1)",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Print() has no %q:\n%s", want, &buf)
+		}
+	}
+	if strings.Contains(buf.String(), colorRed) {
+		t.Error("Print() without color has color")
+	}
+
+	buf.Reset()
+	if _, err = Print(
+		&buf,
+		[]string{"map_accelerator", "map_growth"},
+		inspections,
+		true,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), colorRed+"! grade") {
+		t.Errorf("Print() with color has no red grade:\n%s", &buf)
+	}
+}
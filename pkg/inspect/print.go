@@ -0,0 +1,225 @@
+package inspect
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Khan/clever-repartee/pkg/export"
+)
+
+// maxCellWidth is how much of a value is shown, so that one long value
+// doesn't push the other apps' columns off screen
+const maxCellWidth = 60
+
+const (
+	colorRed   = "\x1b[31m"
+	colorReset = "\x1b[0m"
+)
+
+// table is a side-by-side view with one column per app
+type table struct {
+	sections []section
+}
+
+type section struct {
+	title string
+	rows  []row
+}
+
+type row struct {
+	label string
+	cells []string
+}
+
+// differs is true if the apps don't all see the same value
+func (r row) differs() bool {
+	for _, cell := range r.cells[1:] {
+		if cell != r.cells[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes what each app sees of the entity side by side, one column per
+// app, marking the rows that differ with "!" and, if color is set, in red.
+// It returns the number of rows that differ.
+func Print(
+	w io.Writer,
+	apps []string,
+	inspections []*Inspection,
+	color bool,
+) (int, error) {
+	t := newTable(inspections)
+
+	widths := make([]int, len(apps)+1)
+	for i, app := range apps {
+		widths[i+1] = len(app)
+	}
+	for _, s := range t.sections {
+		for _, r := range s.rows {
+			widths[0] = max(widths[0], len(r.label))
+			for i, cell := range r.cells {
+				widths[i+1] = max(widths[i+1], len(cell))
+			}
+		}
+	}
+
+	differences := 0
+	header := append([]string{""}, apps...)
+	if _, err := fmt.Fprintln(w, "  "+pad(header, widths)); err != nil {
+		return differences, err
+	}
+	for _, s := range t.sections {
+		if _, err := fmt.Fprintf(w, "\n%s\n", s.title); err != nil {
+			return differences, err
+		}
+		for _, r := range s.rows {
+			line := "  " + pad(append([]string{r.label}, r.cells...), widths)
+			if r.differs() {
+				differences++
+				line = "!" + line[1:]
+				if color {
+					line = colorRed + line + colorReset
+				}
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return differences, err
+			}
+		}
+	}
+	return differences, nil
+}
+
+func newTable(inspections []*Inspection) table {
+	first := inspections[0]
+	t := table{}
+
+	// The record, field by field
+	records := section{title: first.Type + " " + first.ID}
+	visible := row{label: "visible"}
+	flattened := make([]map[string]string, len(inspections))
+	fields := map[string]bool{}
+	for i, inspection := range inspections {
+		visible.cells = append(
+			visible.cells,
+			strconv.FormatBool(inspection.Record != nil),
+		)
+		flattened[i] = map[string]string{}
+		if inspection.Record != nil {
+			flattened[i] = export.Flatten(inspection.Record)
+		}
+		for field := range flattened[i] {
+			fields[field] = true
+		}
+	}
+	records.rows = append(records.rows, visible)
+	for _, field := range sortedKeys(fields) {
+		r := row{label: field}
+		for i := range inspections {
+			r.cells = append(r.cells, truncate(flattened[i][field]))
+		}
+		records.rows = append(records.rows, r)
+	}
+	t.sections = append(t.sections, records)
+
+	// Each relationship, one row per related entity
+	for _, name := range relationshipNames(inspections) {
+		labels := map[string]string{}
+		seen := make([]map[string]bool, len(inspections))
+		counts := make([]string, len(inspections))
+		for i, inspection := range inspections {
+			seen[i] = map[string]bool{}
+			for _, r := range inspection.Relationships {
+				if r.Name != name {
+					continue
+				}
+				for _, record := range r.Records {
+					id, _ := record["id"].(string)
+					seen[i][id] = true
+					labels[id] = Label(record)
+				}
+			}
+			counts[i] = strconv.Itoa(len(seen[i]))
+		}
+
+		s := section{title: name}
+		s.rows = append(s.rows, row{label: "count", cells: counts})
+		ids := make([]string, 0, len(labels))
+		for id := range labels {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			return labels[ids[i]] < labels[ids[j]]
+		})
+		for _, id := range ids {
+			r := row{label: ""}
+			for i := range inspections {
+				cell := "-"
+				if seen[i][id] {
+					cell = truncate(labels[id])
+				}
+				r.cells = append(r.cells, cell)
+			}
+			s.rows = append(s.rows, r)
+		}
+		t.sections = append(t.sections, s)
+	}
+	return t
+}
+
+// relationshipNames are every relationship any app returned, in the order
+// they were fetched
+func relationshipNames(inspections []*Inspection) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, inspection := range inspections {
+		for _, r := range inspection.Relationships {
+			if !seen[r.Name] {
+				seen[r.Name] = true
+				names = append(names, r.Name)
+			}
+		}
+	}
+	return names
+}
+
+// sortedKeys puts id first and sorts the rest
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for key := range set {
+		if key != "id" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if set["id"] {
+		keys = append([]string{"id"}, keys...)
+	}
+	return keys
+}
+
+func truncate(value string) string {
+	if len(value) <= maxCellWidth {
+		return value
+	}
+	return value[:maxCellWidth-3] + "..."
+}
+
+func pad(cells []string, widths []int) string {
+	padded := make([]string, len(cells))
+	for i, cell := range cells {
+		padded[i] = cell + strings.Repeat(" ", widths[i]-len(cell))
+	}
+	return strings.TrimRight(strings.Join(padded, "  "), " ")
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
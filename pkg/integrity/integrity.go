@@ -0,0 +1,422 @@
+// Package integrity checks that the references between the records of one
+// app's roster, such as a section's teacher or a student's school, are to
+// records in the roster
+package integrity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// Entity types, named as in the metrics
+const (
+	TypeDistrict      = "district"
+	TypeSchool        = "school"
+	TypeStudent       = "student"
+	TypeTeacher       = "teacher"
+	TypeSection       = "section"
+	TypeDistrictAdmin = "district_admin"
+	TypeSchoolAdmin   = "school_admin"
+	TypeTerm          = "term"
+	TypeCourse        = "course"
+)
+
+// Dangling is a reference to a record that isn't in the roster
+type Dangling struct {
+	// Type and ID are of the record with the reference
+	Type string
+	ID   string
+	// Field is the reference's JSON field, such as teachers or term_id
+	Field string
+	// Target is the type of record referred to
+	Target string
+	// Ref is the ID referred to
+	Ref string
+	// School is the ID of the referring record's school, if it has one
+	School     string `json:",omitempty"`
+	SchoolName string `json:",omitempty"`
+}
+
+// Reference is a field that refers to another type, with how many of its
+// references dangle
+type Reference struct {
+	Type   string
+	Field  string
+	Target string
+	// Checked is how many references there are, Dangling how many of them
+	// aren't in the roster
+	Checked  int
+	Dangling int
+}
+
+// SchoolCount is how many dangling references the records of a school have
+type SchoolCount struct {
+	School     string
+	SchoolName string `json:",omitempty"`
+	Dangling   int
+}
+
+// Report is every dangling reference in a roster
+type Report struct {
+	// References are the fields checked, by type and field
+	References []Reference
+	// Unchecked are the fields that couldn't be checked, since the roster
+	// wasn't given the type they refer to, as type.field
+	Unchecked []string
+	// BySchool counts the dangling references by the school of the records
+	// with them, most first
+	BySchool []SchoolCount
+	// Dangling are the dangling references by type, school name, ID and
+	// field
+	Dangling []Dangling
+}
+
+// Check finds every reference between the records of the roster that is to
+// a record not in it. Empty references aren't dangling, and references to
+// types the roster has no list of, such as terms and courses unless they
+// were fetched, aren't checked.
+func Check(roster *rostering.Roster) *Report {
+	c := newChecker(roster)
+
+	if roster.Schools != nil {
+		for _, school := range *roster.Schools {
+			r := c.record(TypeSchool, school.Id, school.Id)
+			r.one("district", TypeDistrict, school.District)
+		}
+	}
+	if roster.Students != nil {
+		for _, student := range *roster.Students {
+			r := c.record(TypeStudent, student.Id, student.School)
+			r.one("district", TypeDistrict, student.District)
+			r.one("school", TypeSchool, student.School)
+			r.many("schools", TypeSchool, student.Schools)
+			if student.Enrollments != nil {
+				for _, enrollment := range *student.Enrollments {
+					r.one("enrollments.school", TypeSchool, enrollment.School)
+				}
+			}
+		}
+	}
+	if roster.Teachers != nil {
+		for _, teacher := range *roster.Teachers {
+			r := c.record(TypeTeacher, teacher.Id, teacher.School)
+			r.one("district", TypeDistrict, teacher.District)
+			r.one("school", TypeSchool, teacher.School)
+			r.many("schools", TypeSchool, teacher.Schools)
+		}
+	}
+	if roster.Sections != nil {
+		for _, section := range *roster.Sections {
+			r := c.record(TypeSection, section.Id, section.School)
+			r.one("district", TypeDistrict, section.District)
+			r.one("school", TypeSchool, section.School)
+			r.one("teacher", TypeTeacher, section.Teacher)
+			r.many("teachers", TypeTeacher, section.Teachers)
+			r.many("students", TypeStudent, section.Students)
+			r.one("term_id", TypeTerm, section.TermId)
+			r.one("course", TypeCourse, section.Course)
+		}
+	}
+	if roster.DistrictAdmins != nil {
+		for _, admin := range *roster.DistrictAdmins {
+			r := c.record(TypeDistrictAdmin, admin.Id, nil)
+			r.one("district", TypeDistrict, admin.District)
+		}
+	}
+	if roster.SchoolAdmins != nil {
+		for _, admin := range *roster.SchoolAdmins {
+			r := c.record(TypeSchoolAdmin, admin.Id, nil)
+			r.one("district", TypeDistrict, admin.District)
+			r.many("schools", TypeSchool, admin.Schools)
+		}
+	}
+	if roster.Terms != nil {
+		for _, term := range *roster.Terms {
+			r := c.record(TypeTerm, term.Id, nil)
+			r.one("district", TypeDistrict, term.District)
+		}
+	}
+	if roster.Courses != nil {
+		for _, course := range *roster.Courses {
+			r := c.record(TypeCourse, course.Id, nil)
+			r.one("district", TypeDistrict, course.District)
+		}
+	}
+
+	return c.report()
+}
+
+// checker collects the references of a roster as it is walked
+type checker struct {
+	// ids are the IDs of each type in the roster, nil for the types it
+	// wasn't given
+	ids         map[string]map[string]bool
+	schoolNames map[string]string
+	references  map[[2]string]*Reference
+	unchecked   map[string]bool
+	dangling    []Dangling
+}
+
+func newChecker(roster *rostering.Roster) *checker {
+	c := &checker{
+		ids:         map[string]map[string]bool{},
+		schoolNames: map[string]string{},
+		references:  map[[2]string]*Reference{},
+		unchecked:   map[string]bool{},
+	}
+	// A type with an empty list is known to have no records, so any
+	// reference to it dangles
+	known := func(typ string) map[string]bool {
+		c.ids[typ] = map[string]bool{}
+		return c.ids[typ]
+	}
+	add := func(ids map[string]bool, id *string) {
+		if id != nil {
+			ids[*id] = true
+		}
+	}
+	if roster.Districts != nil {
+		ids := known(TypeDistrict)
+		for _, district := range *roster.Districts {
+			add(ids, district.Id)
+		}
+	}
+	if roster.Schools != nil {
+		ids := known(TypeSchool)
+		for _, school := range *roster.Schools {
+			add(ids, school.Id)
+			if school.Id != nil && school.Name != nil {
+				c.schoolNames[*school.Id] = *school.Name
+			}
+		}
+	}
+	if roster.Students != nil {
+		ids := known(TypeStudent)
+		for _, student := range *roster.Students {
+			add(ids, student.Id)
+		}
+	}
+	if roster.Teachers != nil {
+		ids := known(TypeTeacher)
+		for _, teacher := range *roster.Teachers {
+			add(ids, teacher.Id)
+		}
+	}
+	if roster.Terms != nil {
+		ids := known(TypeTerm)
+		for _, term := range *roster.Terms {
+			add(ids, term.Id)
+		}
+	}
+	if roster.Courses != nil {
+		ids := known(TypeCourse)
+		for _, course := range *roster.Courses {
+			add(ids, course.Id)
+		}
+	}
+	return c
+}
+
+// recordRefs checks the references of one record
+type recordRefs struct {
+	c      *checker
+	typ    string
+	id     string
+	school string
+}
+
+func (c *checker) record(typ string, id, school *string) recordRefs {
+	return recordRefs{c: c, typ: typ, id: deref(id), school: deref(school)}
+}
+
+// one checks a reference, if it is set
+func (r recordRefs) one(field, target string, ref *string) {
+	ids := r.c.ids[target]
+	if ids == nil {
+		r.c.unchecked[r.typ+"."+field] = true
+		return
+	}
+	key := [2]string{r.typ, field}
+	reference := r.c.references[key]
+	if reference == nil {
+		reference = &Reference{Type: r.typ, Field: field, Target: target}
+		r.c.references[key] = reference
+	}
+	if ref == nil || *ref == "" {
+		return
+	}
+	reference.Checked++
+	if ids[*ref] {
+		return
+	}
+	reference.Dangling++
+	r.c.dangling = append(r.c.dangling, Dangling{
+		Type:       r.typ,
+		ID:         r.id,
+		Field:      field,
+		Target:     target,
+		Ref:        *ref,
+		School:     r.school,
+		SchoolName: r.c.schoolNames[r.school],
+	})
+}
+
+// many checks each of a list of references
+func (r recordRefs) many(field, target string, refs *[]string) {
+	if refs == nil || len(*refs) == 0 {
+		// Still counts the field as checked, or not
+		r.one(field, target, nil)
+		return
+	}
+	for i := range *refs {
+		r.one(field, target, &(*refs)[i])
+	}
+}
+
+func (c *checker) report() *Report {
+	report := &Report{
+		References: []Reference{},
+		Unchecked:  []string{},
+		BySchool:   []SchoolCount{},
+		Dangling:   c.dangling,
+	}
+	if report.Dangling == nil {
+		report.Dangling = []Dangling{}
+	}
+	for _, reference := range c.references {
+		report.References = append(report.References, *reference)
+	}
+	sort.Slice(report.References, func(i, j int) bool {
+		a, b := report.References[i], report.References[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Field < b.Field
+	})
+	for field := range c.unchecked {
+		report.Unchecked = append(report.Unchecked, field)
+	}
+	sort.Strings(report.Unchecked)
+
+	bySchool := map[string]int{}
+	for _, d := range report.Dangling {
+		bySchool[d.School]++
+	}
+	for school, n := range bySchool {
+		report.BySchool = append(report.BySchool, SchoolCount{
+			School:     school,
+			SchoolName: c.schoolNames[school],
+			Dangling:   n,
+		})
+	}
+	sort.Slice(report.BySchool, func(i, j int) bool {
+		a, b := report.BySchool[i], report.BySchool[j]
+		if a.Dangling != b.Dangling {
+			return a.Dangling > b.Dangling
+		}
+		return a.School < b.School
+	})
+
+	sort.SliceStable(report.Dangling, func(i, j int) bool {
+		a, b := report.Dangling[i], report.Dangling[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.SchoolName != b.SchoolName {
+			return a.SchoolName < b.SchoolName
+		}
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		return a.Field < b.Field
+	})
+	return report
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// WriteTable writes a summary line, the references checked by type and
+// field, the dangling references by school, and one aligned row per
+// dangling reference
+func (r *Report) WriteTable(w io.Writer) error {
+	fmt.Fprintf(w, "%d dangling references\n", len(r.Dangling))
+	if len(r.Unchecked) > 0 {
+		fmt.Fprintf(w, "Not checked, no records to refer to: %v\n", r.Unchecked)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tFIELD\tREFERS TO\tCHECKED\tDANGLING")
+	for _, ref := range r.References {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%d\t%d\n",
+			ref.Type,
+			ref.Field,
+			ref.Target,
+			ref.Checked,
+			ref.Dangling,
+		)
+	}
+	if flushErr := tw.Flush(); flushErr != nil {
+		return flushErr
+	}
+	if len(r.Dangling) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCHOOL\tNAME\tDANGLING")
+	for _, s := range r.BySchool {
+		school := s.School
+		if school == "" {
+			school = "(none)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", school, s.SchoolName, s.Dangling)
+	}
+	if flushErr := tw.Flush(); flushErr != nil {
+		return flushErr
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tID\tSCHOOL\tFIELD\tMISSING")
+	for _, d := range r.Dangling {
+		school := d.SchoolName
+		if school == "" {
+			school = d.School
+		}
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s %s\n",
+			d.Type,
+			d.ID,
+			school,
+			d.Field,
+			d.Target,
+			d.Ref,
+		)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the report as one indented JSON document
+func (r *Report) WriteJSON(w io.Writer) error {
+	content, err := json.MarshalIndent(r, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", content)
+	return err
+}
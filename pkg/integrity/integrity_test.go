@@ -0,0 +1,230 @@
+package integrity
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+func str(s string) *string { return &s }
+
+func strs(s ...string) *[]string { return &s }
+
+// testRoster is a district with two schools, Adams and Lincoln, a teacher
+// and a student at each, and a section at Adams taught by the first teacher
+func testRoster() *rostering.Roster {
+	return &rostering.Roster{
+		Districts: &[]generated.District{{Id: str("d1")}},
+		Schools: &[]generated.School{
+			{Id: str("s1"), Name: str("Adams"), District: str("d1")},
+			{Id: str("s2"), Name: str("Lincoln"), District: str("d1")},
+		},
+		Students: &[]generated.Student{
+			{
+				Id:       str("st1"),
+				District: str("d1"),
+				School:   str("s1"),
+				Schools:  strs("s1"),
+			},
+			{
+				Id:       str("st2"),
+				District: str("d1"),
+				School:   str("s2"),
+				Schools:  strs("s2"),
+			},
+		},
+		Teachers: &[]generated.Teacher{
+			{Id: str("t1"), District: str("d1"), School: str("s1")},
+			{Id: str("t2"), District: str("d1"), School: str("s2")},
+		},
+		Sections: &[]generated.Section{{
+			Id:       str("sec1"),
+			District: str("d1"),
+			School:   str("s1"),
+			Teacher:  str("t1"),
+			Teachers: strs("t1"),
+			Students: strs("st1"),
+		}},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r *rostering.Roster)
+		// want are the dangling references as type, ID, field and ref
+		want          [][4]string
+		wantUnchecked []string
+		wantBySchool  []SchoolCount
+	}{
+		{
+			name:          "every reference is in the roster",
+			wantUnchecked: []string{"section.course", "section.term_id"},
+			wantBySchool:  []SchoolCount{},
+		},
+		{
+			name: "dangling references",
+			modify: func(r *rostering.Roster) {
+				section := &(*r.Sections)[0]
+				section.Teachers = strs("t1", "t9")
+				section.Students = strs("st1", "st9")
+				(*r.Students)[1].Schools = strs("s2", "s9")
+			},
+			want: [][4]string{
+				{TypeSection, "sec1", "students", "st9"},
+				{TypeSection, "sec1", "teachers", "t9"},
+				{TypeStudent, "st2", "schools", "s9"},
+			},
+			wantUnchecked: []string{"section.course", "section.term_id"},
+			wantBySchool: []SchoolCount{
+				{School: "s1", SchoolName: "Adams", Dangling: 2},
+				{School: "s2", SchoolName: "Lincoln", Dangling: 1},
+			},
+		},
+		{
+			name: "empty references aren't dangling",
+			modify: func(r *rostering.Roster) {
+				section := &(*r.Sections)[0]
+				section.Teacher = str("")
+				section.Teachers = nil
+				section.Students = strs()
+				section.TermId = str("")
+			},
+			wantUnchecked: []string{"section.course", "section.term_id"},
+			wantBySchool:  []SchoolCount{},
+		},
+		{
+			name: "references to types not fetched aren't checked",
+			modify: func(r *rostering.Roster) {
+				r.Teachers = nil
+				(*r.Sections)[0].TermId = str("term9")
+			},
+			wantUnchecked: []string{
+				"section.course",
+				"section.teacher",
+				"section.teachers",
+				"section.term_id",
+			},
+			wantBySchool: []SchoolCount{},
+		},
+		{
+			name: "an empty list is known to have no records",
+			modify: func(r *rostering.Roster) {
+				r.Terms = &[]generated.Term{}
+				r.Courses = &[]generated.Course{{Id: str("c1")}}
+				(*r.Sections)[0].TermId = str("term9")
+				(*r.Sections)[0].Course = str("c1")
+			},
+			want: [][4]string{
+				{TypeSection, "sec1", "term_id", "term9"},
+			},
+			wantUnchecked: []string{},
+			wantBySchool: []SchoolCount{
+				{School: "s1", SchoolName: "Adams", Dangling: 1},
+			},
+		},
+		{
+			name: "schools ordered by count, then ID",
+			modify: func(r *rostering.Roster) {
+				// st2 and t2 at Lincoln dangle once each, st1 at Adams
+				// once, and a student at an unknown school twice
+				(*r.Students)[0].District = str("d9")
+				(*r.Students)[1].District = str("d9")
+				(*r.Teachers)[1].District = str("d9")
+				*r.Students = append(*r.Students, generated.Student{
+					Id:      str("st3"),
+					School:  str("s9"),
+					Schools: strs("s9"),
+				})
+			},
+			want: [][4]string{
+				{TypeStudent, "st3", "school", "s9"},
+				{TypeStudent, "st3", "schools", "s9"},
+				{TypeStudent, "st1", "district", "d9"},
+				{TypeStudent, "st2", "district", "d9"},
+				{TypeTeacher, "t2", "district", "d9"},
+			},
+			wantUnchecked: []string{"section.course", "section.term_id"},
+			wantBySchool: []SchoolCount{
+				{School: "s2", SchoolName: "Lincoln", Dangling: 2},
+				{School: "s9", Dangling: 2},
+				{School: "s1", SchoolName: "Adams", Dangling: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roster := testRoster()
+			if tt.modify != nil {
+				tt.modify(roster)
+			}
+			report := Check(roster)
+
+			got := [][4]string{}
+			for _, d := range report.Dangling {
+				got = append(got, [4]string{d.Type, d.ID, d.Field, d.Ref})
+			}
+			want := tt.want
+			if want == nil {
+				want = [][4]string{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("dangling = %v, want %v", got, want)
+			}
+			if !reflect.DeepEqual(report.Unchecked, tt.wantUnchecked) {
+				t.Errorf(
+					"unchecked = %v, want %v",
+					report.Unchecked,
+					tt.wantUnchecked,
+				)
+			}
+			if !reflect.DeepEqual(report.BySchool, tt.wantBySchool) {
+				t.Errorf(
+					"by school = %+v, want %+v",
+					report.BySchool,
+					tt.wantBySchool,
+				)
+			}
+
+			dangling := 0
+			for _, ref := range report.References {
+				dangling += ref.Dangling
+				if ref.Dangling > ref.Checked {
+					t.Errorf("%+v has more dangling than checked", ref)
+				}
+			}
+			if dangling != len(report.Dangling) {
+				t.Errorf(
+					"references count %d dangling, want %d",
+					dangling,
+					len(report.Dangling),
+				)
+			}
+		})
+	}
+}
+
+func TestCheckCounts(t *testing.T) {
+	roster := testRoster()
+	(*roster.Sections)[0].Students = strs("st1", "st2", "st9")
+	report := Check(roster)
+
+	want := Reference{
+		Type:     TypeSection,
+		Field:    "students",
+		Target:   TypeStudent,
+		Checked:  3,
+		Dangling: 1,
+	}
+	for _, ref := range report.References {
+		if ref.Type == want.Type && ref.Field == want.Field {
+			if ref != want {
+				t.Errorf("reference = %+v, want %+v", ref, want)
+			}
+			return
+		}
+	}
+	t.Errorf("no section.students reference in %+v", report.References)
+}
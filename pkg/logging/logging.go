@@ -0,0 +1,108 @@
+// Package logging builds the zap logger every command logs through, as
+// coloured console output for people or JSON for Stackdriver
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Formats
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// DefaultLevel leaves out the debug lines, one for every Clever API request
+const DefaultLevel = "info"
+
+// Fields of the run-scoped logger
+const (
+	FieldRunID    = "run_id"
+	FieldCommand  = "command"
+	FieldDistrict = "district"
+	FieldApp      = "app"
+)
+
+// New builds a logger in the format at the level, one of debug, info, warn
+// or error. Stack traces are only added to errors.
+func New(format, level string) (*zap.Logger, error) {
+	var atomicLevel zap.AtomicLevel
+	if levelErr := atomicLevel.UnmarshalText([]byte(level)); levelErr != nil {
+		return nil, fmt.Errorf(
+			"invalid log level %q, must be debug, info, warn or error",
+			level,
+		)
+	}
+
+	var config zap.Config
+	switch strings.ToLower(format) {
+	case FormatConsole:
+		config = zap.NewDevelopmentConfig()
+	case FormatJSON:
+		config = zap.NewProductionConfig()
+		config.EncoderConfig = stackdriverEncoderConfig()
+		// Every line counts when a run goes wrong
+		config.Sampling = nil
+	default:
+		return nil, fmt.Errorf(
+			"invalid log format %q, must be %s or %s",
+			format,
+			FormatConsole,
+			FormatJSON,
+		)
+	}
+	config.Level = atomicLevel
+	config.DisableStacktrace = true
+	return config.Build(zap.AddStacktrace(zapcore.ErrorLevel))
+}
+
+// stackdriverEncoderConfig names the fields as Stackdriver expects, so that
+// GKE logs get the right severity
+func stackdriverEncoderConfig() zapcore.EncoderConfig {
+	config := zap.NewProductionEncoderConfig()
+	config.TimeKey = "time"
+	config.LevelKey = "severity"
+	config.MessageKey = "message"
+	config.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	config.EncodeLevel = encodeSeverity
+	config.EncodeDuration = zapcore.StringDurationEncoder
+	return config
+}
+
+// encodeSeverity writes the Stackdriver LogSeverity of the level
+func encodeSeverity(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	switch level {
+	case zapcore.DebugLevel:
+		enc.AppendString("DEBUG")
+	case zapcore.InfoLevel:
+		enc.AppendString("INFO")
+	case zapcore.WarnLevel:
+		enc.AppendString("WARNING")
+	case zapcore.ErrorLevel:
+		enc.AppendString("ERROR")
+	case zapcore.DPanicLevel:
+		enc.AppendString("CRITICAL")
+	case zapcore.PanicLevel:
+		enc.AppendString("ALERT")
+	case zapcore.FatalLevel:
+		enc.AppendString("EMERGENCY")
+	default:
+		enc.AppendString("DEFAULT")
+	}
+}
+
+// NewRunID identifies one run of a command in its logs
+func NewRunID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand only fails if the OS has no randomness to give
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
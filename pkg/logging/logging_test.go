@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		want    zapcore.Level
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			format: FormatConsole,
+			level:  DefaultLevel,
+			want:   zapcore.InfoLevel,
+		},
+		{
+			name:   "json at debug",
+			format: FormatJSON,
+			level:  "debug",
+			want:   zapcore.DebugLevel,
+		},
+		{
+			name:   "format ignores case",
+			format: "JSON",
+			level:  "warn",
+			want:   zapcore.WarnLevel,
+		},
+		{
+			name:   "level ignores case",
+			format: FormatConsole,
+			level:  "ERROR",
+			want:   zapcore.ErrorLevel,
+		},
+		{name: "unknown format", format: "text", level: "info", wantErr: true},
+		{name: "no format", format: "", level: "info", wantErr: true},
+		{
+			name:    "unknown level",
+			format:  FormatJSON,
+			level:   "verbose",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := New(tt.format, tt.level)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			core := logger.Core()
+			if !core.Enabled(tt.want) || core.Enabled(tt.want-1) {
+				t.Errorf("least severe level logged isn't %s", tt.want)
+			}
+		})
+	}
+}
+
+func TestStackdriverEncoder(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  string
+	}{
+		{level: zapcore.DebugLevel, want: "DEBUG"},
+		{level: zapcore.InfoLevel, want: "INFO"},
+		{level: zapcore.WarnLevel, want: "WARNING"},
+		{level: zapcore.ErrorLevel, want: "ERROR"},
+		{level: zapcore.DPanicLevel, want: "CRITICAL"},
+		{level: zapcore.PanicLevel, want: "ALERT"},
+		{level: zapcore.FatalLevel, want: "EMERGENCY"},
+	}
+	encoder := zapcore.NewJSONEncoder(stackdriverEncoderConfig())
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			buf, err := encoder.EncodeEntry(zapcore.Entry{
+				Level:   tt.level,
+				Time:    time.Date(2020, 9, 1, 6, 0, 0, 0, time.UTC),
+				Message: "Running diff",
+			}, []zapcore.Field{zap.Duration("took", time.Second)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if jsonErr := json.Unmarshal(buf.Bytes(), &got); jsonErr != nil {
+				t.Fatalf("invalid JSON %s: %v", buf.Bytes(), jsonErr)
+			}
+			want := map[string]string{
+				"severity": tt.want,
+				"time":     "2020-09-01T06:00:00Z",
+				"message":  "Running diff",
+				"took":     "1s",
+			}
+			for key, value := range want {
+				if got[key] != value {
+					t.Errorf("%s = %v, want %s", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	first, second := NewRunID(), NewRunID()
+	if len(first) != 16 {
+		t.Errorf("run ID %q, want 16 hex digits", first)
+	}
+	if first == second {
+		t.Errorf("two run IDs are both %q", first)
+	}
+}
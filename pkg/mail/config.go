@@ -0,0 +1,181 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSMode controls how the connection to the SMTP server is secured
+type TLSMode string
+
+const (
+	// TLSModeStartTLS connects in plain text and upgrades with STARTTLS
+	// (usually port 587). The upgrade is mandatory.
+	TLSModeStartTLS TLSMode = "starttls"
+	// TLSModeImplicit connects with TLS from the start (usually port 465)
+	TLSModeImplicit TLSMode = "tls"
+	// TLSModeNone never uses TLS, e.g. for a relay on localhost (port 25)
+	TLSModeNone TLSMode = "none"
+)
+
+// AuthMechanism is the SMTP AUTH mechanism used to log in
+type AuthMechanism string
+
+const (
+	AuthPlain   AuthMechanism = "plain"
+	AuthLogin   AuthMechanism = "login"
+	AuthCRAMMD5 AuthMechanism = "cram-md5"
+	// AuthNone skips SMTP AUTH entirely, e.g. for an open local relay
+	AuthNone AuthMechanism = "none"
+)
+
+const (
+	defaultHost = "smtp.gmail.com"
+)
+
+// Config describes how to reach the SMTP server and who the mail is from
+type Config struct {
+	// Host is the SMTP server hostname
+	Host string
+	// Port is the SMTP server port. Defaults according to TLSMode.
+	Port string
+	// TLSMode is one of starttls, tls or none
+	TLSMode TLSMode
+	// Auth is one of plain, login, cram-md5 or none
+	Auth AuthMechanism
+	// Username to log in with. Defaults to From.
+	Username string
+	// Password (or secret for cram-md5) to log in with
+	Password string
+	// HeloName is the name we announce in EHLO. Defaults to localhost.
+	HeloName string
+
+	// From is the sender address used in both the envelope and the header
+	From string
+	// FromName is the optional display name for From
+	FromName string
+	// ReplyTo is an optional address replies should go to instead of From
+	ReplyTo string
+}
+
+// ConfigFromEnv reads the SMTP settings from the environment, defaulting to
+// Gmail with STARTTLS so existing deployments keep working:
+//
+//	SMTP_HOST      defaults to smtp.gmail.com
+//	SMTP_PORT      defaults to 587, 465 or 25 depending on SMTP_TLS
+//	SMTP_TLS       starttls (default), tls or none
+//	SMTP_AUTH      plain (default), login, cram-md5 or none
+//	SMTP_USERNAME  defaults to FROM_EMAIL
+//	SMTP_PASSWORD  falls back to GMAIL_PASSWORD
+//	SMTP_HELO      defaults to localhost
+//	FROM_EMAIL     sender address
+//	FROM_NAME      sender display name
+//	REPLY_TO_EMAIL optional Reply-To address
+func ConfigFromEnv() (*Config, error) {
+	config := readConfigFromEnv()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SenderConfigFromEnv reads the same settings as ConfigFromEnv but only
+// requires the sender, for previewing messages without an SMTP server
+func SenderConfigFromEnv() (*Config, error) {
+	config := readConfigFromEnv()
+	if err := config.ValidateSender(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func readConfigFromEnv() *Config {
+	config := &Config{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		TLSMode:  TLSMode(strings.ToLower(os.Getenv("SMTP_TLS"))),
+		Auth:     AuthMechanism(strings.ToLower(os.Getenv("SMTP_AUTH"))),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		HeloName: os.Getenv("SMTP_HELO"),
+		From:     os.Getenv("FROM_EMAIL"),
+		FromName: os.Getenv("FROM_NAME"),
+		ReplyTo:  os.Getenv("REPLY_TO_EMAIL"),
+	}
+	if config.Password == "" {
+		config.Password = os.Getenv("GMAIL_PASSWORD")
+	}
+	config.SetDefaults()
+	return config
+}
+
+// SetDefaults fills in any unset fields with their default values
+func (c *Config) SetDefaults() {
+	if c.Host == "" {
+		c.Host = defaultHost
+	}
+	if c.TLSMode == "" {
+		c.TLSMode = TLSModeStartTLS
+	}
+	if c.Port == "" {
+		switch c.TLSMode {
+		case TLSModeImplicit:
+			c.Port = "465"
+		case TLSModeNone:
+			c.Port = "25"
+		default:
+			c.Port = "587"
+		}
+	}
+	if c.Auth == "" {
+		c.Auth = AuthPlain
+	}
+	if c.Username == "" {
+		c.Username = c.From
+	}
+	if c.HeloName == "" {
+		c.HeloName = "localhost"
+	}
+}
+
+// Validate reports the first problem with the configuration, if any
+func (c *Config) Validate() error {
+	switch c.TLSMode {
+	case TLSModeStartTLS, TLSModeImplicit, TLSModeNone:
+	default:
+		return fmt.Errorf(
+			"invalid SMTP TLS mode %q, must be starttls, tls or none",
+			c.TLSMode,
+		)
+	}
+	switch c.Auth {
+	case AuthPlain, AuthLogin, AuthCRAMMD5:
+		if c.Password == "" {
+			return fmt.Errorf(
+				"SMTP auth %s requires a password (SMTP_PASSWORD)",
+				c.Auth,
+			)
+		}
+	case AuthNone:
+	default:
+		return fmt.Errorf(
+			"invalid SMTP auth %q, must be plain, login, cram-md5 or none",
+			c.Auth,
+		)
+	}
+	return c.ValidateSender()
+}
+
+// ValidateSender checks only the sender, which is all a preview needs
+func (c *Config) ValidateSender() error {
+	if c.From == "" {
+		return fmt.Errorf("a sender address (FROM_EMAIL) is required")
+	}
+	return nil
+}
+
+// Addr is the host:port of the SMTP server
+func (c *Config) Addr() string {
+	return fmt.Sprintf("%s:%s", c.Host, c.Port)
+}
@@ -1,136 +1,145 @@
 package mail
 
 import (
-	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"html/template"
-	"mime/quotedprintable"
 	"net/smtp"
+	"strings"
 )
 
 // Mail is a generic function to send email
-func Mail(
-	fromEmail, toEmail, password, host, port, subject, bodyMessage string,
-) error {
-	addr := fmt.Sprintf("%s:%s", host, port)
-	// PlainAuth will only send the credentials if the connection is using TLS
-	// or is connected to localhost.
-	auth := smtp.PlainAuth("", fromEmail, password, host)
-
-	header := make(map[string]string)
-
-	header["From"] = fromEmail
-	header["To"] = toEmail
-	header["Subject"] = subject
+func Mail(config *Config, message *Message) error {
+	if message.Empty() {
+		return errors.New("message has no recipients")
+	}
+	finalMessage, err := message.Bytes()
+	if err != nil {
+		return err
+	}
 
-	header["MIME-Version"] = "1.0"
-	header["Content-Type"] = fmt.Sprintf("%s; charset=\"utf-8\"", "text/html")
-	header["Content-Transfer-Encoding"] = "quoted-printable"
-	header["Content-Disposition"] = "inline"
+	client, err := dial(config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
 
-	headerMessage := ""
-	for key, value := range header {
-		headerMessage += fmt.Sprintf("%s: %s\r\n", key, value)
+	if err = client.Hello(config.HeloName); err != nil {
+		return err
+	}
+	if config.TLSMode == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf(
+				"SMTP server %s does not support STARTTLS",
+				config.Addr(),
+			)
+		}
+		err = client.StartTLS(&tls.Config{ServerName: config.Host})
+		if err != nil {
+			return err
+		}
+	}
+	if auth := config.smtpAuth(); auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf(
+				"SMTP server %s does not support AUTH",
+				config.Addr(),
+			)
+		}
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
 	}
 
-	finalMessage := headerMessage + "\r\n" + bodyMessage
-	sendErr := smtp.SendMail(
-		addr,
-		auth,
-		fromEmail,
-		[]string{toEmail},
-		[]byte(finalMessage),
-	)
-	if sendErr != nil {
-		return sendErr
+	if err = client.Mail(message.From.Address); err != nil {
+		return err
+	}
+	for _, recipient := range message.All() {
+		if err = client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(finalMessage); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
 	}
 
-	return nil
+	return client.Quit()
 }
 
-// NewSummaryMailBody is specific to the PullTestResults
-func NewSummaryMailBody(summary *MissingReport) (string, error) {
-	const htmlTmpl = `
-  <hr style=
-  "border: 0;
-height: 1px;
-background-image: linear-gradient(to right, rgba(0, 0, 0, 0), rgba(0, 0, 0, 0.75), rgba(0, 0, 0, 0));
-" />
-
-
-  <h3>&#129335;District {{.DistrictName}} CleverID {{.DistrictCleverID}} was missing these Clever IDs:</h3>
-  <h4>Student Clever IDs</h4>
-  <ul>
-    <li style="list-style: none">{{range .MissingStudentCleverIDs}}</li>
-
-    <li>{{.}}</li>
-
-    <li style="list-style: none">{{end}}</li>
-  </ul>
-
-  <hr style=
-  "border: 0;
-height: 1px;
-background-image: linear-gradient(to right, rgba(0, 0, 0, 0), rgba(0, 0, 0, 0.75), rgba(0, 0, 0, 0));
-" />
-
-  <h4>Teacher Clever IDs</h4>
-  <ul>
-    <li style="list-style: none">{{range .MissingTeacherCleverIDs}}</li>
-
-    <li>{{.}}</li>
-
-    <li style="list-style: none">{{end}}</li>
-  </ul>
-
-  <hr style=
-  "border: 0;
-height: 1px;
-background-image: linear-gradient(to right, rgba(0, 0, 0, 0), rgba(0, 0, 0, 0.75), rgba(0, 0, 0, 0));
-" />
-
-  <h4>School Clever IDs</h4>
-  <ul>
-    <li style="list-style: none">{{range .MissingSchoolCleverIDs}}</li>
-
-    <li>{{.}}</li>
+func dial(config *Config) (*smtp.Client, error) {
+	if config.TLSMode == TLSModeImplicit {
+		conn, err := tls.Dial(
+			"tcp",
+			config.Addr(),
+			&tls.Config{ServerName: config.Host},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return smtp.NewClient(conn, config.Host)
+	}
+	return smtp.Dial(config.Addr())
+}
 
-    <li style="list-style: none">{{end}}</li>
-  </ul>
+func (c *Config) smtpAuth() smtp.Auth {
+	switch c.Auth {
+	case AuthPlain:
+		// PlainAuth will only send the credentials if the connection is
+		// using TLS or is connected to localhost.
+		return smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	case AuthLogin:
+		return &loginAuth{
+			username: c.Username,
+			password: c.Password,
+			host:     c.Host,
+		}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(c.Username, c.Password)
+	default:
+		return nil
+	}
+}
 
-  <hr style=
-  "border: 0;
-height: 1px;
-background-image: linear-gradient(to right, rgba(0, 0, 0, 0), rgba(0, 0, 0, 0.75), rgba(0, 0, 0, 0));
-" />
-`
+// loginAuth implements the non-standard but widely deployed LOGIN mechanism
+// (e.g. Office 365), which net/smtp does not provide
+type loginAuth struct {
+	username, password, host string
+}
 
-	t, err := template.New("pullRosterSummary").Parse(htmlTmpl)
-	if err != nil {
-		return "", err
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Same guard as smtp.PlainAuth: never send credentials in the clear
+	// to anything other than localhost.
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
 	}
-	var tpl bytes.Buffer
-	err = t.Execute(&tpl, summary)
-	if err != nil {
-		return "", err
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
 	}
-	body := tpl.String()
+	return "LOGIN", nil, nil
+}
 
-	var bodyMessage bytes.Buffer
-	temp := quotedprintable.NewWriter(&bodyMessage)
-	defer temp.Close()
-	_, writeErr := temp.Write([]byte(body))
-	if writeErr != nil {
-		return "", writeErr
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN prompt %q", fromServer)
 	}
-
-	return bodyMessage.String(), nil
 }
 
-type MissingReport struct {
-	DistrictName            string
-	DistrictCleverID        string
-	MissingStudentCleverIDs []string
-	MissingTeacherCleverIDs []string
-	MissingSchoolCleverIDs  []string
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
 }
@@ -0,0 +1,246 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is a multipart/alternative email with a plain text and an HTML
+// version of the same body, plus optional attachments
+type Message struct {
+	From    netmail.Address
+	ReplyTo string
+	Recipients
+	Subject string
+	// TextBody and HTMLBody are unencoded, they are quoted-printable encoded
+	// when the message is rendered
+	TextBody    string
+	HTMLBody    string
+	Attachments []Attachment
+	// Date defaults to the time the message is rendered
+	Date time.Time
+	// MessageID defaults to a random ID at the sender's domain
+	MessageID string
+}
+
+// Attachment is a file attached to a Message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// NewMessage addresses a message from the configured sender
+func NewMessage(
+	config *Config,
+	recipients Recipients,
+	subject, textBody, htmlBody string,
+) *Message {
+	return &Message{
+		From:       netmail.Address{Name: config.FromName, Address: config.From},
+		ReplyTo:    config.ReplyTo,
+		Recipients: recipients,
+		Subject:    subject,
+		TextBody:   textBody,
+		HTMLBody:   htmlBody,
+	}
+}
+
+// Bytes renders the complete RFC 5322 message, headers first in a fixed
+// order. The MIME boundaries are derived from the Message-ID, so the output
+// only depends on the message's fields.
+func (m *Message) Bytes() ([]byte, error) {
+	if m.Date.IsZero() {
+		m.Date = time.Now()
+	}
+	if m.MessageID == "" {
+		id, err := newMessageID(m.From.Address)
+		if err != nil {
+			return nil, err
+		}
+		m.MessageID = id
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "Date", m.Date.Format(time.RFC1123Z))
+	writeHeader(&buf, "From", m.From.String())
+	if m.ReplyTo != "" {
+		writeHeader(&buf, "Reply-To", m.ReplyTo)
+	}
+	// An address in both To and Cc is only listed in To
+	to := dedupe(m.To)
+	var cc []string
+	for _, address := range dedupe(m.Cc) {
+		if !containsFold(to, address) {
+			cc = append(cc, address)
+		}
+	}
+	if len(to) > 0 {
+		writeHeader(&buf, "To", strings.Join(to, ", "))
+	} else {
+		writeHeader(&buf, "To", "undisclosed-recipients:;")
+	}
+	if len(cc) > 0 {
+		writeHeader(&buf, "Cc", strings.Join(cc, ", "))
+	}
+	writeHeader(&buf, "Message-ID", m.MessageID)
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	alternative := multipart.NewWriter(nil)
+	if err := alternative.SetBoundary(m.boundary("alt")); err != nil {
+		return nil, err
+	}
+	alternativeType := "multipart/alternative; boundary=" +
+		alternative.Boundary()
+
+	if len(m.Attachments) == 0 {
+		writeHeader(&buf, "Content-Type", alternativeType)
+		buf.WriteString("\r\n")
+		if err := m.writeAlternative(&buf, alternative.Boundary()); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	if err := mixed.SetBoundary(m.boundary("mixed")); err != nil {
+		return nil, err
+	}
+	writeHeader(
+		&buf,
+		"Content-Type",
+		"multipart/mixed; boundary="+mixed.Boundary(),
+	)
+	buf.WriteString("\r\n")
+
+	part, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {alternativeType},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = m.writeAlternative(part, alternative.Boundary()); err != nil {
+		return nil, err
+	}
+
+	for i := range m.Attachments {
+		if err = writeAttachment(mixed, &m.Attachments[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err = mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeAlternative writes the text and HTML parts, text first since clients
+// show the last part they understand
+func (m *Message) writeAlternative(w io.Writer, boundary string) error {
+	alternative := multipart.NewWriter(w)
+	if err := alternative.SetBoundary(boundary); err != nil {
+		return err
+	}
+	bodies := []struct{ contentType, body string }{
+		{`text/plain; charset="utf-8"`, m.TextBody},
+		{`text/html; charset="utf-8"`, m.HTMLBody},
+	}
+	for _, b := range bodies {
+		part, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {b.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err = qp.Write([]byte(b.body)); err != nil {
+			return err
+		}
+		if err = qp.Close(); err != nil {
+			return err
+		}
+	}
+	return alternative.Close()
+}
+
+func writeAttachment(mixed *multipart.Writer, attachment *Attachment) error {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	params := map[string]string{"filename": attachment.Filename}
+	part, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {contentType},
+		"Content-Disposition": {
+			mime.FormatMediaType("attachment", params),
+		},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(attachment.Content)
+	// RFC 2045 limits encoded lines to 76 characters
+	for len(encoded) > 76 {
+		if _, err = fmt.Fprintf(part, "%s\r\n", encoded[:76]); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = fmt.Fprintf(part, "%s\r\n", encoded)
+	return err
+}
+
+func (m *Message) boundary(kind string) string {
+	sum := sha256.Sum256([]byte(kind + m.MessageID))
+	return kind + "-" + hex.EncodeToString(sum[:12])
+}
+
+// maxHeaderLine is the longest a header line should be, per RFC 5322
+const maxHeaderLine = 78
+
+// writeHeader writes one header field, folded at spaces so that its lines
+// are at most maxHeaderLine characters, unless a single word is longer
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	line := key + ":"
+	for i, word := range strings.Split(value, " ") {
+		if i > 0 && word != "" && len(line)+1+len(word) > maxHeaderLine {
+			buf.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+// newMessageID makes a globally unique Message-ID at the sender's domain
+func newMessageID(from string) (string, error) {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"<%d.%s@%s>",
+		time.Now().UnixNano(),
+		hex.EncodeToString(random),
+		domain,
+	), nil
+}
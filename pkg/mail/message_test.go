@@ -0,0 +1,344 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMessage is a message with a fixed Date and Message-ID, so it always
+// renders the same
+func testMessage() *Message {
+	return &Message{
+		From:    netmail.Address{Name: "Roster Bot", Address: "bot@example.org"},
+		ReplyTo: "team@example.org",
+		Recipients: Recipients{
+			To:  []string{"pat@example.org", "sam@example.org"},
+			Cc:  []string{"lead@example.org"},
+			Bcc: []string{"audit@example.org"},
+		},
+		Subject:   "Springfield Unified: 3 records missing from MAP Growth ✓",
+		TextBody:  "3 records are missing.\nSee the attached CSV files.\n",
+		HTMLBody:  "<p>3 records are missing. See the attached CSV files.</p>",
+		Date:      time.Date(2020, 9, 1, 6, 0, 0, 0, time.UTC),
+		MessageID: "<1598940000.test@example.org>",
+	}
+}
+
+// part is a decoded leaf of a MIME message
+type part struct {
+	contentType string
+	filename    string
+	content     string
+}
+
+// parseMessage reads a rendered message back, returning its headers and
+// every leaf part in order
+func parseMessage(t *testing.T, content []byte) (netmail.Header, []part) {
+	t.Helper()
+	msg, err := netmail.ReadMessage(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("unable to parse message: %v", err)
+	}
+	parts := readParts(t, msg.Header.Get("Content-Type"), msg.Body)
+	return msg.Header, parts
+}
+
+func readParts(t *testing.T, contentType string, body io.Reader) []part {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("invalid Content-Type %q: %v", contentType, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		t.Fatalf("Content-Type %q is not multipart", contentType)
+	}
+	var parts []part
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		p, partErr := reader.NextRawPart()
+		if partErr == io.EOF {
+			return parts
+		}
+		if partErr != nil {
+			t.Fatalf("unable to read part: %v", partErr)
+		}
+		partType := p.Header.Get("Content-Type")
+		if strings.HasPrefix(partType, "multipart/") {
+			parts = append(parts, readParts(t, partType, p)...)
+			continue
+		}
+		var decoded io.Reader = p
+		switch p.Header.Get("Content-Transfer-Encoding") {
+		case "quoted-printable":
+			decoded = quotedprintable.NewReader(p)
+		case "base64":
+			decoded = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		content, readErr := ioutil.ReadAll(decoded)
+		if readErr != nil {
+			t.Fatalf("unable to decode part: %v", readErr)
+		}
+		parts = append(parts, part{
+			contentType: partType,
+			filename:    p.FileName(),
+			content:     string(content),
+		})
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	csv := []byte(
+		strings.Repeat("5f1e2d3c4b5a69788796b003,Grace Hopper\n", 10),
+	)
+	// Text is sent with CRLF line breaks, as MIME requires
+	text := strings.Replace(testMessage().TextBody, "\n", "\r\n", -1)
+	tests := []struct {
+		name        string
+		attachments []Attachment
+		wantType    string
+		wantParts   []part
+	}{
+		{
+			name:     "text and HTML only",
+			wantType: "multipart/alternative",
+			wantParts: []part{
+				{
+					contentType: `text/plain; charset="utf-8"`,
+					content:     text,
+				},
+				{
+					contentType: `text/html; charset="utf-8"`,
+					content:     testMessage().HTMLBody,
+				},
+			},
+		},
+		{
+			name: "with attachments",
+			attachments: []Attachment{
+				{
+					Filename:    "missing-students.csv",
+					ContentType: "text/csv",
+					Content:     csv,
+				},
+				{Filename: "report.bin", Content: []byte{0, 1, 2, 255}},
+			},
+			wantType: "multipart/mixed",
+			wantParts: []part{
+				{
+					contentType: `text/plain; charset="utf-8"`,
+					content:     text,
+				},
+				{
+					contentType: `text/html; charset="utf-8"`,
+					content:     testMessage().HTMLBody,
+				},
+				{
+					contentType: "text/csv",
+					filename:    "missing-students.csv",
+					content:     string(csv),
+				},
+				{
+					contentType: "application/octet-stream",
+					filename:    "report.bin",
+					content:     string([]byte{0, 1, 2, 255}),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := testMessage()
+			message.Attachments = tt.attachments
+			content, err := message.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+
+			header, parts := parseMessage(t, content)
+			wantHeaders := map[string]string{
+				"Date":       "Tue, 01 Sep 2020 06:00:00 +0000",
+				"From":       `"Roster Bot" <bot@example.org>`,
+				"Reply-To":   "team@example.org",
+				"To":         "pat@example.org, sam@example.org",
+				"Cc":         "lead@example.org",
+				"Bcc":        "",
+				"Message-ID": "<1598940000.test@example.org>",
+			}
+			for key, want := range wantHeaders {
+				if got := header.Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+			subject, decodeErr := new(mime.WordDecoder).DecodeHeader(
+				header.Get("Subject"),
+			)
+			if decodeErr != nil || subject != message.Subject {
+				t.Errorf(
+					"Subject = %q (%v), want %q",
+					subject,
+					decodeErr,
+					message.Subject,
+				)
+			}
+			mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+			if mediaType != tt.wantType {
+				t.Errorf("Content-Type = %s, want %s", mediaType, tt.wantType)
+			}
+
+			if len(parts) != len(tt.wantParts) {
+				t.Fatalf(
+					"%d parts, want %d: %+v",
+					len(parts),
+					len(tt.wantParts),
+					parts,
+				)
+			}
+			for i, want := range tt.wantParts {
+				if parts[i] != want {
+					t.Errorf("part %d = %+v, want %+v", i, parts[i], want)
+				}
+			}
+
+			for _, line := range strings.Split(string(content), "\r\n") {
+				if len(line) > 998 {
+					t.Errorf("line of %d characters", len(line))
+				}
+			}
+			if bytes.Contains(content, []byte("audit@example.org")) {
+				t.Error("Bcc address in the message")
+			}
+		})
+	}
+}
+
+func TestMessageBytesRecipients(t *testing.T) {
+	var many []string
+	for i := 0; i < 12; i++ {
+		many = append(many, fmt.Sprintf("teacher-%02d@example.org", i))
+	}
+	tests := []struct {
+		name   string
+		to     []string
+		cc     []string
+		wantTo string
+		wantCc string
+	}{
+		{
+			name:   "duplicates",
+			to:     []string{"pat@example.org", "PAT@example.org"},
+			cc:     []string{"Pat@example.org", "lead@example.org"},
+			wantTo: "pat@example.org",
+			wantCc: "lead@example.org",
+		},
+		{
+			name:   "everyone in To",
+			to:     []string{"pat@example.org"},
+			cc:     []string{"pat@example.org"},
+			wantTo: "pat@example.org",
+		},
+		{
+			name:   "folded",
+			to:     many,
+			cc:     many[:3],
+			wantTo: strings.Join(many, ", "),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := testMessage()
+			message.To = tt.to
+			message.Cc = tt.cc
+			content, err := message.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+			header, _ := parseMessage(t, content)
+			if got := header.Get("To"); got != tt.wantTo {
+				t.Errorf("To = %q, want %q", got, tt.wantTo)
+			}
+			if got := header.Get("Cc"); got != tt.wantCc {
+				t.Errorf("Cc = %q, want %q", got, tt.wantCc)
+			}
+
+			head := content[:bytes.Index(content, []byte("\r\n\r\n"))]
+			for _, line := range strings.Split(string(head), "\r\n") {
+				if len(line) > maxHeaderLine &&
+					!strings.HasPrefix(line, "Subject:") {
+					t.Errorf("header line %q is too long", line)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageBytesAttachmentLines(t *testing.T) {
+	message := testMessage()
+	message.Attachments = []Attachment{{
+		Filename: "big.csv",
+		Content:  bytes.Repeat([]byte("x"), 1000),
+	}}
+	content, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "xxx" encodes to "eHh4", so every line of the attachment starts so
+	encodedLines := 0
+	for _, line := range strings.Split(string(content), "\r\n") {
+		if !strings.HasPrefix(line, "eHh4") {
+			continue
+		}
+		encodedLines++
+		if len(line) > 76 {
+			t.Errorf("base64 line of %d characters, want 76 at most", len(line))
+		}
+	}
+	// 1000 bytes are 1336 base64 characters
+	if encodedLines != 18 {
+		t.Errorf("%d base64 lines, want 18", encodedLines)
+	}
+}
+
+func TestMessageBytesDefaults(t *testing.T) {
+	message := testMessage()
+	message.Date = time.Time{}
+	message.MessageID = ""
+	message.To = nil
+
+	before := time.Now().Add(-time.Second)
+	content, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _ := parseMessage(t, content)
+
+	date, dateErr := header.Date()
+	if dateErr != nil || date.Before(before) {
+		t.Errorf("Date = %v (%v), want about now", date, dateErr)
+	}
+	id := header.Get("Message-ID")
+	if !strings.HasPrefix(id, "<") ||
+		!strings.HasSuffix(id, "@example.org>") {
+		t.Errorf("Message-ID = %s, want one at example.org", id)
+	}
+	if to := header.Get("To"); to != "undisclosed-recipients:;" {
+		t.Errorf("To = %q, want undisclosed-recipients:;", to)
+	}
+
+	again, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, again) {
+		t.Error("rendering the same message twice differs")
+	}
+}
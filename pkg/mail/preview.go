@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Preview writes the message to path exactly as Mail would send it, as an
+// .eml file any mail client can open, and writes a summary of it to w.
+// Nothing is sent.
+func Preview(message *Message, path string, w io.Writer) error {
+	content, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return err
+	}
+	return writeSummary(w, message, path, len(content))
+}
+
+func writeSummary(w io.Writer, message *Message, path string, size int) error {
+	lines := [][2]string{
+		{"File", fmt.Sprintf("%s (%d bytes)", path, size)},
+		{"From", message.From.String()},
+		{"Reply-To", message.ReplyTo},
+		{"To", strings.Join(message.To, ", ")},
+		{"Cc", strings.Join(message.Cc, ", ")},
+		// Bcc is not in the message headers, so it is only shown here
+		{"Bcc", strings.Join(message.Bcc, ", ")},
+		{"Subject", message.Subject},
+		{"Message-ID", message.MessageID},
+		{"Text", fmt.Sprintf("%d bytes", len(message.TextBody))},
+		{"HTML", fmt.Sprintf("%d bytes", len(message.HTMLBody))},
+	}
+	for i := range message.Attachments {
+		attachment := message.Attachments[i]
+		lines = append(lines, [2]string{
+			"Attachment",
+			fmt.Sprintf(
+				"%s (%s, %d bytes)",
+				attachment.Filename,
+				attachment.ContentType,
+				len(attachment.Content),
+			),
+		})
+	}
+	if message.Empty() {
+		lines = append(lines, [2]string{"Warning", "no recipients"})
+	}
+	for _, line := range lines {
+		if line[1] == "" {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "%-11s %s\n", line[0]+":", line[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package mail
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testReport is a district missing one student, one teacher and one section
+// from MAP Growth, redacted as the summary email is by default
+func testReport(t *testing.T) *report.MissingReport {
+	t.Helper()
+	missing := &report.MissingReport{
+		DistrictName:     "Springfield Unified",
+		DistrictCleverID: "5f1e2d3c4b5a69788796a5b4",
+		MissingStudents: []report.Discrepancy{{
+			CleverID: "5f1e2d3c4b5a69788796b003",
+			Name:     "Grace Hopper",
+			SisID:    "100245678",
+			SchoolID: "5f1e2d3c4b5a69788796a002",
+			School:   "Roosevelt Middle",
+			Grade:    "7",
+		}},
+		MissingTeachers: []report.Discrepancy{{
+			CleverID: "5f1e2d3c4b5a69788796c002",
+			Name:     "Maria Montessori",
+			SchoolID: "5f1e2d3c4b5a69788796a002",
+			School:   "Roosevelt Middle",
+		}},
+		MissingSections: []report.Discrepancy{{
+			CleverID: "5f1e2d3c4b5a69788796f002",
+			Name:     "Science 7",
+			SchoolID: "5f1e2d3c4b5a69788796a002",
+			School:   "Roosevelt Middle",
+			Grade:    "7",
+		}},
+		BySchool: []report.SchoolBreakdown{{
+			SchoolID:        "5f1e2d3c4b5a69788796a002",
+			School:          "Roosevelt Middle",
+			MissingStudents: 1,
+			MissingTeachers: 1,
+			MissingSections: 1,
+			Students:        1,
+			Teachers:        1,
+			Sections:        1,
+			PercentAffected: 100,
+			Highlighted:     true,
+		}},
+		ByGrade: []report.GradeBreakdown{{
+			Grade:           "7",
+			MissingStudents: 1,
+			MissingSections: 1,
+			Students:        1,
+			Sections:        1,
+			PercentAffected: 100,
+		}},
+		HighlightPercent: report.DefaultHighlightPercent,
+		Health: []report.HealthCheck{{
+			Check:  report.HealthLastSync,
+			App:    rostering.AppMAPGrowth,
+			Passed: false,
+			Detail: "last synced 3 days ago",
+		}},
+	}
+	redacted, err := report.DefaultEmailRedaction().Apply(missing, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redacted
+}
+
+func TestPreview(t *testing.T) {
+	summary := testReport(t)
+	subject, text, html, mailErr := NewSummaryMail(
+		DefaultTemplates(),
+		summary,
+		DefaultSummaryRows,
+	)
+	if mailErr != nil {
+		t.Fatal(mailErr)
+	}
+	attachments, attachmentsErr := NewMissingReportAttachments(summary)
+	if attachmentsErr != nil {
+		t.Fatal(attachmentsErr)
+	}
+	config := &Config{
+		From:     "roster-bot@example.org",
+		FromName: "Roster Bot",
+		ReplyTo:  "roster-team@example.org",
+	}
+	message := NewMessage(
+		config,
+		Recipients{
+			To:  []string{"pat@example.org"},
+			Bcc: []string{"audit@example.org"},
+		},
+		subject,
+		text,
+		html,
+	)
+	message.Attachments = attachments
+	message.Date = time.Date(2020, 9, 1, 6, 0, 0, 0, time.UTC)
+	message.MessageID = "<1598940000.preview@example.org>"
+
+	dir, dirErr := ioutil.TempDir("", "preview")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "summary.eml")
+
+	var out bytes.Buffer
+	if previewErr := Preview(message, path, &out); previewErr != nil {
+		t.Fatalf("Preview() error = %v", previewErr)
+	}
+	got, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	// The summary names the file, which is somewhere new every run
+	gotSummary := bytes.Replace(out.Bytes(), []byte(path), []byte("PATH"), 1)
+	goldens := []struct {
+		path string
+		got  []byte
+	}{
+		{path: "testdata/summary.eml", got: got},
+		{path: "testdata/summary.txt", got: gotSummary},
+	}
+	for _, golden := range goldens {
+		if *update {
+			if err := ioutil.WriteFile(golden.path, golden.got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(golden.got, want) {
+			t.Errorf(
+				"preview differs from %s, run go test -update and review "+
+					"the diff if the change is intended:\n%s",
+				golden.path,
+				golden.got,
+			)
+		}
+	}
+}
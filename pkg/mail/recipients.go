@@ -0,0 +1,231 @@
+package mail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	netmail "net/mail"
+	"os"
+	"strings"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+)
+
+// Recipients of a single message. Bcc addresses are only used in the SMTP
+// envelope and never appear in the headers.
+type Recipients struct {
+	To  []string `json:"to,omitempty"`
+	Cc  []string `json:"cc,omitempty"`
+	Bcc []string `json:"bcc,omitempty"`
+}
+
+// Empty is true when there is nobody to send to
+func (r Recipients) Empty() bool {
+	return len(r.To) == 0 && len(r.Cc) == 0 && len(r.Bcc) == 0
+}
+
+// All is every envelope recipient, without duplicates
+func (r Recipients) All() []string {
+	all := append(append([]string{}, r.To...), r.Cc...)
+	return dedupe(append(all, r.Bcc...))
+}
+
+// Merge adds other's recipients to r, without duplicates
+func (r Recipients) Merge(other Recipients) Recipients {
+	return Recipients{
+		To:  dedupe(append(append([]string{}, r.To...), other.To...)),
+		Cc:  dedupe(append(append([]string{}, r.Cc...), other.Cc...)),
+		Bcc: dedupe(append(append([]string{}, r.Bcc...), other.Bcc...)),
+	}
+}
+
+// Validate checks that every address parses
+func (r Recipients) Validate() error {
+	for _, address := range r.All() {
+		if _, err := netmail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid email address %q: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// Route sends the reports of every district matching any of its criteria to
+// its Recipients
+type Route struct {
+	// Name is only used to describe the route in logs
+	Name        string   `json:"name,omitempty"`
+	DistrictIDs []string `json:"district_ids,omitempty"`
+	// States are matched case-insensitively against District.State
+	States []string `json:"states,omitempty"`
+	// SisTypes are matched case-insensitively against District.SisType
+	SisTypes []string `json:"sis_types,omitempty"`
+	Recipients
+}
+
+// RoutingTable decides who receives each district's report.
+//
+// The most specific matching route wins: a route listing the district's ID
+// beats one listing its state, which beats one listing its SIS type. Ties go
+// to the route listed first. Districts no route matches go to Default.
+// Cc and Bcc are added to every report, whichever route it took.
+type RoutingTable struct {
+	Routes  []Route    `json:"routes,omitempty"`
+	Default Recipients `json:"default"`
+	Cc      []string   `json:"cc,omitempty"`
+	Bcc     []string   `json:"bcc,omitempty"`
+}
+
+// Resolve finds the recipients for a district's report
+func (t *RoutingTable) Resolve(district generated.District) Recipients {
+	recipients := t.Default
+	if route := t.match(district); route != nil {
+		recipients = route.Recipients
+	}
+	return recipients.Merge(Recipients{Cc: t.Cc, Bcc: t.Bcc})
+}
+
+func (t *RoutingTable) match(district generated.District) *Route {
+	criteria := []func(*Route) bool{
+		func(r *Route) bool {
+			return district.Id != nil && contains(r.DistrictIDs, *district.Id)
+		},
+		func(r *Route) bool {
+			return district.State != nil &&
+				containsFold(r.States, *district.State)
+		},
+		func(r *Route) bool {
+			return district.SisType != nil &&
+				containsFold(r.SisTypes, *district.SisType)
+		},
+	}
+	for _, matches := range criteria {
+		for i := range t.Routes {
+			if matches(&t.Routes[i]) {
+				return &t.Routes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks every address in the table, and that every district will
+// be sent to somebody
+func (t *RoutingTable) Validate() error {
+	if t.Default.Empty() {
+		return fmt.Errorf("the default route has no recipients (TO_EMAIL)")
+	}
+	if err := t.Default.Validate(); err != nil {
+		return fmt.Errorf("default route: %w", err)
+	}
+	shared := Recipients{Cc: t.Cc, Bcc: t.Bcc}
+	if err := shared.Validate(); err != nil {
+		return err
+	}
+	for i := range t.Routes {
+		route := t.Routes[i]
+		if route.Empty() {
+			return fmt.Errorf("route %d %s has no recipients", i, route.Name)
+		}
+		if err := route.Validate(); err != nil {
+			return fmt.Errorf("route %d %s: %w", i, route.Name, err)
+		}
+	}
+	return nil
+}
+
+// RoutingTableFromEnv builds the routing table from the environment:
+//
+//	TO_EMAIL     comma separated default recipients
+//	CC_EMAIL     comma separated addresses copied on every report
+//	BCC_EMAIL    comma separated addresses blind copied on every report
+//	MAIL_ROUTES  optional path to a JSON RoutingTable with per-district
+//	             routes. Its default route, if any, replaces TO_EMAIL and
+//	             its cc/bcc lists are added to CC_EMAIL/BCC_EMAIL.
+func RoutingTableFromEnv() (*RoutingTable, error) {
+	table := &RoutingTable{}
+	if path := os.Getenv("MAIL_ROUTES"); path != "" {
+		loaded, err := LoadRoutingTable(path)
+		if err != nil {
+			return nil, err
+		}
+		table = loaded
+	}
+	if table.Default.Empty() {
+		table.Default.To = SplitAddresses(os.Getenv("TO_EMAIL"))
+	}
+	table.Cc = dedupe(
+		append(table.Cc, SplitAddresses(os.Getenv("CC_EMAIL"))...),
+	)
+	table.Bcc = dedupe(
+		append(table.Bcc, SplitAddresses(os.Getenv("BCC_EMAIL"))...),
+	)
+	if err := table.Validate(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// LoadRoutingTable reads a JSON RoutingTable, e.g.
+//
+//	{
+//	  "default": {"to": ["roster-team@example.org"]},
+//	  "bcc": ["audit@example.org"],
+//	  "routes": [
+//	    {"name": "Pat", "district_ids": ["5f2b..."], "to": ["pat@example.org"]},
+//	    {"name": "Texas", "states": ["TX"], "to": ["tx-team@example.org"]}
+//	  ]
+//	}
+func LoadRoutingTable(path string) (*RoutingTable, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table := &RoutingTable{}
+	if err := json.Unmarshal(content, table); err != nil {
+		return nil, fmt.Errorf("unable to parse mail routes %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// SplitAddresses splits a comma separated list of addresses, dropping blanks
+func SplitAddresses(list string) []string {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		address = strings.TrimSpace(address)
+		if address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+func dedupe(addresses []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, address := range addresses {
+		key := strings.ToLower(address)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, address)
+		}
+	}
+	return unique
+}
+
+func contains(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for i := range list {
+		if strings.EqualFold(list[i], s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+package mail
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+)
+
+func TestRoutingTableResolve(t *testing.T) {
+	table := &RoutingTable{
+		Routes: []Route{
+			{
+				Name:       "Texas",
+				States:     []string{"TX"},
+				Recipients: Recipients{To: []string{"tx@example.org"}},
+			},
+			{
+				Name:       "Clever SIS",
+				SisTypes:   []string{"clever"},
+				Recipients: Recipients{To: []string{"sis@example.org"}},
+			},
+			{
+				Name:        "Pat",
+				DistrictIDs: []string{"5f1e2d3c4b5a69788796a5b4"},
+				Recipients: Recipients{
+					To: []string{"pat@example.org"},
+					Cc: []string{"pat-lead@example.org"},
+				},
+			},
+			{
+				Name:       "Texas again",
+				States:     []string{"tx"},
+				Recipients: Recipients{To: []string{"tx2@example.org"}},
+			},
+		},
+		Default: Recipients{To: []string{"team@example.org"}},
+		Cc:      []string{"lead@example.org"},
+		Bcc:     []string{"audit@example.org"},
+	}
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		district generated.District
+		want     Recipients
+	}{
+		{
+			name: "district ID beats state and SIS type",
+			district: generated.District{
+				Id:      str("5f1e2d3c4b5a69788796a5b4"),
+				State:   str("TX"),
+				SisType: str("clever"),
+			},
+			want: Recipients{
+				To:  []string{"pat@example.org"},
+				Cc:  []string{"pat-lead@example.org", "lead@example.org"},
+				Bcc: []string{"audit@example.org"},
+			},
+		},
+		{
+			name: "state beats SIS type, first route wins",
+			district: generated.District{
+				Id:      str("5f1e2d3c4b5a69788796a5b5"),
+				State:   str("tx"),
+				SisType: str("clever"),
+			},
+			want: Recipients{
+				To:  []string{"tx@example.org"},
+				Cc:  []string{"lead@example.org"},
+				Bcc: []string{"audit@example.org"},
+			},
+		},
+		{
+			name:     "SIS type, ignoring case",
+			district: generated.District{SisType: str("Clever")},
+			want: Recipients{
+				To:  []string{"sis@example.org"},
+				Cc:  []string{"lead@example.org"},
+				Bcc: []string{"audit@example.org"},
+			},
+		},
+		{
+			name:     "no match goes to the default",
+			district: generated.District{State: str("CA")},
+			want: Recipients{
+				To:  []string{"team@example.org"},
+				Cc:  []string{"lead@example.org"},
+				Bcc: []string{"audit@example.org"},
+			},
+		},
+		{
+			name:     "no details goes to the default",
+			district: generated.District{},
+			want: Recipients{
+				To:  []string{"team@example.org"},
+				Cc:  []string{"lead@example.org"},
+				Bcc: []string{"audit@example.org"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := table.Resolve(tt.district)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecipientsAll(t *testing.T) {
+	r := Recipients{
+		To:  []string{"a@example.org", "b@example.org"},
+		Cc:  []string{"B@example.org", "c@example.org"},
+		Bcc: []string{"a@example.org", "d@example.org"},
+	}
+	want := []string{
+		"a@example.org",
+		"b@example.org",
+		"c@example.org",
+		"d@example.org",
+	}
+	if got := r.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("All() = %v, want %v", got, want)
+	}
+}
+
+func TestRoutingTableValidate(t *testing.T) {
+	valid := Recipients{To: []string{"team@example.org"}}
+	tests := []struct {
+		name    string
+		table   RoutingTable
+		wantErr bool
+	}{
+		{name: "default only", table: RoutingTable{Default: valid}},
+		{name: "no default", table: RoutingTable{}, wantErr: true},
+		{
+			name: "invalid default",
+			table: RoutingTable{
+				Default: Recipients{To: []string{"team at example.org"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid bcc",
+			table: RoutingTable{
+				Default: valid,
+				Bcc:     []string{"audit@"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route without recipients",
+			table: RoutingTable{
+				Default: valid,
+				Routes:  []Route{{Name: "Texas", States: []string{"TX"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route with an invalid address",
+			table: RoutingTable{
+				Default: valid,
+				Routes: []Route{{
+					Name:       "Texas",
+					States:     []string{"TX"},
+					Recipients: Recipients{Cc: []string{"tx"}},
+				}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.table.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// setenv sets the environment variables until the returned function is
+// called, which restores them
+func setenv(t *testing.T, env map[string]string) func() {
+	t.Helper()
+	old := map[string]string{}
+	for key, value := range env {
+		old[key] = os.Getenv(key)
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for key, value := range old {
+			_ = os.Setenv(key, value)
+		}
+	}
+}
+
+func TestRoutingTableFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    *RoutingTable
+		wantErr bool
+	}{
+		{
+			name: "addresses only",
+			env: map[string]string{
+				"MAIL_ROUTES": "",
+				"TO_EMAIL":    "team@example.org, ,lead@example.org",
+				"CC_EMAIL":    "",
+				"BCC_EMAIL":   "audit@example.org",
+			},
+			want: &RoutingTable{
+				Default: Recipients{
+					To: []string{"team@example.org", "lead@example.org"},
+				},
+				Bcc: []string{"audit@example.org"},
+			},
+		},
+		{
+			name: "routes file replaces TO_EMAIL and adds to the copies",
+			env: map[string]string{
+				"MAIL_ROUTES": "testdata/routes.json",
+				"TO_EMAIL":    "ignored@example.org",
+				"CC_EMAIL":    "lead@example.org",
+				"BCC_EMAIL":   "AUDIT@example.org",
+			},
+			want: &RoutingTable{
+				Routes: []Route{{
+					Name:       "Texas",
+					States:     []string{"TX"},
+					Recipients: Recipients{To: []string{"tx@example.org"}},
+				}},
+				Default: Recipients{To: []string{"team@example.org"}},
+				Cc:      []string{"lead@example.org"},
+				Bcc:     []string{"audit@example.org"},
+			},
+		},
+		{
+			name: "nobody to send to",
+			env: map[string]string{
+				"MAIL_ROUTES": "",
+				"TO_EMAIL":    "",
+				"CC_EMAIL":    "lead@example.org",
+				"BCC_EMAIL":   "",
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing routes file",
+			env: map[string]string{
+				"MAIL_ROUTES": "testdata/missing.json",
+				"TO_EMAIL":    "team@example.org",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setenv(t, tt.env)()
+			got, err := RoutingTableFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf(
+					"RoutingTableFromEnv() error = %v, want error %v",
+					err,
+					tt.wantErr,
+				)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RoutingTableFromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Khan/clever-repartee/pkg/report"
+)
+
+// DefaultSummaryRows is how many records of each type are listed in the body
+// of the summary email. The CSV attachments always have every record.
+const DefaultSummaryRows = 100
+
+// SummaryData is what the subject, text and HTML templates of the summary
+// email are executed with. See the README for the full data model.
+type SummaryData struct {
+	// MissingReport provides .DistrictName, .DistrictCleverID, the complete
+	// .MissingStudents, .MissingTeachers, .MissingSchools and
+	// .MissingSections, the .BySchool and .ByGrade breakdowns, and the
+	// .Health checks, with .HealthFailed and .FailedHealthChecks
+	*report.MissingReport
+	// Students, Teachers, Schools and Sections are capped at MaxRows
+	// records each
+	Students SummaryList
+	Teachers SummaryList
+	Schools  SummaryList
+	Sections SummaryList
+	// Lists is Students, Teachers, Schools and Sections, in that order
+	Lists []SummaryList
+	// Total is the number of missing records of every type
+	Total int
+	// MaxRows is the cap on each list, negative for no cap
+	MaxRows int
+}
+
+// SummaryList is one entity type's missing records, capped for the body of
+// the email
+type SummaryList struct {
+	// Title is "Students", "Teachers", "Schools" or "Sections"
+	Title string
+	// Shown is the first MaxRows records
+	Shown []report.Discrepancy
+	// Total is the number of records, shown or not
+	Total int
+	// Omitted is the number of records left out of Shown
+	Omitted int
+	// Attachment is the name of the CSV file with every record
+	Attachment string
+}
+
+// NewSummaryData caps each list of missing records at maxRows (negative for
+// no cap)
+func NewSummaryData(summary *report.MissingReport, maxRows int) *SummaryData {
+	data := &SummaryData{
+		MissingReport: summary,
+		Students: newSummaryList(
+			"Students",
+			summary.MissingStudents,
+			maxRows,
+			attachmentName(summary, "students"),
+		),
+		Teachers: newSummaryList(
+			"Teachers",
+			summary.MissingTeachers,
+			maxRows,
+			attachmentName(summary, "teachers"),
+		),
+		Schools: newSummaryList(
+			"Schools",
+			summary.MissingSchools,
+			maxRows,
+			attachmentName(summary, "schools"),
+		),
+		Sections: newSummaryList(
+			"Sections",
+			summary.MissingSections,
+			maxRows,
+			attachmentName(summary, "sections"),
+		),
+		MaxRows: maxRows,
+	}
+	data.Lists = []SummaryList{
+		data.Students,
+		data.Teachers,
+		data.Schools,
+		data.Sections,
+	}
+	for _, list := range data.Lists {
+		data.Total += list.Total
+	}
+	return data
+}
+
+func newSummaryList(
+	title string,
+	missing []report.Discrepancy,
+	maxRows int,
+	attachment string,
+) SummaryList {
+	list := SummaryList{
+		Title:      title,
+		Shown:      missing,
+		Total:      len(missing),
+		Attachment: attachment,
+	}
+	if maxRows >= 0 && len(missing) > maxRows {
+		list.Shown = missing[:maxRows]
+		list.Omitted = len(missing) - maxRows
+	}
+	return list
+}
+
+// NewSummaryMail renders the subject, plain text and HTML bodies of the
+// summary email, listing at most maxRows records of each type (negative for
+// no limit)
+func NewSummaryMail(
+	templates *Templates,
+	summary *report.MissingReport,
+	maxRows int,
+) (subject, text, html string, err error) {
+	return templates.Execute(NewSummaryData(summary, maxRows))
+}
+
+// NewMissingReportAttachments makes one CSV file per entity type with every
+// missing record. Entity types with nothing missing are left out.
+func NewMissingReportAttachments(
+	summary *report.MissingReport,
+) ([]Attachment, error) {
+	lists := []struct {
+		entity  string
+		missing []report.Discrepancy
+	}{
+		{"students", summary.MissingStudents},
+		{"teachers", summary.MissingTeachers},
+		{"schools", summary.MissingSchools},
+		{"sections", summary.MissingSections},
+	}
+	var attachments []Attachment
+	for _, list := range lists {
+		if len(list.missing) == 0 {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := report.WriteCSV(&buf, list.missing); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, Attachment{
+			Filename:    attachmentName(summary, list.entity),
+			ContentType: `text/csv; charset="utf-8"`,
+			Content:     buf.Bytes(),
+		})
+	}
+	return attachments, nil
+}
+
+func attachmentName(summary *report.MissingReport, entity string) string {
+	return fmt.Sprintf("%s-missing-%s.csv", summary.DistrictCleverID, entity)
+}
@@ -0,0 +1,251 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+const defaultSubjectTmpl = `{{if .HealthFailed}}⚠️ Sync health check failed: {{end}}🕵️ Clever Discrepancy Report`
+
+const defaultTextTmpl = `District {{.DistrictName}} CleverID {{.DistrictCleverID}} was missing these records:
+{{with .FailedHealthChecks}}
+Sync health checks failed, so these records may not be current
+{{range .}}  ! {{.Check}}{{with .App}} ({{appName .}}){{end}}: {{.Detail}}
+{{end}}{{end}}{{if .BySchool}}
+By school (* is at least {{printf "%.0f" .HighlightPercent}}% of the school's roster)
+{{range .BySchool}}  {{if .Highlighted}}*{{else}} {{end}} {{or .School .SchoolID}}: {{.MissingStudents}}/{{.Students}} students, {{.MissingTeachers}}/{{.Teachers}} teachers, {{.MissingSections}}/{{.Sections}} sections ({{printf "%.1f" .PercentAffected}}%)
+{{end}}{{end}}{{if .ByGrade}}
+By grade
+{{range .ByGrade}}  {{.Grade}}: {{.MissingStudents}}/{{.Students}} students, {{.MissingSections}}/{{.Sections}} sections ({{printf "%.1f" .PercentAffected}}%)
+{{end}}{{end}}{{range .Lists}}
+{{.Title}} ({{.Total}})
+{{range .Shown}}  - {{.CleverID}}{{with .Details}} ({{.}}){{end}}
+{{end}}{{if .Omitted}}  ... and {{.Omitted}} more, see the attached {{.Attachment}}
+{{end}}{{end}}`
+
+const defaultHTMLTmpl = `{{define "rule"}}
+  <hr style=
+  "border: 0;
+height: 1px;
+background-image: linear-gradient(to right, rgba(0, 0, 0, 0), rgba(0, 0, 0, 0.75), rgba(0, 0, 0, 0));
+" />
+{{end}}{{template "rule"}}
+
+  <h3>&#129335;District {{.DistrictName}} CleverID {{.DistrictCleverID}} was missing these records:</h3>
+{{with .FailedHealthChecks}}
+  <h4 style="color: #b00">Sync health checks failed, so these records may not be current</h4>
+  <ul>
+  {{range .}}
+    <li><strong>{{.Check}}</strong>{{with .App}} ({{appName .}}){{end}}: {{.Detail}}</li>
+  {{end}}
+  </ul>
+{{template "rule"}}{{end}}{{if .BySchool}}
+  <h4>By school</h4>
+  <p>Schools missing at least {{printf "%.0f" .HighlightPercent}}% of their roster are highlighted.</p>
+  <table style="border-collapse: collapse">
+    <tr><th align="left">School</th><th>Students</th><th>Teachers</th><th>Sections</th><th>Affected</th></tr>
+  {{range .BySchool}}
+    <tr{{if .Highlighted}} style="background-color: #fdd"{{end}}>
+      <td>{{if .Highlighted}}<strong>{{or .School .SchoolID}}</strong>{{else}}{{or .School .SchoolID}}{{end}}</td>
+      <td align="right">{{.MissingStudents}} / {{.Students}}</td>
+      <td align="right">{{.MissingTeachers}} / {{.Teachers}}</td>
+      <td align="right">{{.MissingSections}} / {{.Sections}}</td>
+      <td align="right">{{printf "%.1f" .PercentAffected}}%</td>
+    </tr>
+  {{end}}
+  </table>
+{{template "rule"}}{{end}}{{if .ByGrade}}
+  <h4>By grade</h4>
+  <table style="border-collapse: collapse">
+    <tr><th align="left">Grade</th><th>Students</th><th>Sections</th><th>Affected</th></tr>
+  {{range .ByGrade}}
+    <tr>
+      <td>{{.Grade}}</td>
+      <td align="right">{{.MissingStudents}} / {{.Students}}</td>
+      <td align="right">{{.MissingSections}} / {{.Sections}}</td>
+      <td align="right">{{printf "%.1f" .PercentAffected}}%</td>
+    </tr>
+  {{end}}
+  </table>
+{{template "rule"}}{{end}}{{range .Lists}}
+  <h4>{{.Title}} ({{.Total}})</h4>
+  {{if .Shown}}<ul>
+  {{range .Shown}}
+    <li>{{.CleverID}}{{with .Details}} ({{.}}){{end}}</li>
+  {{end}}
+  </ul>{{end}}
+  {{if .Omitted}}<p>&hellip; and {{.Omitted}} more, see the attached {{.Attachment}}</p>{{end}}
+{{template "rule"}}{{end}}`
+
+// Templates render the summary email from a SummaryData
+type Templates struct {
+	Subject *template.Template
+	Text    *template.Template
+	HTML    *htmltemplate.Template
+}
+
+// DefaultTemplates are the built in templates
+func DefaultTemplates() *Templates {
+	return &Templates{
+		Subject: template.Must(newTextTemplate("subject", defaultSubjectTmpl)),
+		Text:    template.Must(newTextTemplate("text", defaultTextTmpl)),
+		HTML: htmltemplate.Must(
+			newHTMLTemplate("html", defaultHTMLTmpl),
+		),
+	}
+}
+
+// LoadTemplates reads user supplied template files. The subject and text
+// templates use text/template and the HTML template uses html/template. An
+// empty path keeps the built in template. The templates are checked against
+// sample data, so mistakes surface before any roster is fetched.
+func LoadTemplates(subjectPath, textPath, htmlPath string) (*Templates, error) {
+	templates := DefaultTemplates()
+	if subjectPath != "" {
+		content, err := ioutil.ReadFile(subjectPath)
+		if err != nil {
+			return nil, err
+		}
+		templates.Subject, err = newTextTemplate(
+			filepath.Base(subjectPath),
+			string(content),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if textPath != "" {
+		content, err := ioutil.ReadFile(textPath)
+		if err != nil {
+			return nil, err
+		}
+		templates.Text, err = newTextTemplate(
+			filepath.Base(textPath),
+			string(content),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if htmlPath != "" {
+		content, err := ioutil.ReadFile(htmlPath)
+		if err != nil {
+			return nil, err
+		}
+		templates.HTML, err = newHTMLTemplate(
+			filepath.Base(htmlPath),
+			string(content),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := templates.Check(); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// Check executes every template against sample reports, both empty and with
+// more records than fit in the email, to catch references to fields that do
+// not exist
+func (t *Templates) Check() error {
+	full := &report.MissingReport{
+		DistrictName:     "Sample District",
+		DistrictCleverID: "sample-district",
+		BySchool: []report.SchoolBreakdown{{
+			SchoolID:        "sample-school",
+			School:          "Sample School",
+			MissingStudents: 2,
+			MissingTeachers: 2,
+			MissingSections: 2,
+			Students:        10,
+			Teachers:        2,
+			Sections:        4,
+			PercentAffected: 37.5,
+			Highlighted:     true,
+		}},
+		ByGrade: []report.GradeBreakdown{{
+			Grade:           "3",
+			MissingStudents: 2,
+			MissingSections: 2,
+			Students:        10,
+			Sections:        4,
+			PercentAffected: 28.6,
+		}},
+		HighlightPercent: report.DefaultHighlightPercent,
+	}
+	for i := 0; i < 2; i++ {
+		sample := report.Discrepancy{
+			CleverID: fmt.Sprintf("sample-%d", i),
+			Name:     "Sample Name",
+			SisID:    "1234",
+			Number:   "5678",
+			SchoolID: "sample-school",
+			School:   "Sample School",
+			Grade:    "3",
+		}
+		full.MissingStudents = append(full.MissingStudents, sample)
+		full.MissingTeachers = append(full.MissingTeachers, sample)
+		full.MissingSchools = append(full.MissingSchools, sample)
+		full.MissingSections = append(full.MissingSections, sample)
+	}
+	samples := []*SummaryData{
+		NewSummaryData(full, 1),
+		NewSummaryData(&report.MissingReport{}, DefaultSummaryRows),
+	}
+	for _, sample := range samples {
+		if _, _, _, err := t.Execute(sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Execute renders the subject, plain text body and HTML body
+func (t *Templates) Execute(
+	data *SummaryData,
+) (subject, text, html string, err error) {
+	var buf bytes.Buffer
+	if err = t.Subject.Execute(&buf, data); err != nil {
+		return "", "", "", fmt.Errorf("subject template: %w", err)
+	}
+	// A subject is a single header line
+	subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	if err = t.Text.Execute(&buf, data); err != nil {
+		return "", "", "", fmt.Errorf("text template: %w", err)
+	}
+	text = buf.String()
+
+	buf.Reset()
+	if err = t.HTML.Execute(&buf, data); err != nil {
+		return "", "", "", fmt.Errorf("HTML template: %w", err)
+	}
+	html = buf.String()
+
+	return subject, text, html, nil
+}
+
+// funcs are the functions every template can call: appName names an app,
+// such as a health check's .App, for people
+var funcs = map[string]interface{}{
+	"appName": rostering.AppName,
+}
+
+func newTextTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Parse(text)
+}
+
+func newHTMLTemplate(name, text string) (*htmltemplate.Template, error) {
+	return htmltemplate.New(name).Funcs(funcs).Parse(text)
+}
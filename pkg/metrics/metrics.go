@@ -0,0 +1,242 @@
+// Package metrics collects Prometheus metrics about Clever API calls and
+// about each run, and exposes them on /metrics or pushes them to a
+// Pushgateway when the run finishes.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const namespace = "clever_repartee"
+
+// Metrics holds every collector in its own registry. All methods are safe to
+// call on a nil *Metrics, which records nothing, so callers don't need to
+// check whether metrics are enabled.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	retries       *prometheus.CounterVec
+	requestBytes  *prometheus.CounterVec
+	responseBytes *prometheus.CounterVec
+
+	recordsFetched *prometheus.GaugeVec
+	discrepancies  *prometheus.GaugeVec
+	runDuration    prometheus.Gauge
+	lastSuccess    prometheus.Gauge
+}
+
+// New creates and registers every collector
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "http_requests_total",
+				Help: "Clever API requests by endpoint template and " +
+					"status code, or error if there was no response",
+			},
+			[]string{"endpoint", "method", "status"},
+		),
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "http_request_duration_seconds",
+				Help:      "Clever API request latency",
+				Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+			},
+			[]string{"endpoint", "method"},
+		),
+		retries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "http_retries_total",
+				Help:      "Clever API requests that were retried",
+			},
+			[]string{"endpoint", "method"},
+		),
+		requestBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "http_request_bytes_total",
+				Help:      "Bytes sent in Clever API request bodies",
+			},
+			[]string{"endpoint"},
+		),
+		responseBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "http_response_bytes_total",
+				Help:      "Bytes read from Clever API response bodies",
+			},
+			[]string{"endpoint"},
+		),
+		recordsFetched: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "records_fetched",
+				Help:      "Records fetched in the last run, by app and entity",
+			},
+			[]string{"district", "app", "entity"},
+		),
+		discrepancies: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "discrepancies",
+				Help: "Records missing from MAP Growth in the last run, " +
+					"by entity",
+			},
+			[]string{"district", "entity"},
+		),
+		runDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "run_duration_seconds",
+			Help:      "How long the last run took",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time the last successful run finished",
+		}),
+	}
+	m.Registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.retries,
+		m.requestBytes,
+		m.responseBytes,
+		m.recordsFetched,
+		m.discrepancies,
+		m.runDuration,
+		m.lastSuccess,
+	)
+	return m
+}
+
+// ObserveRequest records one request attempt. status is 0 if there was no
+// response.
+func (m *Metrics) ObserveRequest(
+	endpoint, method string,
+	status int,
+	took time.Duration,
+	requestBytes int64,
+) {
+	if m == nil {
+		return
+	}
+	statusLabel := "error"
+	if status != 0 {
+		statusLabel = strconv.Itoa(status)
+	}
+	m.requests.WithLabelValues(endpoint, method, statusLabel).Inc()
+	m.duration.WithLabelValues(endpoint, method).Observe(took.Seconds())
+	if requestBytes > 0 {
+		m.requestBytes.WithLabelValues(endpoint).Add(float64(requestBytes))
+	}
+}
+
+// ObserveResponseBytes records bytes read from a response body
+func (m *Metrics) ObserveResponseBytes(endpoint string, n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.responseBytes.WithLabelValues(endpoint).Add(float64(n))
+}
+
+// ObserveRetry records one retried request
+func (m *Metrics) ObserveRetry(endpoint, method string) {
+	if m == nil {
+		return
+	}
+	m.retries.WithLabelValues(endpoint, method).Inc()
+}
+
+// SetRecordsFetched records how many records of an entity type an app could
+// see
+func (m *Metrics) SetRecordsFetched(district, app, entity string, n int) {
+	if m == nil {
+		return
+	}
+	m.recordsFetched.WithLabelValues(district, app, entity).Set(float64(n))
+}
+
+// SetDiscrepancies records how many records of an entity type are missing
+func (m *Metrics) SetDiscrepancies(district, entity string, n int) {
+	if m == nil {
+		return
+	}
+	m.discrepancies.WithLabelValues(district, entity).Set(float64(n))
+}
+
+// RunFinished records how long the run took, and when it succeeded if err
+// is nil
+func (m *Metrics) RunFinished(began time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.runDuration.Set(time.Since(began).Seconds())
+	if err == nil {
+		m.lastSuccess.SetToCurrentTime()
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// Serve serves the metrics on addr at /metrics until the server fails. It
+// is meant to be run in its own goroutine.
+func (m *Metrics) Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// Push sends the metrics to a Pushgateway compatible URL, replacing those
+// previously pushed for the same job and district
+func (m *Metrics) Push(url, district string) error {
+	if m == nil {
+		return nil
+	}
+	pusher := push.New(url, namespace)
+	var gatherer prometheus.Gatherer = m.Registry
+	if district != "" {
+		// The grouping key labels every metric with the district, and
+		// metrics can't be pushed with a label of their own of that name
+		pusher = pusher.Grouping("district", district)
+		gatherer = withoutLabel{m.Registry, "district", district}
+	}
+	return pusher.Gatherer(gatherer).Push()
+}
+
+// withoutLabel gathers metrics without the label if it has the value
+type withoutLabel struct {
+	prometheus.Gatherer
+	name, value string
+}
+
+func (g withoutLabel) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			labels := metric.Label[:0]
+			for _, label := range metric.Label {
+				if label.GetName() != g.name || label.GetValue() != g.value {
+					labels = append(labels, label)
+				}
+			}
+			metric.Label = labels
+		}
+	}
+	return families, err
+}
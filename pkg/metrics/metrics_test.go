@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("/students", http.MethodGet, 200, time.Second, 10)
+	m.ObserveResponseBytes("/students", 10)
+	m.ObserveRetry("/students", http.MethodGet)
+	m.SetRecordsFetched("d1", "map_growth", "students", 10)
+	m.SetDiscrepancies("d1", "students", 1)
+	m.RunFinished(time.Now(), nil)
+	if err := m.Push("http://localhost:0", "d1"); err != nil {
+		t.Errorf("Push() error = %v, want nothing pushed", err)
+	}
+}
+
+func TestObserveRequest(t *testing.T) {
+	m := New()
+	m.ObserveRequest("/students", http.MethodGet, 200, time.Second, 0)
+	m.ObserveRequest("/students", http.MethodGet, 200, time.Second, 0)
+	m.ObserveRequest("/students", http.MethodGet, 0, time.Second, 0)
+	m.ObserveRequest("/oauth/tokens", http.MethodPost, 429, time.Second, 12)
+	m.ObserveResponseBytes("/students", 100)
+	m.ObserveResponseBytes("/students", 0)
+	m.ObserveRetry("/students", http.MethodGet)
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{
+			name: "200s",
+			got: testutil.ToFloat64(
+				m.requests.WithLabelValues("/students", http.MethodGet, "200"),
+			),
+			want: 2,
+		},
+		{
+			name: "errors",
+			got: testutil.ToFloat64(
+				m.requests.WithLabelValues(
+					"/students",
+					http.MethodGet,
+					"error",
+				),
+			),
+			want: 1,
+		},
+		{
+			name: "429s",
+			got: testutil.ToFloat64(m.requests.WithLabelValues(
+				"/oauth/tokens",
+				http.MethodPost,
+				"429",
+			)),
+			want: 1,
+		},
+		{
+			name: "request bytes",
+			got: testutil.ToFloat64(
+				m.requestBytes.WithLabelValues("/oauth/tokens"),
+			),
+			want: 12,
+		},
+		{
+			name: "response bytes",
+			got: testutil.ToFloat64(
+				m.responseBytes.WithLabelValues("/students"),
+			),
+			want: 100,
+		},
+		{
+			name: "retries",
+			got: testutil.ToFloat64(
+				m.retries.WithLabelValues("/students", http.MethodGet),
+			),
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	// Requests with no body don't count towards the bytes sent
+	if n := testutil.CollectAndCount(m.requestBytes); n != 1 {
+		t.Errorf("%d request bytes series, want 1", n)
+	}
+}
+
+func TestRunFinished(t *testing.T) {
+	m := New()
+	m.RunFinished(time.Now().Add(-time.Minute), errors.New("failed"))
+	if got := testutil.ToFloat64(m.runDuration); got < 60 {
+		t.Errorf("run duration = %v, want at least a minute", got)
+	}
+	if got := testutil.ToFloat64(m.lastSuccess); got != 0 {
+		t.Errorf("last success = %v after a failed run, want 0", got)
+	}
+
+	before := time.Now().Unix()
+	m.RunFinished(time.Now(), nil)
+	if got := testutil.ToFloat64(m.lastSuccess); got < float64(before) {
+		t.Errorf("last success = %v, want at least %d", got, before)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.SetRecordsFetched("d1", "map_growth", "students", 10)
+	m.SetDiscrepancies("d1", "students", 1)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(
+		rec,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	)
+	for _, want := range []string{
+		`clever_repartee_records_fetched{app="map_growth",district="d1",` +
+			`entity="students"} 10`,
+		`clever_repartee_discrepancies{district="d1",entity="students"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics have no %s:\n%s", want, rec.Body)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name     string
+		district string
+		wantPath string
+	}{
+		{
+			name:     "district",
+			district: "d1",
+			wantPath: "/metrics/job/clever_repartee/district/d1",
+		},
+		{name: "no district", wantPath: "/metrics/job/clever_repartee"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path, body string
+			gateway := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					content, _ := ioutil.ReadAll(r.Body)
+					method, path, body = r.Method, r.URL.Path, string(content)
+					w.WriteHeader(http.StatusOK)
+				},
+			))
+			defer gateway.Close()
+
+			m := New()
+			m.SetDiscrepancies("d1", "students", 1)
+			if err := m.Push(gateway.URL, tt.district); err != nil {
+				t.Fatalf("Push() error = %v", err)
+			}
+			// PUT replaces every metric pushed before for the group
+			if method != http.MethodPut || path != tt.wantPath {
+				t.Errorf(
+					"pushed %s %s, want PUT %s",
+					method,
+					path,
+					tt.wantPath,
+				)
+			}
+			if !strings.Contains(body, "clever_repartee_discrepancies") {
+				t.Error("discrepancies weren't pushed")
+			}
+		})
+	}
+}
+
+func TestPushError(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+	))
+	defer gateway.Close()
+	if err := New().Push(gateway.URL, "d1"); err == nil {
+		t.Error("Push() to a failing gateway succeeded")
+	}
+}
+
+func TestWithoutLabel(t *testing.T) {
+	m := New()
+	m.SetDiscrepancies("d1", "students", 1)
+	m.SetRecordsFetched("d2", "map_growth", "students", 10)
+	families, err := withoutLabel{m.Registry, "district", "d1"}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"clever_repartee_discrepancies": "entity=students",
+		"clever_repartee_records_fetched": "app=map_growth,district=d2," +
+			"entity=students",
+	}
+	for _, family := range families {
+		wantLabels, ok := want[family.GetName()]
+		if !ok {
+			continue
+		}
+		var labels []string
+		for _, label := range family.Metric[0].Label {
+			labels = append(labels, label.GetName()+"="+label.GetValue())
+		}
+		if got := strings.Join(labels, ","); got != wantLabels {
+			t.Errorf(
+				"%s labels = %s, want %s",
+				family.GetName(),
+				got,
+				wantLabels,
+			)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package report
+
+import (
+	"sort"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// DefaultHighlightPercent is the default share of a school's roster that has
+// to be missing for the school to be highlighted
+const DefaultHighlightPercent = 10.0
+
+// SchoolBreakdown counts one school's missing records against the size of
+// the school's roster in the app that can see them, to tell a single broken
+// school apart from a broken district
+type SchoolBreakdown struct {
+	SchoolID        string
+	School          string
+	MissingStudents int
+	MissingTeachers int
+	MissingSections int
+	// Students, Teachers and Sections are the size of the school's roster
+	Students int
+	Teachers int
+	Sections int
+	// PercentAffected is the share of the school's students, teachers and
+	// sections that are missing
+	PercentAffected float64
+	// Highlighted is true when PercentAffected reaches the report's
+	// HighlightPercent
+	Highlighted bool
+}
+
+// GradeBreakdown counts one grade's missing students and sections against
+// the size of the grade's roster in the app that can see them
+type GradeBreakdown struct {
+	Grade           string
+	MissingStudents int
+	MissingSections int
+	Students        int
+	Sections        int
+	PercentAffected float64
+}
+
+// breakdownBySchool counts students and teachers in every school they belong
+// to, and sections in their one school
+func breakdownBySchool(
+	roster *rostering.Roster,
+	missingStudents []generated.Student,
+	missingTeachers []generated.Teacher,
+	missingSections []generated.Section,
+	schoolNames map[string]string,
+	highlightPercent float64,
+) []SchoolBreakdown {
+	bySchool := map[string]*SchoolBreakdown{}
+	school := func(id string) *SchoolBreakdown {
+		if _, ok := bySchool[id]; !ok {
+			bySchool[id] = &SchoolBreakdown{
+				SchoolID: id,
+				School:   schoolNames[id],
+			}
+		}
+		return bySchool[id]
+	}
+
+	if roster.Students != nil {
+		for i := range *roster.Students {
+			student := (*roster.Students)[i]
+			for _, id := range schoolsOf(student.School, student.Schools) {
+				school(id).Students++
+			}
+		}
+	}
+	if roster.Teachers != nil {
+		for i := range *roster.Teachers {
+			teacher := (*roster.Teachers)[i]
+			for _, id := range schoolsOf(teacher.School, teacher.Schools) {
+				school(id).Teachers++
+			}
+		}
+	}
+	if roster.Sections != nil {
+		for i := range *roster.Sections {
+			section := (*roster.Sections)[i]
+			for _, id := range schoolsOf(section.School, nil) {
+				school(id).Sections++
+			}
+		}
+	}
+
+	for i := range missingStudents {
+		student := missingStudents[i]
+		for _, id := range schoolsOf(student.School, student.Schools) {
+			school(id).MissingStudents++
+		}
+	}
+	for i := range missingTeachers {
+		teacher := missingTeachers[i]
+		for _, id := range schoolsOf(teacher.School, teacher.Schools) {
+			school(id).MissingTeachers++
+		}
+	}
+	for i := range missingSections {
+		section := missingSections[i]
+		for _, id := range schoolsOf(section.School, nil) {
+			school(id).MissingSections++
+		}
+	}
+
+	var breakdown []SchoolBreakdown
+	for _, b := range bySchool {
+		missing := b.MissingStudents + b.MissingTeachers + b.MissingSections
+		if missing == 0 {
+			continue
+		}
+		b.PercentAffected = percent(missing, b.Students+b.Teachers+b.Sections)
+		b.Highlighted = highlightPercent > 0 &&
+			b.PercentAffected >= highlightPercent
+		breakdown = append(breakdown, *b)
+	}
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].PercentAffected != breakdown[j].PercentAffected {
+			return breakdown[i].PercentAffected > breakdown[j].PercentAffected
+		}
+		return breakdown[i].School < breakdown[j].School
+	})
+	return breakdown
+}
+
+func breakdownByGrade(
+	roster *rostering.Roster,
+	missingStudents []generated.Student,
+	missingSections []generated.Section,
+) []GradeBreakdown {
+	byGrade := map[string]*GradeBreakdown{}
+	grade := func(g *string) *GradeBreakdown {
+		name := value(g)
+		if name == "" {
+			name = "Unknown"
+		}
+		if _, ok := byGrade[name]; !ok {
+			byGrade[name] = &GradeBreakdown{Grade: name}
+		}
+		return byGrade[name]
+	}
+
+	if roster.Students != nil {
+		for i := range *roster.Students {
+			grade((*roster.Students)[i].Grade).Students++
+		}
+	}
+	if roster.Sections != nil {
+		for i := range *roster.Sections {
+			grade((*roster.Sections)[i].Grade).Sections++
+		}
+	}
+	for i := range missingStudents {
+		grade(missingStudents[i].Grade).MissingStudents++
+	}
+	for i := range missingSections {
+		grade(missingSections[i].Grade).MissingSections++
+	}
+
+	var breakdown []GradeBreakdown
+	for _, b := range byGrade {
+		missing := b.MissingStudents + b.MissingSections
+		if missing == 0 {
+			continue
+		}
+		b.PercentAffected = percent(missing, b.Students+b.Sections)
+		breakdown = append(breakdown, *b)
+	}
+	sort.Slice(breakdown, func(i, j int) bool {
+		oi, oj := gradeOrder(breakdown[i].Grade), gradeOrder(breakdown[j].Grade)
+		if oi != oj {
+			return oi < oj
+		}
+		return breakdown[i].Grade < breakdown[j].Grade
+	})
+	return breakdown
+}
+
+// schoolsOf is every school a record belongs to, without duplicates
+func schoolsOf(school *string, schools *[]string) []string {
+	var ids []string
+	seen := map[string]bool{}
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	add(value(school))
+	if schools != nil {
+		for _, id := range *schools {
+			add(id)
+		}
+	}
+	return ids
+}
+
+// grades in the order Clever documents them
+var grades = []string{
+	"InfantToddler",
+	"Preschool",
+	"PreKindergarten",
+	"TransitionalKindergarten",
+	"Kindergarten",
+	"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13",
+	"PostGraduate",
+	"Ungraded",
+	"Other",
+}
+
+func gradeOrder(grade string) int {
+	for i := range grades {
+		if grades[i] == grade {
+			return i
+		}
+	}
+	return len(grades)
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 100
+	}
+	return 100 * float64(part) / float64(whole)
+}
@@ -0,0 +1,213 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+func str(s string) *string { return &s }
+
+func strs(s ...string) *[]string { return &s }
+
+// testBreakdownRoster has two schools: Adams, with four students, a teacher
+// and two sections, and Lincoln, with one student who is at both schools
+// and a section
+func testBreakdownRoster() *rostering.Roster {
+	student := func(id, grade string, schools ...string) generated.Student {
+		return generated.Student{
+			Id:      str(id),
+			Grade:   str(grade),
+			School:  str(schools[0]),
+			Schools: strs(schools...),
+		}
+	}
+	section := func(id, grade, school string) generated.Section {
+		return generated.Section{
+			Id:     str(id),
+			Grade:  str(grade),
+			School: str(school),
+		}
+	}
+	return &rostering.Roster{
+		Students: &[]generated.Student{
+			student("st1", "Kindergarten", "s1"),
+			student("st2", "10", "s1"),
+			student("st3", "10", "s1"),
+			student("st4", "2", "s2", "s1"),
+		},
+		Teachers: &[]generated.Teacher{
+			{Id: str("t1"), School: str("s1")},
+		},
+		Sections: &[]generated.Section{
+			section("sec1", "10", "s1"),
+			section("sec2", "", "s1"),
+			section("sec3", "2", "s2"),
+		},
+	}
+}
+
+func TestBreakdownBySchool(t *testing.T) {
+	roster := testBreakdownRoster()
+	schoolNames := map[string]string{"s1": "Adams", "s2": "Lincoln"}
+	tests := []struct {
+		name             string
+		students         []generated.Student
+		teachers         []generated.Teacher
+		sections         []generated.Section
+		highlightPercent float64
+		want             []SchoolBreakdown
+	}{
+		{
+			name:             "nothing missing",
+			highlightPercent: DefaultHighlightPercent,
+		},
+		{
+			name:             "a student at two schools counts at both",
+			students:         []generated.Student{(*roster.Students)[3]},
+			highlightPercent: 30,
+			want: []SchoolBreakdown{
+				{
+					SchoolID:        "s2",
+					School:          "Lincoln",
+					MissingStudents: 1,
+					Students:        1,
+					Sections:        1,
+					PercentAffected: 50,
+					Highlighted:     true,
+				},
+				{
+					SchoolID:        "s1",
+					School:          "Adams",
+					MissingStudents: 1,
+					Students:        4,
+					Teachers:        1,
+					Sections:        2,
+					PercentAffected: 100.0 / 7,
+				},
+			},
+		},
+		{
+			name:     "teachers and sections, no highlighting",
+			teachers: *roster.Teachers,
+			sections: (*roster.Sections)[:1],
+			want: []SchoolBreakdown{{
+				SchoolID:        "s1",
+				School:          "Adams",
+				MissingTeachers: 1,
+				MissingSections: 1,
+				Students:        4,
+				Teachers:        1,
+				Sections:        2,
+				PercentAffected: 200.0 / 7,
+			}},
+		},
+		{
+			name: "a school not in the roster",
+			students: []generated.Student{{
+				Id:     str("st9"),
+				School: str("s9"),
+			}},
+			highlightPercent: DefaultHighlightPercent,
+			want: []SchoolBreakdown{{
+				SchoolID:        "s9",
+				MissingStudents: 1,
+				PercentAffected: 100,
+				Highlighted:     true,
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := breakdownBySchool(
+				roster,
+				tt.students,
+				tt.teachers,
+				tt.sections,
+				schoolNames,
+				tt.highlightPercent,
+			)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("breakdownBySchool() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakdownByGrade(t *testing.T) {
+	roster := testBreakdownRoster()
+	got := breakdownByGrade(
+		roster,
+		[]generated.Student{
+			(*roster.Students)[1],
+			(*roster.Students)[3],
+			(*roster.Students)[0],
+		},
+		[]generated.Section{(*roster.Sections)[1]},
+	)
+	want := []GradeBreakdown{
+		{
+			Grade:           "Kindergarten",
+			MissingStudents: 1,
+			Students:        1,
+			PercentAffected: 100,
+		},
+		{
+			Grade:           "2",
+			MissingStudents: 1,
+			Students:        1,
+			Sections:        1,
+			PercentAffected: 50,
+		},
+		{
+			Grade:           "10",
+			MissingStudents: 1,
+			Students:        2,
+			Sections:        1,
+			PercentAffected: 100.0 / 3,
+		},
+		{
+			Grade:           "Unknown",
+			MissingSections: 1,
+			Sections:        1,
+			PercentAffected: 100,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("breakdownByGrade() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSchoolsOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		school  *string
+		schools *[]string
+		want    []string
+	}{
+		{name: "none"},
+		{name: "school only", school: str("s1"), want: []string{"s1"}},
+		{
+			name:    "school first, without duplicates",
+			school:  str("s2"),
+			schools: strs("s1", "s2", "", "s1"),
+			want:    []string{"s2", "s1"},
+		},
+		{
+			name:    "empty school",
+			school:  str(""),
+			schools: strs("s1"),
+			want:    []string{"s1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := schoolsOf(tt.school, tt.schools)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("schoolsOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
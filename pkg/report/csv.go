@@ -0,0 +1,46 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// CSVHeader is the first row written by WriteCSV
+var CSVHeader = []string{
+	"clever_id",
+	"name",
+	"sis_id",
+	"number",
+	"school_id",
+	"school",
+	"grade",
+	"email",
+	"dob",
+}
+
+// WriteCSV writes one row per discrepancy, after a CSVHeader row
+func WriteCSV(w io.Writer, discrepancies []Discrepancy) error {
+	rows := [][]string{CSVHeader}
+	for _, d := range discrepancies {
+		rows = append(rows, []string{
+			d.CleverID,
+			d.Name,
+			d.SisID,
+			d.Number,
+			d.SchoolID,
+			d.School,
+			d.Grade,
+			d.Email,
+			d.DOB,
+		})
+	}
+	return writeCSVRows(w, rows)
+}
+
+func writeCSVRows(w io.Writer, rows [][]string) error {
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.WriteAll(rows); err != nil {
+		return err
+	}
+	return csvWriter.Error()
+}
@@ -0,0 +1,202 @@
+package report
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// documentData is what the Markdown and HTML report templates are executed
+// with
+type documentData struct {
+	*MissingReport
+	Lists []documentList
+}
+
+type documentList struct {
+	Title   string
+	Missing []Discrepancy
+}
+
+func newDocumentData(report *MissingReport) *documentData {
+	data := &documentData{MissingReport: report}
+	for _, list := range report.lists() {
+		data.Lists = append(
+			data.Lists,
+			documentList{Title: list.title, Missing: list.missing},
+		)
+	}
+	return data
+}
+
+const markdownTmpl = `# Clever Discrepancy Report: {{md .DistrictName}}
+
+District Clever ID ` + "`{{.DistrictCleverID}}`" + `
+{{if .Health}}
+## Sync health
+{{if .HealthFailed}}
+**Some checks failed, so the rest of this report may not be current.**
+{{end}}
+| Check | App | Result | Detail |
+|---|---|---|---|
+{{range .Health}}| {{.Check}} | {{md (appName .App)}} | {{if .Passed}}passed{{else}}**failed**{{end}} | {{md .Detail}} |
+{{end}}{{end}}{{if .BySchool}}
+## By school
+
+Schools missing at least {{printf "%.0f" .HighlightPercent}}% of their roster are in **bold**.
+
+| School | Students | Teachers | Sections | Affected |
+|---|---:|---:|---:|---:|
+{{range .BySchool}}| {{if .Highlighted}}**{{md (or .School .SchoolID)}}**{{else}}{{md (or .School .SchoolID)}}{{end}} | {{.MissingStudents}} / {{.Students}} | {{.MissingTeachers}} / {{.Teachers}} | {{.MissingSections}} / {{.Sections}} | {{printf "%.1f" .PercentAffected}}% |
+{{end}}{{end}}{{if .ByGrade}}
+## By grade
+
+| Grade | Students | Sections | Affected |
+|---|---:|---:|---:|
+{{range .ByGrade}}| {{md .Grade}} | {{.MissingStudents}} / {{.Students}} | {{.MissingSections}} / {{.Sections}} | {{printf "%.1f" .PercentAffected}}% |
+{{end}}{{end}}{{if .CircuitBreakers}}
+## Clever API circuit breakers
+
+These circuits opened during the run because too many requests failed.
+
+| Circuit | State | Requests | Failures | Times opened |
+|---|---|---:|---:|---:|
+{{range .CircuitBreakers}}| {{md .Circuit}} | {{.State}} | {{.Requests}} | {{.Failures}} | {{.Trips}} |
+{{end}}{{end}}{{range .Lists}}
+## Missing {{.Title}} ({{len .Missing}})
+{{if .Missing}}
+| Clever ID | Name | SIS ID | Number | School | Grade | Email | DOB |
+|---|---|---|---|---|---|---|---|
+{{range .Missing}}| ` + "`{{.CleverID}}`" + ` | {{md .Name}} | {{md .SisID}} | {{md .Number}} | {{md (or .School .SchoolID)}} | {{md .Grade}} | {{md .Email}} | {{md .DOB}} |
+{{end}}{{end}}{{end}}`
+
+const htmlDocumentTmpl = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Clever Discrepancy Report: {{.DistrictName}}</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; }
+  table { border-collapse: collapse; margin-bottom: 2em; }
+  th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; }
+  td.number { text-align: right; }
+  tr.highlighted { background-color: #fdd; font-weight: bold; }
+  code { font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>&#129335; Clever Discrepancy Report: {{.DistrictName}}</h1>
+<p>District Clever ID <code>{{.DistrictCleverID}}</code></p>
+{{if .Health}}
+<h2>Sync health</h2>
+{{if .HealthFailed}}<p><strong>Some checks failed, so the rest of this report may not be current.</strong></p>
+{{end}}<table>
+  <tr><th>Check</th><th>App</th><th>Result</th><th>Detail</th></tr>
+{{range .Health}}  <tr{{if not .Passed}} class="highlighted"{{end}}><td>{{.Check}}</td><td>{{appName .App}}</td><td>{{if .Passed}}passed{{else}}failed{{end}}</td><td>{{.Detail}}</td></tr>
+{{end}}</table>
+{{end}}{{if .BySchool}}
+<h2>By school</h2>
+<p>Schools missing at least {{printf "%.0f" .HighlightPercent}}% of their roster are highlighted.</p>
+<table>
+  <tr><th>School</th><th>Students</th><th>Teachers</th><th>Sections</th><th>Affected</th></tr>
+{{range .BySchool}}  <tr{{if .Highlighted}} class="highlighted"{{end}}><td>{{or .School .SchoolID}}</td><td class="number">{{.MissingStudents}} / {{.Students}}</td><td class="number">{{.MissingTeachers}} / {{.Teachers}}</td><td class="number">{{.MissingSections}} / {{.Sections}}</td><td class="number">{{printf "%.1f" .PercentAffected}}%</td></tr>
+{{end}}</table>
+{{end}}{{if .ByGrade}}
+<h2>By grade</h2>
+<table>
+  <tr><th>Grade</th><th>Students</th><th>Sections</th><th>Affected</th></tr>
+{{range .ByGrade}}  <tr><td>{{.Grade}}</td><td class="number">{{.MissingStudents}} / {{.Students}}</td><td class="number">{{.MissingSections}} / {{.Sections}}</td><td class="number">{{printf "%.1f" .PercentAffected}}%</td></tr>
+{{end}}</table>
+{{end}}{{if .CircuitBreakers}}
+<h2>Clever API circuit breakers</h2>
+<p>These circuits opened during the run because too many requests failed.</p>
+<table>
+  <tr><th>Circuit</th><th>State</th><th>Requests</th><th>Failures</th><th>Times opened</th></tr>
+{{range .CircuitBreakers}}  <tr><td>{{.Circuit}}</td><td>{{.State}}</td><td class="number">{{.Requests}}</td><td class="number">{{.Failures}}</td><td class="number">{{.Trips}}</td></tr>
+{{end}}</table>
+{{end}}{{range .Lists}}
+<h2>Missing {{.Title}} ({{len .Missing}})</h2>
+{{if .Missing}}<table>
+  <tr><th>Clever ID</th><th>Name</th><th>SIS ID</th><th>Number</th><th>School</th><th>Grade</th><th>Email</th><th>DOB</th></tr>
+{{range .Missing}}  <tr><td><code>{{.CleverID}}</code></td><td>{{.Name}}</td><td>{{.SisID}}</td><td>{{.Number}}</td><td>{{or .School .SchoolID}}</td><td>{{.Grade}}</td><td>{{.Email}}</td><td>{{.DOB}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}</body>
+</html>
+`
+
+var markdownTemplate = template.Must(
+	template.New("markdown").
+		Funcs(template.FuncMap{
+			"md":      escapeMarkdown,
+			"appName": rostering.AppName,
+		}).
+		Parse(markdownTmpl),
+)
+
+var htmlDocumentTemplate = htmltemplate.Must(
+	htmltemplate.New("html").
+		Funcs(htmltemplate.FuncMap{"appName": rostering.AppName}).
+		Parse(htmlDocumentTmpl),
+)
+
+// markdownWriter writes a single Markdown document, e.g. for pasting into a
+// ticket or wiki
+type markdownWriter struct{}
+
+func (markdownWriter) Format() string { return "md" }
+
+func (markdownWriter) Write(
+	dir, prefix string,
+	report *MissingReport,
+) ([]string, error) {
+	var buf bytes.Buffer
+	err := markdownTemplate.Execute(&buf, newDocumentData(report))
+	if err != nil {
+		return nil, err
+	}
+	path, err := writeFile(dir, prefix+".md", buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return []string{path}, nil
+}
+
+// htmlWriter writes a standalone HTML page that needs nothing but a browser
+type htmlWriter struct{}
+
+func (htmlWriter) Format() string { return "html" }
+
+func (htmlWriter) Write(
+	dir, prefix string,
+	report *MissingReport,
+) ([]string, error) {
+	var buf bytes.Buffer
+	err := htmlDocumentTemplate.Execute(&buf, newDocumentData(report))
+	if err != nil {
+		return nil, err
+	}
+	path, err := writeFile(dir, prefix+".html", buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return []string{path}, nil
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"|", `\|`,
+	"*", `\*`,
+	"_", `\_`,
+	"`", "\\`",
+	"<", "&lt;",
+	">", "&gt;",
+	"\n", " ",
+)
+
+// escapeMarkdown makes a value safe to put in a Markdown table cell
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
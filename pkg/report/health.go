@@ -0,0 +1,161 @@
+package report
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Khan/clever-repartee/pkg/districts"
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// Health checks
+const (
+	HealthError     = "error"
+	HealthLastSync  = "last_sync"
+	HealthPaused    = "paused"
+	HealthSyncMatch = "sync_match"
+)
+
+// HealthCheck is the result of one check on the health of the district's
+// sync, which decides whether the rest of the report can be trusted
+type HealthCheck struct {
+	Check string
+	// App is the app the district was checked via, as labelled in logs
+	// (rostering.AppMAPAccelerator or AppMAPGrowth), or empty for a check
+	// that compares both apps
+	App    string `json:",omitempty"`
+	Passed bool
+	Detail string
+}
+
+// checkHealth checks the district as each app sees it, and that both apps
+// saw the same last sync. An app that can't see the district is skipped.
+func checkHealth(
+	mapGrowthRoster *rostering.Roster,
+	mapAcceleratorRoster *rostering.Roster,
+	now time.Time,
+	maxSyncAge time.Duration,
+) []HealthCheck {
+	var checks []HealthCheck
+	var lastSyncs []string
+	apps := []struct {
+		name   string
+		roster *rostering.Roster
+	}{
+		{rostering.AppMAPAccelerator, mapAcceleratorRoster},
+		{rostering.AppMAPGrowth, mapGrowthRoster},
+	}
+	for _, app := range apps {
+		district, ok := rosterDistrict(app.roster)
+		if !ok {
+			continue
+		}
+		status := districts.NewStatus(app.name, district, now, maxSyncAge)
+		checks = append(checks, districtHealth(status, maxSyncAge)...)
+		lastSyncs = append(lastSyncs, status.LastSync)
+	}
+
+	if len(lastSyncs) == 2 {
+		check := HealthCheck{
+			Check:  HealthSyncMatch,
+			Passed: lastSyncs[0] == lastSyncs[1],
+			Detail: "Both apps last synced at " + lastSyncs[0],
+		}
+		if !check.Passed {
+			check.Detail = fmt.Sprintf(
+				"%s last synced at %s but %s at %s",
+				rostering.AppName(rostering.AppMAPAccelerator),
+				orNever(lastSyncs[0]),
+				rostering.AppName(rostering.AppMAPGrowth),
+				orNever(lastSyncs[1]),
+			)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func districtHealth(
+	status districts.Status,
+	maxSyncAge time.Duration,
+) []HealthCheck {
+	flagged := map[string]bool{}
+	for _, flag := range status.Flags {
+		flagged[flag] = true
+	}
+
+	errorCheck := HealthCheck{
+		Check:  HealthError,
+		App:    status.App,
+		Passed: !flagged[districts.FlagError],
+		Detail: "No error reported",
+	}
+	if !errorCheck.Passed {
+		errorCheck.Detail = "Clever reports: " + status.Error
+	}
+
+	syncCheck := HealthCheck{
+		Check:  HealthLastSync,
+		App:    status.App,
+		Passed: !flagged[districts.FlagStale],
+		Detail: "Last synced at " + status.LastSync,
+	}
+	if !syncCheck.Passed {
+		syncCheck.Detail = fmt.Sprintf(
+			"Last synced at %s, more than %s ago",
+			orNever(status.LastSync),
+			maxSyncAge,
+		)
+	}
+
+	pauseCheck := HealthCheck{
+		Check:  HealthPaused,
+		App:    status.App,
+		Passed: !flagged[districts.FlagPaused],
+		Detail: "Not paused",
+	}
+	if !pauseCheck.Passed {
+		pauseCheck.Detail = "Paused since " + status.PauseStart +
+			" with no end set"
+		if status.PauseEnd != "" {
+			pauseCheck.Detail = fmt.Sprintf(
+				"Paused from %s until %s",
+				status.PauseStart,
+				status.PauseEnd,
+			)
+		}
+	}
+	return []HealthCheck{errorCheck, syncCheck, pauseCheck}
+}
+
+func rosterDistrict(roster *rostering.Roster) (generated.District, bool) {
+	if roster == nil || roster.Districts == nil ||
+		len(*roster.Districts) == 0 {
+		return generated.District{}, false
+	}
+	return (*roster.Districts)[0], true
+}
+
+func orNever(at string) string {
+	if at == "" {
+		return "never"
+	}
+	return at
+}
+
+// HealthFailed is true if any health check failed
+func (r *MissingReport) HealthFailed() bool {
+	return len(r.FailedHealthChecks()) > 0
+}
+
+// FailedHealthChecks are the health checks that failed
+func (r *MissingReport) FailedHealthChecks() []HealthCheck {
+	var failed []HealthCheck
+	for _, check := range r.Health {
+		if !check.Passed {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
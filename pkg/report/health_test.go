@@ -0,0 +1,169 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+func districtRoster(district generated.District) *rostering.Roster {
+	return &rostering.Roster{Districts: &[]generated.District{district}}
+}
+
+func TestCheckHealth(t *testing.T) {
+	now := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	healthy := generated.District{
+		Id:       str("d1"),
+		LastSync: str("2020-09-01T10:00:00Z"),
+	}
+	tests := []struct {
+		name        string
+		growth      *rostering.Roster
+		accelerator *rostering.Roster
+		maxSyncAge  time.Duration
+		want        []HealthCheck
+	}{
+		{
+			name:        "neither app sees the district",
+			growth:      &rostering.Roster{},
+			accelerator: nil,
+			maxSyncAge:  24 * time.Hour,
+		},
+		{
+			name:        "healthy",
+			growth:      districtRoster(healthy),
+			accelerator: districtRoster(healthy),
+			maxSyncAge:  24 * time.Hour,
+			want: []HealthCheck{
+				{
+					Check:  HealthError,
+					App:    rostering.AppMAPAccelerator,
+					Passed: true,
+					Detail: "No error reported",
+				},
+				{
+					Check:  HealthLastSync,
+					App:    rostering.AppMAPAccelerator,
+					Passed: true,
+					Detail: "Last synced at 2020-09-01T10:00:00Z",
+				},
+				{
+					Check:  HealthPaused,
+					App:    rostering.AppMAPAccelerator,
+					Passed: true,
+					Detail: "Not paused",
+				},
+				{
+					Check:  HealthError,
+					App:    rostering.AppMAPGrowth,
+					Passed: true,
+					Detail: "No error reported",
+				},
+				{
+					Check:  HealthLastSync,
+					App:    rostering.AppMAPGrowth,
+					Passed: true,
+					Detail: "Last synced at 2020-09-01T10:00:00Z",
+				},
+				{
+					Check:  HealthPaused,
+					App:    rostering.AppMAPGrowth,
+					Passed: true,
+					Detail: "Not paused",
+				},
+				{
+					Check:  HealthSyncMatch,
+					Passed: true,
+					Detail: "Both apps last synced at 2020-09-01T10:00:00Z",
+				},
+			},
+		},
+		{
+			name: "failing, as seen by one app",
+			growth: districtRoster(generated.District{
+				Id:         str("d1"),
+				Error:      str("SIS credentials expired"),
+				PauseStart: str("2020-08-01"),
+			}),
+			maxSyncAge: time.Hour,
+			want: []HealthCheck{
+				{
+					Check:  HealthError,
+					App:    rostering.AppMAPGrowth,
+					Detail: "Clever reports: SIS credentials expired",
+				},
+				{
+					Check:  HealthLastSync,
+					App:    rostering.AppMAPGrowth,
+					Detail: "Last synced at never, more than 1h0m0s ago",
+				},
+				{
+					Check:  HealthPaused,
+					App:    rostering.AppMAPGrowth,
+					Detail: "Paused since 2020-08-01 with no end set",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkHealth(tt.growth, tt.accelerator, now, tt.maxSyncAge)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkHealth() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckHealthDetails(t *testing.T) {
+	now := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	growth := generated.District{
+		Id:         str("d1"),
+		LastSync:   str("2020-09-01T10:00:00Z"),
+		PauseStart: str("2020-08-01"),
+		PauseEnd:   str("2020-10-01"),
+	}
+	accelerator := generated.District{Id: str("d1")}
+	// Without a maximum sync age, never having synced isn't flagged
+	checks := checkHealth(
+		districtRoster(growth),
+		districtRoster(accelerator),
+		now,
+		0,
+	)
+	details := map[string]string{}
+	for _, check := range checks {
+		if !check.Passed {
+			details[check.App+" "+check.Check] = check.Detail
+		}
+	}
+	want := map[string]string{
+		"map_growth paused": "Paused from 2020-08-01 until 2020-10-01",
+		" sync_match": "MAP Accelerator last synced at never but " +
+			"MAP Growth at 2020-09-01T10:00:00Z",
+	}
+	if !reflect.DeepEqual(details, want) {
+		t.Errorf("failed checks = %q, want %q", details, want)
+	}
+}
+
+func TestHealthFailed(t *testing.T) {
+	r := &MissingReport{Health: []HealthCheck{
+		{Check: HealthError, Passed: true},
+		{Check: HealthPaused, Passed: true},
+	}}
+	if r.HealthFailed() || r.FailedHealthChecks() != nil {
+		t.Errorf("HealthFailed() with every check passed")
+	}
+	r.Health[1].Passed = false
+	if !r.HealthFailed() {
+		t.Errorf("HealthFailed() = false with a failed check")
+	}
+	failed := r.FailedHealthChecks()
+	if len(failed) != 1 || failed[0].Check != HealthPaused {
+		t.Errorf("FailedHealthChecks() = %+v, want the pause", failed)
+	}
+}
@@ -0,0 +1,86 @@
+package report
+
+import (
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// note not defensively nil safe, but in practice probably ok?
+func findMissingStudents(
+	mapGrowthRoster *rostering.Roster,
+	mapAcceleratorRoster *rostering.Roster,
+) []generated.Student {
+	mapGrowthStudentIDmap := map[string]bool{}
+	for i := range *mapGrowthRoster.Students {
+		mapGrowthStudent := (*mapGrowthRoster.Students)[i]
+		mapGrowthStudentIDmap[*mapGrowthStudent.Id] = true
+	}
+	var missingStudents []generated.Student
+	for i := range *mapAcceleratorRoster.Students {
+		mapAcceleratorStudent := (*mapAcceleratorRoster.Students)[i]
+		if _, ok := mapGrowthStudentIDmap[*mapAcceleratorStudent.Id]; !ok {
+			missingStudents = append(missingStudents, mapAcceleratorStudent)
+		}
+	}
+	return missingStudents
+}
+
+// note not defensively nil safe, but in practice probably ok?
+func findMissingTeachers(
+	mapGrowthRoster *rostering.Roster,
+	mapAcceleratorRoster *rostering.Roster,
+) []generated.Teacher {
+	mapGrowthTeacherIDmap := map[string]bool{}
+	for i := range *mapGrowthRoster.Teachers {
+		mapGrowthTeacher := (*mapGrowthRoster.Teachers)[i]
+		mapGrowthTeacherIDmap[*mapGrowthTeacher.Id] = true
+	}
+	var missing []generated.Teacher
+	for i := range *mapAcceleratorRoster.Teachers {
+		mapAcceleratorTeacher := (*mapAcceleratorRoster.Teachers)[i]
+		if _, ok := mapGrowthTeacherIDmap[*mapAcceleratorTeacher.Id]; !ok {
+			missing = append(missing, mapAcceleratorTeacher)
+		}
+	}
+	return missing
+}
+
+// note not defensively nil safe, but in practice probably ok?
+func findMissingSchools(
+	mapGrowthRoster *rostering.Roster,
+	mapAcceleratorRoster *rostering.Roster,
+) []generated.School {
+	mapGrowthSchoolIDmap := map[string]bool{}
+	for i := range *mapGrowthRoster.Schools {
+		mapGrowthSchool := (*mapGrowthRoster.Schools)[i]
+		mapGrowthSchoolIDmap[*mapGrowthSchool.Id] = true
+	}
+	var missing []generated.School
+	for i := range *mapAcceleratorRoster.Schools {
+		mapAcceleratorSchool := (*mapAcceleratorRoster.Schools)[i]
+		if _, ok := mapGrowthSchoolIDmap[*mapAcceleratorSchool.Id]; !ok {
+			missing = append(missing, mapAcceleratorSchool)
+		}
+	}
+	return missing
+}
+
+// note not defensively nil safe, but in practice probably ok?
+func findMissingSections(
+	mapGrowthRoster *rostering.Roster,
+	mapAcceleratorRoster *rostering.Roster,
+) []generated.Section {
+	mapGrowthSectionIDmap := map[string]bool{}
+	for i := range *mapGrowthRoster.Sections {
+		mapGrowthSection := (*mapGrowthRoster.Sections)[i]
+		mapGrowthSectionIDmap[*mapGrowthSection.Id] = true
+	}
+	var missing []generated.Section
+	for i := range *mapAcceleratorRoster.Sections {
+		mapAcceleratorSection := (*mapAcceleratorRoster.Sections)[i]
+		if _, ok := mapGrowthSectionIDmap[*mapAcceleratorSection.Id]; !ok {
+			missing = append(missing, mapAcceleratorSection)
+		}
+	}
+	return missing
+}
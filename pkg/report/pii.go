@@ -0,0 +1,177 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PIIField is a personally identifying detail of a student or teacher that
+// may be left out of reports
+type PIIField string
+
+const (
+	PIIName  PIIField = "name"
+	PIISisID PIIField = "sis_id"
+	// PIINumber is the StudentNumber or TeacherNumber
+	PIINumber PIIField = "number"
+	PIIEmail  PIIField = "email"
+	// PIIDOB is a student's date of birth
+	PIIDOB PIIField = "dob"
+)
+
+// AllPIIFields is every PIIField, in the order they are documented
+var AllPIIFields = []PIIField{PIIName, PIISisID, PIINumber, PIIEmail, PIIDOB}
+
+// DefaultPIIFields are the fields included unless asked otherwise. Emails and
+// dates of birth are left out unless explicitly asked for.
+var DefaultPIIFields = []PIIField{PIIName, PIISisID, PIINumber}
+
+// PIIFields is the set of PII fields to include in reports. It implements
+// flag.Value, parsing a comma separated list, "all" or "none".
+type PIIFields map[PIIField]bool
+
+// ParsePIIFields parses a comma separated list of PII fields, "all" or "none"
+func ParsePIIFields(list string) (PIIFields, error) {
+	fields := PIIFields{}
+	return fields, fields.Set(list)
+}
+
+// String lists the fields in a form ParsePIIFields accepts
+func (f PIIFields) String() string {
+	var names []string
+	for field, included := range f {
+		if included {
+			names = append(names, string(field))
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Set replaces the fields with a comma separated list, "all" or "none"
+func (f PIIFields) Set(list string) error {
+	for field := range f {
+		delete(f, field)
+	}
+	switch strings.TrimSpace(strings.ToLower(list)) {
+	case "", "none":
+		return nil
+	case "all":
+		for _, field := range AllPIIFields {
+			f[field] = true
+		}
+		return nil
+	}
+	for _, name := range strings.Split(list, ",") {
+		field := PIIField(strings.TrimSpace(strings.ToLower(name)))
+		if !isPIIField(field) {
+			return fmt.Errorf(
+				"unknown PII field %q, must be one of %v, all or none",
+				name,
+				AllPIIFields,
+			)
+		}
+		f[field] = true
+	}
+	return nil
+}
+
+// cleverJSONPaths are the dotted paths of each field in the Clever API
+// objects of people, e.g. a student
+var cleverJSONPaths = map[PIIField][]string{
+	PIIName:   {"name.first", "name.middle", "name.last"},
+	PIISisID:  {"sis_id"},
+	PIINumber: {"student_number", "teacher_number"},
+	PIIEmail:  {"email"},
+	PIIDOB:    {"dob"},
+}
+
+// CleverJSONKeys are the keys that hold the fields in Clever API responses,
+// for redacting them from raw HTTP bodies
+func (f PIIFields) CleverJSONKeys() []string {
+	var keys []string
+	for _, field := range AllPIIFields {
+		if !f[field] {
+			continue
+		}
+		for _, path := range cleverJSONPaths[field] {
+			keys = append(keys, path[strings.LastIndex(path, ".")+1:])
+		}
+	}
+	return keys
+}
+
+// Omit removes the PII fields that are not included from the Clever API
+// object of a person decoded as JSON. Fields are found by their path, so
+// only the person's own name.first is removed, not a first key anywhere in
+// the object. Objects left empty are removed too, e.g. a name without its
+// parts.
+func (f PIIFields) Omit(object map[string]interface{}) {
+	for _, field := range AllPIIFields {
+		if f[field] {
+			continue
+		}
+		for _, path := range cleverJSONPaths[field] {
+			omitPath(object, strings.Split(path, "."))
+		}
+	}
+}
+
+// omitPath removes the key at the end of the path. A list along the path
+// has the rest of the path removed from each of its objects.
+func omitPath(value interface{}, path []string) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			omitPath(item, path)
+		}
+	case map[string]interface{}:
+		key := path[0]
+		if len(path) == 1 {
+			delete(v, key)
+			return
+		}
+		nested, ok := v[key]
+		if !ok {
+			return
+		}
+		omitPath(nested, path[1:])
+		if object, ok := nested.(map[string]interface{}); ok &&
+			len(object) == 0 {
+			delete(v, key)
+		}
+	}
+}
+
+// scrub blanks the PII fields that are not included
+func (f PIIFields) scrub(d Discrepancy) Discrepancy {
+	if !f[PIIName] {
+		d.Name = ""
+	}
+	if !f[PIISisID] {
+		d.SisID = ""
+	}
+	if !f[PIINumber] {
+		d.Number = ""
+	}
+	if !f[PIIEmail] {
+		d.Email = ""
+	}
+	if !f[PIIDOB] {
+		d.DOB = ""
+	}
+	return d
+}
+
+func isPIIField(field PIIField) bool {
+	for _, known := range AllPIIFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package report
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPIIFieldsOmit(t *testing.T) {
+	// A student as the Clever API returns it, with keys named like PII
+	// fields in places that aren't the student's own details
+	student := `{
+		"id": "5f1e2d3c4b5a69788796b003",
+		"name": {"first": "Grace", "middle": "B", "last": "Hopper"},
+		"sis_id": "100245678",
+		"student_number": "2045",
+		"email": "grace@example.org",
+		"dob": "1/2/2008",
+		"grade": "7",
+		"credentials": {"district_username": "ghopper"},
+		"ext": {"first": "first period", "email": "opt-in"},
+		"enrollments": [
+			{"school": "5f1e2d3c4b5a69788796a002", "sis_id": "E1"}
+		]
+	}`
+	tests := []struct {
+		name    string
+		include string
+		want    string
+	}{
+		{
+			name:    "all",
+			include: "all",
+			want:    student,
+		},
+		{
+			name:    "none",
+			include: "none",
+			want: `{
+				"id": "5f1e2d3c4b5a69788796b003",
+				"grade": "7",
+				"credentials": {"district_username": "ghopper"},
+				"ext": {"first": "first period", "email": "opt-in"},
+				"enrollments": [
+					{"school": "5f1e2d3c4b5a69788796a002", "sis_id": "E1"}
+				]
+			}`,
+		},
+		{
+			name:    "default",
+			include: "name,sis_id,number",
+			want: `{
+				"id": "5f1e2d3c4b5a69788796b003",
+				"name": {"first": "Grace", "middle": "B", "last": "Hopper"},
+				"sis_id": "100245678",
+				"student_number": "2045",
+				"grade": "7",
+				"credentials": {"district_username": "ghopper"},
+				"ext": {"first": "first period", "email": "opt-in"},
+				"enrollments": [
+					{"school": "5f1e2d3c4b5a69788796a002", "sis_id": "E1"}
+				]
+			}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pii, err := ParsePIIFields(tt.include)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want map[string]interface{}
+			if err := json.Unmarshal([]byte(student), &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			pii.Omit(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Omit() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestOmitPathLists(t *testing.T) {
+	var got, want interface{}
+	if err := json.Unmarshal([]byte(`[
+		{"name": [{"first": "Grace", "last": "Hopper"}, {"first": "Ada"}]},
+		{"name": {"first": "Alan", "middle": "M"}},
+		"not an object"
+	]`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`[
+		{"name": [{"last": "Hopper"}, {}]},
+		{"name": {"middle": "M"}},
+		"not an object"
+	]`), &want); err != nil {
+		t.Fatal(err)
+	}
+	omitPath(got, []string{"name", "first"})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("omitPath() = %v, want %v", got, want)
+	}
+}
+
+func TestCleverJSONKeys(t *testing.T) {
+	pii, err := ParsePIIFields("email,name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first", "middle", "last", "email"}
+	if got := pii.CleverJSONKeys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CleverJSONKeys() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package report
+
+import (
+	"strings"
+
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// PseudonymMatch is a record whose field hashes to a pseudonym
+type PseudonymMatch struct {
+	Pseudonym string
+	// Type is "student" or "teacher"
+	Type     string
+	Field    PIIField
+	CleverID string
+}
+
+// RevealPseudonyms maps pseudonyms back to the records they were made from,
+// by hashing every student and teacher in the roster with the same key.
+// Pseudonyms nobody in the roster hashes to are left out, and a pseudonym
+// shared by several records, e.g. a common name, matches all of them.
+func RevealPseudonyms(
+	roster *rostering.Roster,
+	key []byte,
+	pseudonyms []string,
+) []PseudonymMatch {
+	wanted := map[string]bool{}
+	for _, pseudonym := range pseudonyms {
+		wanted[strings.ToLower(strings.TrimSpace(pseudonym))] = true
+	}
+
+	var matches []PseudonymMatch
+	match := func(entity string, d Discrepancy) {
+		fields := map[PIIField]string{
+			PIIName:   d.Name,
+			PIISisID:  d.SisID,
+			PIINumber: d.Number,
+			PIIEmail:  d.Email,
+			PIIDOB:    d.DOB,
+		}
+		for _, field := range AllPIIFields {
+			if fields[field] == "" {
+				continue
+			}
+			pseudonym := Pseudonym(key, field, fields[field])
+			if wanted[pseudonym] {
+				matches = append(matches, PseudonymMatch{
+					Pseudonym: pseudonym,
+					Type:      entity,
+					Field:     field,
+					CleverID:  d.CleverID,
+				})
+			}
+		}
+	}
+
+	schoolNames := schoolNames(roster)
+	if roster.Students != nil {
+		for i := range *roster.Students {
+			student := (*roster.Students)[i]
+			match("student", studentDiscrepancy(student, schoolNames))
+		}
+	}
+	if roster.Teachers != nil {
+		for i := range *roster.Teachers {
+			teacher := (*roster.Teachers)[i]
+			match("teacher", teacherDiscrepancy(teacher, schoolNames))
+		}
+	}
+	return matches
+}
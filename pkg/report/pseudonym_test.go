@@ -0,0 +1,101 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+func TestRevealPseudonyms(t *testing.T) {
+	key := []byte("secret")
+	str := func(s string) *string { return &s }
+	roster := &rostering.Roster{
+		Students: &[]generated.Student{
+			{
+				Id:    str("5f1e2d3c4b5a69788796b001"),
+				Name:  &generated.Name{First: str("Ada"), Last: str("Lovelace")},
+				SisId: str("1001"),
+			},
+			{
+				Id:    str("5f1e2d3c4b5a69788796b002"),
+				Name:  &generated.Name{First: str("Ada"), Last: str("Lovelace")},
+				SisId: str("1002"),
+			},
+		},
+		Teachers: &[]generated.Teacher{
+			{
+				Id:    str("5f1e2d3c4b5a69788796c001"),
+				Email: str("anne@example.org"),
+			},
+		},
+	}
+	name := Pseudonym(key, PIIName, "Ada Lovelace")
+	sisID := Pseudonym(key, PIISisID, "1002")
+	email := Pseudonym(key, PIIEmail, "anne@example.org")
+
+	tests := []struct {
+		name       string
+		key        []byte
+		pseudonyms []string
+		want       []PseudonymMatch
+	}{
+		{
+			name:       "shared name matches every record",
+			key:        key,
+			pseudonyms: []string{name},
+			want: []PseudonymMatch{
+				{
+					Pseudonym: name,
+					Type:      "student",
+					Field:     PIIName,
+					CleverID:  "5f1e2d3c4b5a69788796b001",
+				},
+				{
+					Pseudonym: name,
+					Type:      "student",
+					Field:     PIIName,
+					CleverID:  "5f1e2d3c4b5a69788796b002",
+				},
+			},
+		},
+		{
+			name:       "students and teachers",
+			key:        key,
+			pseudonyms: []string{" " + sisID + " ", email},
+			want: []PseudonymMatch{
+				{
+					Pseudonym: sisID,
+					Type:      "student",
+					Field:     PIISisID,
+					CleverID:  "5f1e2d3c4b5a69788796b002",
+				},
+				{
+					Pseudonym: email,
+					Type:      "teacher",
+					Field:     PIIEmail,
+					CleverID:  "5f1e2d3c4b5a69788796c001",
+				},
+			},
+		},
+		{
+			name:       "unknown pseudonym",
+			key:        key,
+			pseudonyms: []string{"name:0000000000000000"},
+		},
+		{
+			name:       "wrong key",
+			key:        []byte("other"),
+			pseudonyms: []string{name, sisID, email},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RevealPseudonyms(roster, tt.key, tt.pseudonyms)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RevealPseudonyms() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package report
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// RedactAction is what a RedactionPolicy does to one PII field
+type RedactAction string
+
+const (
+	RedactKeep RedactAction = "keep"
+	RedactDrop RedactAction = "drop"
+	// RedactMask hides most of the value but keeps enough to tell records
+	// apart at a glance, e.g. initials or the last digits of an ID
+	RedactMask RedactAction = "mask"
+	// RedactHash replaces the value with a pseudonym, a keyed HMAC that only
+	// someone with the key can map back to a record
+	RedactHash RedactAction = "hash"
+)
+
+// PseudonymKeyEnv is the environment variable holding the HMAC key
+// pseudonyms are made with
+const PseudonymKeyEnv = "PII_HMAC_KEY"
+
+// RedactionPolicy is what to do to each PII field of the students and
+// teachers in one report output. Fields it doesn't mention are kept. It
+// implements flag.Value, parsing a comma separated list of field=action,
+// e.g. "name=hash,sis_id=mask,email=drop". An action on its own, e.g.
+// "drop", applies to every field.
+type RedactionPolicy map[PIIField]RedactAction
+
+// DefaultEmailRedaction masks every PII field, so that emails, which leave
+// the building, only carry full details when asked for
+func DefaultEmailRedaction() RedactionPolicy {
+	policy := RedactionPolicy{}
+	for _, field := range AllPIIFields {
+		policy[field] = RedactMask
+	}
+	return policy
+}
+
+// ParseRedactionPolicy parses a comma separated list of field=action
+func ParseRedactionPolicy(list string) (RedactionPolicy, error) {
+	policy := RedactionPolicy{}
+	return policy, policy.Set(list)
+}
+
+// String lists the policy in a form ParseRedactionPolicy accepts
+func (p RedactionPolicy) String() string {
+	var rules []string
+	for field, action := range p {
+		if action != RedactKeep {
+			rules = append(rules, string(field)+"="+string(action))
+		}
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, ",")
+}
+
+// Set replaces the policy with a comma separated list of field=action.
+// Rules apply in order, so "mask,name=hash" masks every field but the name.
+func (p RedactionPolicy) Set(list string) error {
+	for field := range p {
+		delete(p, field)
+	}
+	for _, rule := range strings.Split(list, ",") {
+		rule = strings.TrimSpace(strings.ToLower(rule))
+		if rule == "" {
+			continue
+		}
+		fields := AllPIIFields
+		name, actionName := "", rule
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, actionName = rule[:i], rule[i+1:]
+			fields = []PIIField{PIIField(name)}
+			if !isPIIField(fields[0]) {
+				return fmt.Errorf(
+					"unknown PII field %q, must be one of %v",
+					name,
+					AllPIIFields,
+				)
+			}
+		}
+		action := RedactAction(actionName)
+		switch action {
+		case RedactKeep, RedactDrop, RedactMask, RedactHash:
+		default:
+			return fmt.Errorf(
+				"unknown redaction %q, must be keep, drop, mask or hash",
+				actionName,
+			)
+		}
+		for _, field := range fields {
+			p[field] = action
+		}
+	}
+	return nil
+}
+
+// NeedsKey is true if the policy makes pseudonyms
+func (p RedactionPolicy) NeedsKey() bool {
+	for _, action := range p {
+		if action == RedactHash {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns a copy of the report with the policy applied to its students
+// and teachers. Schools and sections have no PII and are left as they are.
+// key is only needed if the policy hashes a field.
+func (p RedactionPolicy) Apply(
+	report *MissingReport,
+	key []byte,
+) (*MissingReport, error) {
+	if p.NeedsKey() && len(key) == 0 {
+		return nil, fmt.Errorf(
+			"redaction policy %s needs a key in %s",
+			p,
+			PseudonymKeyEnv,
+		)
+	}
+	redacted := *report
+	redacted.MissingStudents = p.redactAll(report.MissingStudents, key)
+	redacted.MissingTeachers = p.redactAll(report.MissingTeachers, key)
+	return &redacted, nil
+}
+
+func (p RedactionPolicy) redactAll(
+	discrepancies []Discrepancy,
+	key []byte,
+) []Discrepancy {
+	if len(p) == 0 || discrepancies == nil {
+		return discrepancies
+	}
+	redacted := make([]Discrepancy, len(discrepancies))
+	for i, d := range discrepancies {
+		d.Name = p.redact(PIIName, d.Name, key)
+		d.SisID = p.redact(PIISisID, d.SisID, key)
+		d.Number = p.redact(PIINumber, d.Number, key)
+		d.Email = p.redact(PIIEmail, d.Email, key)
+		d.DOB = p.redact(PIIDOB, d.DOB, key)
+		redacted[i] = d
+	}
+	return redacted
+}
+
+func (p RedactionPolicy) redact(
+	field PIIField,
+	value string,
+	key []byte,
+) string {
+	if value == "" {
+		return ""
+	}
+	switch p[field] {
+	case RedactDrop:
+		return ""
+	case RedactMask:
+		return mask(field, value)
+	case RedactHash:
+		return Pseudonym(key, field, value)
+	default:
+		return value
+	}
+}
+
+// Pseudonym is the keyed HMAC-SHA256 of a field's value, shortened to 16 hex
+// digits and prefixed with the field, e.g. "sis_id:9f86d081884c7d65". The
+// same value always gets the same pseudonym, so records can still be
+// counted and matched across reports without revealing who they are.
+func Pseudonym(key []byte, field PIIField, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(string(field) + ":" + value))
+	return string(field) + ":" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// PseudonymKeyFromEnv reads the pseudonym HMAC key from PII_HMAC_KEY
+func PseudonymKeyFromEnv() []byte {
+	return []byte(os.Getenv(PseudonymKeyEnv))
+}
+
+var year = regexp.MustCompile(`\d{4}`)
+
+// mask keeps initials of a name, the first letter and domain of an email,
+// the year of a date of birth, and the last 4 characters of anything else
+func mask(field PIIField, value string) string {
+	switch field {
+	case PIIName:
+		var initials []string
+		for _, part := range strings.Fields(value) {
+			r, _ := utf8.DecodeRuneInString(part)
+			initials = append(initials, string(r)+".")
+		}
+		return strings.Join(initials, " ")
+	case PIIEmail:
+		at := strings.LastIndex(value, "@")
+		if at <= 0 {
+			return stars(value)
+		}
+		r, _ := utf8.DecodeRuneInString(value)
+		return string(r) + "***" + value[at:]
+	case PIIDOB:
+		loc := year.FindStringIndex(value)
+		if loc == nil {
+			return stars(value)
+		}
+		return stars(value[:loc[0]]) + value[loc[0]:loc[1]] +
+			stars(value[loc[1]:])
+	default:
+		runes := []rune(value)
+		if len(runes) <= 4 {
+			return stars(value)
+		}
+		return strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-4:])
+	}
+}
+
+// stars replaces every letter and digit with *, keeping separators
+func stars(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '/' || r == '.' || r == ' ' {
+			return r
+		}
+		return '*'
+	}, value)
+}
@@ -0,0 +1,227 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactionPolicySet(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    string
+		wantErr bool
+	}{
+		{list: "", want: ""},
+		{list: "keep", want: ""},
+		{list: "name=hash", want: "name=hash"},
+		{
+			list: "name=hash, SIS_ID=Mask,email=drop",
+			want: "email=drop,name=hash,sis_id=mask",
+		},
+		{
+			list: "mask",
+			want: "dob=mask,email=mask,name=mask,number=mask,sis_id=mask",
+		},
+		{
+			list: "mask,name=hash",
+			want: "dob=mask,email=mask,name=hash,number=mask,sis_id=mask",
+		},
+		{
+			list: "name=hash,drop",
+			want: "dob=drop,email=drop,name=drop,number=drop,sis_id=drop",
+		},
+		{
+			list: "mask,name=keep",
+			want: "dob=mask,email=mask,number=mask,sis_id=mask",
+		},
+		{list: "nickname=drop", wantErr: true},
+		{list: "name=blur", wantErr: true},
+		{list: "blur", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			policy := RedactionPolicy{PIIDOB: RedactHash}
+			err := policy.Set(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf(
+					"Set(%q) error = %v, want error %v",
+					tt.list,
+					err,
+					tt.wantErr,
+				)
+			}
+			if err == nil && policy.String() != tt.want {
+				t.Errorf("Set(%q) = %s, want %s", tt.list, policy, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultEmailRedaction(t *testing.T) {
+	policy := DefaultEmailRedaction()
+	for _, field := range AllPIIFields {
+		if policy[field] != RedactMask {
+			t.Errorf("%s = %q, want mask", field, policy[field])
+		}
+	}
+	if policy.NeedsKey() {
+		t.Error("default email redaction needs a key")
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		field PIIField
+		value string
+		want  string
+	}{
+		{field: PIIName, value: "Grace Brewster Hopper", want: "G. B. H."},
+		{field: PIIName, value: "Émile Zola", want: "É. Z."},
+		{field: PIIEmail, value: "grace@example.org", want: "g***@example.org"},
+		{field: PIIEmail, value: "not-an-email", want: "***-**-*****"},
+		{field: PIIDOB, value: "12/9/1906", want: "**/*/1906"},
+		{field: PIIDOB, value: "1906-12-09", want: "1906-**-**"},
+		{field: PIIDOB, value: "unknown", want: "*******"},
+		{field: PIISisID, value: "100245678", want: "*****5678"},
+		{field: PIINumber, value: "S1", want: "**"},
+		{field: PIINumber, value: "1234", want: "****"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.field)+" "+tt.value, func(t *testing.T) {
+			if got := mask(tt.field, tt.value); got != tt.want {
+				t.Errorf(
+					"mask(%s, %q) = %q, want %q",
+					tt.field,
+					tt.value,
+					got,
+					tt.want,
+				)
+			}
+		})
+	}
+}
+
+func TestPseudonym(t *testing.T) {
+	key := []byte("secret")
+	got := Pseudonym(key, PIISisID, "1003")
+	if want := "sis_id:7a007afe95ec1cd4"; got != want {
+		t.Errorf("Pseudonym() = %s, want %s", got, want)
+	}
+	if again := Pseudonym(key, PIISisID, "1003"); again != got {
+		t.Errorf("Pseudonym() = %s then %s, want the same", got, again)
+	}
+	others := []string{
+		Pseudonym([]byte("other"), PIISisID, "1003"),
+		Pseudonym(key, PIINumber, "1003"),
+		Pseudonym(key, PIISisID, "1004"),
+	}
+	for _, other := range others {
+		if other[len(other)-16:] == got[len(got)-16:] {
+			t.Errorf(
+				"Pseudonym() = %s for a different key, field or value",
+				other,
+			)
+		}
+	}
+}
+
+func TestRedactionPolicyApply(t *testing.T) {
+	key := []byte("secret")
+	student := Discrepancy{
+		CleverID: "5f1e2d3c4b5a69788796b003",
+		Name:     "Grace Hopper",
+		SisID:    "1003",
+		Number:   "S1003",
+		School:   "Roosevelt Middle",
+		Grade:    "7",
+		Email:    "grace@example.org",
+		DOB:      "12/9/1906",
+	}
+	school := Discrepancy{
+		CleverID: "5f1e2d3c4b5a69788796a002",
+		Name:     "Roosevelt Middle",
+		SisID:    "200",
+	}
+	tests := []struct {
+		name   string
+		policy string
+		want   Discrepancy
+	}{
+		{name: "keep", policy: "keep", want: student},
+		{
+			name:   "drop",
+			policy: "drop",
+			want: Discrepancy{
+				CleverID: student.CleverID,
+				School:   student.School,
+				Grade:    student.Grade,
+			},
+		},
+		{
+			name:   "mask then hash the name",
+			policy: "mask,name=hash",
+			want: Discrepancy{
+				CleverID: student.CleverID,
+				Name:     Pseudonym(key, PIIName, "Grace Hopper"),
+				SisID:    "****",
+				Number:   "*1003",
+				School:   student.School,
+				Grade:    student.Grade,
+				Email:    "g***@example.org",
+				DOB:      "**/*/1906",
+			},
+		},
+		{
+			name:   "one field",
+			policy: "email=drop",
+			want: Discrepancy{
+				CleverID: student.CleverID,
+				Name:     student.Name,
+				SisID:    student.SisID,
+				Number:   student.Number,
+				School:   student.School,
+				Grade:    student.Grade,
+				DOB:      student.DOB,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, parseErr := ParseRedactionPolicy(tt.policy)
+			if parseErr != nil {
+				t.Fatal(parseErr)
+			}
+			original := &MissingReport{
+				MissingStudents: []Discrepancy{student},
+				MissingSchools:  []Discrepancy{school},
+			}
+			got, err := policy.Apply(original, key)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.MissingStudents, []Discrepancy{tt.want}) {
+				t.Errorf(
+					"students = %+v, want %+v",
+					got.MissingStudents,
+					tt.want,
+				)
+			}
+			if !reflect.DeepEqual(got.MissingSchools, []Discrepancy{school}) {
+				t.Errorf("schools = %+v, want them kept", got.MissingSchools)
+			}
+			if original.MissingStudents[0] != student {
+				t.Errorf(
+					"Apply() changed the original to %+v",
+					original.MissingStudents[0],
+				)
+			}
+		})
+	}
+}
+
+func TestRedactionPolicyApplyNeedsKey(t *testing.T) {
+	policy := RedactionPolicy{PIIName: RedactHash}
+	if _, err := policy.Apply(&MissingReport{}, nil); err == nil {
+		t.Error("Apply() of a hash without a key succeeded")
+	}
+}
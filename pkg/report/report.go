@@ -0,0 +1,283 @@
+// Package report compares what two Clever apps can see in a district and
+// describes the discrepancies in terms a person can act on.
+package report
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+	"github.com/Khan/clever-repartee/pkg/tripperware"
+)
+
+// MissingReport lists the records the MAP Accelerator app can see in a
+// district that the MAP Growth app cannot
+type MissingReport struct {
+	DistrictName     string
+	DistrictCleverID string
+	MissingStudents  []Discrepancy
+	MissingTeachers  []Discrepancy
+	MissingSchools   []Discrepancy
+	MissingSections  []Discrepancy
+	// BySchool has every school with missing records, the worst affected
+	// first
+	BySchool []SchoolBreakdown
+	// ByGrade has every grade with missing students or sections
+	ByGrade []GradeBreakdown
+	// HighlightPercent is the share of a school's roster that has to be
+	// missing for the school to be highlighted
+	HighlightPercent float64
+	// CircuitBreakers are the Clever API circuits that opened during the
+	// run, which may have left gaps the run recovered from
+	CircuitBreakers []tripperware.BreakerState `json:",omitempty"`
+	// Health are the checks on the district's sync
+	Health []HealthCheck `json:",omitempty"`
+}
+
+// Options control what goes into a MissingReport
+type Options struct {
+	// PII is the set of PII fields to keep
+	PII PIIFields
+	// HighlightPercent is the share of a school's roster that has to be
+	// missing for the school to be highlighted
+	HighlightPercent float64
+	// MaxSyncAge is how long ago the district can have last synced before
+	// its health check fails. Zero skips the health checks.
+	MaxSyncAge time.Duration
+	// Now is when the health checks are made, the current time if zero
+	Now time.Time
+}
+
+// Discrepancy is one missing record, with enough detail to recognise it
+// without looking the Clever ID up in the Clever dashboard
+type Discrepancy struct {
+	CleverID string
+	Name     string `json:",omitempty"`
+	SisID    string `json:",omitempty"`
+	// Number is the StudentNumber, TeacherNumber, SchoolNumber or
+	// SectionNumber
+	Number string `json:",omitempty"`
+	// SchoolID and School are the record's primary school, if any
+	SchoolID string `json:",omitempty"`
+	School   string `json:",omitempty"`
+	// Grade is a student's or section's grade, or a school's grade range
+	Grade string `json:",omitempty"`
+	// Email is a student's or teacher's email address
+	Email string `json:",omitempty"`
+	// DOB is a student's date of birth
+	DOB string `json:",omitempty"`
+}
+
+// Details describes the record in one line, e.g.
+// "Jane Doe, SIS ID 1234, number 5678, Lincoln Elementary, grade 3"
+func (d Discrepancy) Details() string {
+	var details []string
+	if d.Name != "" {
+		details = append(details, d.Name)
+	}
+	if d.SisID != "" {
+		details = append(details, "SIS ID "+d.SisID)
+	}
+	if d.Number != "" {
+		details = append(details, "number "+d.Number)
+	}
+	if d.School != "" {
+		details = append(details, d.School)
+	} else if d.SchoolID != "" {
+		details = append(details, "school "+d.SchoolID)
+	}
+	if d.Grade != "" {
+		details = append(details, "grade "+d.Grade)
+	}
+	if d.Email != "" {
+		details = append(details, d.Email)
+	}
+	if d.DOB != "" {
+		details = append(details, "born "+d.DOB)
+	}
+	return strings.Join(details, ", ")
+}
+
+// NewMissingReport finds what the MAP Growth roster is missing compared to
+// the MAP Accelerator roster. The details of each record come from the
+// Accelerator roster, since that is the one that has it, and only the PII
+// fields listed in the options are kept.
+func NewMissingReport(
+	districtName, districtCleverID string,
+	mapGrowthRoster *rostering.Roster,
+	mapAcceleratorRoster *rostering.Roster,
+	options Options,
+) *MissingReport {
+	pii := options.PII
+	schoolNames := schoolNames(mapAcceleratorRoster, mapGrowthRoster)
+
+	missingReport := &MissingReport{
+		DistrictName:     districtName,
+		DistrictCleverID: districtCleverID,
+		HighlightPercent: options.HighlightPercent,
+	}
+
+	if options.MaxSyncAge > 0 {
+		now := options.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		missingReport.Health = checkHealth(
+			mapGrowthRoster,
+			mapAcceleratorRoster,
+			now,
+			options.MaxSyncAge,
+		)
+	}
+
+	missingStudents := findMissingStudents(mapGrowthRoster, mapAcceleratorRoster)
+	for i := range missingStudents {
+		student := missingStudents[i]
+		missingReport.MissingStudents = append(
+			missingReport.MissingStudents,
+			pii.scrub(studentDiscrepancy(student, schoolNames)),
+		)
+	}
+
+	missingTeachers := findMissingTeachers(mapGrowthRoster, mapAcceleratorRoster)
+	for i := range missingTeachers {
+		teacher := missingTeachers[i]
+		missingReport.MissingTeachers = append(
+			missingReport.MissingTeachers,
+			pii.scrub(teacherDiscrepancy(teacher, schoolNames)),
+		)
+	}
+
+	missingSchools := findMissingSchools(mapGrowthRoster, mapAcceleratorRoster)
+	for i := range missingSchools {
+		school := missingSchools[i]
+		// School names and numbers are public, so they are never scrubbed
+		missingReport.MissingSchools = append(
+			missingReport.MissingSchools,
+			Discrepancy{
+				CleverID: value(school.Id),
+				Name:     value(school.Name),
+				SisID:    value(school.SisId),
+				Number:   value(school.SchoolNumber),
+				Grade:    gradeRange(school.LowGrade, school.HighGrade),
+			},
+		)
+	}
+
+	missingSections := findMissingSections(mapGrowthRoster, mapAcceleratorRoster)
+	for i := range missingSections {
+		section := missingSections[i]
+		missingReport.MissingSections = append(
+			missingReport.MissingSections,
+			Discrepancy{
+				CleverID: value(section.Id),
+				Name:     value(section.Name),
+				SisID:    value(section.SisId),
+				Number:   value(section.SectionNumber),
+				SchoolID: value(section.School),
+				School:   schoolNames[value(section.School)],
+				Grade:    value(section.Grade),
+			},
+		)
+	}
+
+	missingReport.BySchool = breakdownBySchool(
+		mapAcceleratorRoster,
+		missingStudents,
+		missingTeachers,
+		missingSections,
+		schoolNames,
+		options.HighlightPercent,
+	)
+	missingReport.ByGrade = breakdownByGrade(
+		mapAcceleratorRoster,
+		missingStudents,
+		missingSections,
+	)
+
+	return missingReport
+}
+
+func studentDiscrepancy(
+	student generated.Student,
+	schoolNames map[string]string,
+) Discrepancy {
+	return Discrepancy{
+		CleverID: value(student.Id),
+		Name:     fullName(student.Name),
+		SisID:    value(student.SisId),
+		Number:   value(student.StudentNumber),
+		SchoolID: value(student.School),
+		School:   schoolNames[value(student.School)],
+		Grade:    value(student.Grade),
+		Email:    value(student.Email),
+		DOB:      value(student.Dob),
+	}
+}
+
+func teacherDiscrepancy(
+	teacher generated.Teacher,
+	schoolNames map[string]string,
+) Discrepancy {
+	return Discrepancy{
+		CleverID: value(teacher.Id),
+		Name:     fullName(teacher.Name),
+		SisID:    value(teacher.SisId),
+		Number:   value(teacher.TeacherNumber),
+		SchoolID: value(teacher.School),
+		School:   schoolNames[value(teacher.School)],
+		Email:    value(teacher.Email),
+	}
+}
+
+// schoolNames maps school Clever IDs to names, preferring earlier rosters
+func schoolNames(rosters ...*rostering.Roster) map[string]string {
+	names := map[string]string{}
+	for _, roster := range rosters {
+		if roster.Schools == nil {
+			continue
+		}
+		for i := range *roster.Schools {
+			school := (*roster.Schools)[i]
+			id := value(school.Id)
+			if _, ok := names[id]; !ok && school.Name != nil {
+				names[id] = *school.Name
+			}
+		}
+	}
+	return names
+}
+
+func fullName(name *generated.Name) string {
+	if name == nil {
+		return ""
+	}
+	var parts []string
+	for _, part := range []*string{name.First, name.Middle, name.Last} {
+		if part != nil && *part != "" {
+			parts = append(parts, *part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func gradeRange(low, high *string) string {
+	switch {
+	case low == nil && high == nil:
+		return ""
+	case low == nil || *low == value(high):
+		return value(high)
+	case high == nil:
+		return *low
+	default:
+		return *low + "-" + *high
+	}
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
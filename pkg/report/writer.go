@@ -0,0 +1,306 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Writer writes a MissingReport to files in one format
+type Writer interface {
+	// Format is the name used to select the writer, e.g. "csv"
+	Format() string
+	// Write writes the report to one or more files in dir whose names start
+	// with prefix, and returns their paths
+	Write(dir, prefix string, report *MissingReport) ([]string, error)
+}
+
+var writers = map[string]Writer{}
+
+func register(w Writer) {
+	writers[w.Format()] = w
+}
+
+func init() {
+	register(jsonWriter{})
+	register(ndjsonWriter{})
+	register(csvWriter{})
+	register(markdownWriter{})
+	register(htmlWriter{})
+}
+
+// Formats is the name of every available Writer
+func Formats() []string {
+	var formats []string
+	for format := range writers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// NewWriter finds the Writer for a format
+func NewWriter(format string) (Writer, error) {
+	w, ok := writers[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf(
+			"unknown report format %q, must be one of %s",
+			format,
+			strings.Join(Formats(), ", "),
+		)
+	}
+	return w, nil
+}
+
+// FilePrefix names a run's report files after the district and the time the
+// run started, so that repeated runs don't overwrite each other
+func FilePrefix(districtCleverID string, runAt time.Time) string {
+	return districtCleverID + "-" + runAt.UTC().Format("20060102T150405Z")
+}
+
+// WriteAll writes the report in every format to dir, creating it if needed,
+// and returns the paths of every file written
+func WriteAll(
+	dir, prefix string,
+	report *MissingReport,
+	formats []string,
+) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, format := range formats {
+		w, err := NewWriter(format)
+		if err != nil {
+			return paths, err
+		}
+		written, err := w.Write(dir, prefix, report)
+		paths = append(paths, written...)
+		if err != nil {
+			return paths, fmt.Errorf("unable to write %s report: %w", format, err)
+		}
+	}
+	return paths, nil
+}
+
+// FormatList is a repeatable flag.Value collecting report formats, which
+// also accepts comma separated lists
+type FormatList []string
+
+func (f *FormatList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *FormatList) Set(value string) error {
+	for _, format := range strings.Split(value, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" {
+			continue
+		}
+		if _, err := NewWriter(format); err != nil {
+			return err
+		}
+		if !contains(*f, format) {
+			*f = append(*f, format)
+		}
+	}
+	return nil
+}
+
+func writeFile(dir, name string, content []byte) (string, error) {
+	path := filepath.Join(dir, name)
+	return path, ioutil.WriteFile(path, content, 0644)
+}
+
+// jsonWriter writes the whole report as one indented JSON document
+type jsonWriter struct{}
+
+func (jsonWriter) Format() string { return "json" }
+
+func (jsonWriter) Write(
+	dir, prefix string,
+	report *MissingReport,
+) ([]string, error) {
+	file, err := json.MarshalIndent(*report, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	path, err := writeFile(dir, prefix+".json", file)
+	if err != nil {
+		return nil, err
+	}
+	return []string{path}, nil
+}
+
+// ndjsonWriter writes one JSON object per line for every missing record, for
+// loading into log pipelines and databases
+type ndjsonWriter struct{}
+
+func (ndjsonWriter) Format() string { return "ndjson" }
+
+// ndjsonRecord is one line of the ndjson report
+type ndjsonRecord struct {
+	DistrictName     string
+	DistrictCleverID string
+	Type             string
+	Discrepancy
+}
+
+func (ndjsonWriter) Write(
+	dir, prefix string,
+	report *MissingReport,
+) ([]string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, list := range report.lists() {
+		for _, d := range list.missing {
+			err := enc.Encode(ndjsonRecord{
+				DistrictName:     report.DistrictName,
+				DistrictCleverID: report.DistrictCleverID,
+				Type:             list.entity,
+				Discrepancy:      d,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	path, err := writeFile(dir, prefix+".ndjson", buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return []string{path}, nil
+}
+
+// csvWriter writes one CSV file per entity type, plus one per breakdown
+type csvWriter struct{}
+
+func (csvWriter) Format() string { return "csv" }
+
+func (csvWriter) Write(
+	dir, prefix string,
+	report *MissingReport,
+) ([]string, error) {
+	var paths []string
+	for _, list := range report.lists() {
+		var buf bytes.Buffer
+		if err := WriteCSV(&buf, list.missing); err != nil {
+			return paths, err
+		}
+		path, err := writeFile(
+			dir,
+			prefix+"-missing-"+list.entity+"s.csv",
+			buf.Bytes(),
+		)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+
+	var buf bytes.Buffer
+	if err := writeSchoolBreakdownCSV(&buf, report.BySchool); err != nil {
+		return paths, err
+	}
+	path, err := writeFile(dir, prefix+"-by-school.csv", buf.Bytes())
+	if err != nil {
+		return paths, err
+	}
+	paths = append(paths, path)
+
+	buf.Reset()
+	if err = writeGradeBreakdownCSV(&buf, report.ByGrade); err != nil {
+		return paths, err
+	}
+	path, err = writeFile(dir, prefix+"-by-grade.csv", buf.Bytes())
+	if err != nil {
+		return paths, err
+	}
+	return append(paths, path), nil
+}
+
+func writeSchoolBreakdownCSV(w io.Writer, breakdown []SchoolBreakdown) error {
+	rows := [][]string{{
+		"school_id",
+		"school",
+		"missing_students",
+		"students",
+		"missing_teachers",
+		"teachers",
+		"missing_sections",
+		"sections",
+		"percent_affected",
+		"highlighted",
+	}}
+	for _, b := range breakdown {
+		rows = append(rows, []string{
+			b.SchoolID,
+			b.School,
+			strconv.Itoa(b.MissingStudents),
+			strconv.Itoa(b.Students),
+			strconv.Itoa(b.MissingTeachers),
+			strconv.Itoa(b.Teachers),
+			strconv.Itoa(b.MissingSections),
+			strconv.Itoa(b.Sections),
+			strconv.FormatFloat(b.PercentAffected, 'f', 1, 64),
+			strconv.FormatBool(b.Highlighted),
+		})
+	}
+	return writeCSVRows(w, rows)
+}
+
+func writeGradeBreakdownCSV(w io.Writer, breakdown []GradeBreakdown) error {
+	rows := [][]string{{
+		"grade",
+		"missing_students",
+		"students",
+		"missing_sections",
+		"sections",
+		"percent_affected",
+	}}
+	for _, b := range breakdown {
+		rows = append(rows, []string{
+			b.Grade,
+			strconv.Itoa(b.MissingStudents),
+			strconv.Itoa(b.Students),
+			strconv.Itoa(b.MissingSections),
+			strconv.Itoa(b.Sections),
+			strconv.FormatFloat(b.PercentAffected, 'f', 1, 64),
+		})
+	}
+	return writeCSVRows(w, rows)
+}
+
+// entityList is one entity type's missing records
+type entityList struct {
+	// entity is the singular, lower case entity type, e.g. "student"
+	entity  string
+	title   string
+	missing []Discrepancy
+}
+
+func (r *MissingReport) lists() []entityList {
+	return []entityList{
+		{"student", "Students", r.MissingStudents},
+		{"teacher", "Teachers", r.MissingTeachers},
+		{"school", "Schools", r.MissingSchools},
+		{"section", "Sections", r.MissingSections},
+	}
+}
+
+func contains(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
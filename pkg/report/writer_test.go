@@ -0,0 +1,289 @@
+package report
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testReport is missing one student, whose name needs escaping in every
+// document format, and one section
+func testReport() *MissingReport {
+	return &MissingReport{
+		DistrictName:     "Springfield Unified",
+		DistrictCleverID: "5f1e2d3c4b5a69788796a5b4",
+		MissingStudents: []Discrepancy{{
+			CleverID: "5f1e2d3c4b5a69788796b003",
+			Name:     "Grace <b>|Hopper|</b>",
+			SchoolID: "5f1e2d3c4b5a69788796a002",
+			School:   "Roosevelt Middle",
+			Grade:    "7",
+		}},
+		MissingSections: []Discrepancy{{
+			CleverID: "5f1e2d3c4b5a69788796f002",
+			Name:     "Science 7",
+		}},
+		BySchool: []SchoolBreakdown{{
+			SchoolID:        "5f1e2d3c4b5a69788796a002",
+			School:          "Roosevelt Middle",
+			MissingStudents: 1,
+			Students:        3,
+			PercentAffected: 100.0 / 3,
+			Highlighted:     true,
+		}},
+		ByGrade: []GradeBreakdown{{
+			Grade:           "7",
+			MissingStudents: 1,
+			Students:        3,
+			PercentAffected: 100.0 / 3,
+		}},
+		HighlightPercent: DefaultHighlightPercent,
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	want := []string{"csv", "html", "json", "md", "ndjson"}
+	if got := Formats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Formats() = %v, want %v", got, want)
+	}
+	for _, format := range []string{"csv", "CSV", "md"} {
+		w, err := NewWriter(format)
+		if err != nil {
+			t.Errorf("NewWriter(%q) error = %v", format, err)
+			continue
+		}
+		if w.Format() != strings.ToLower(format) {
+			t.Errorf("NewWriter(%q) = %s writer", format, w.Format())
+		}
+	}
+	if _, err := NewWriter("xlsx"); err == nil {
+		t.Error("NewWriter(\"xlsx\") = nil error, want unknown format")
+	}
+}
+
+func TestFormatListSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    FormatList
+		wantErr bool
+	}{
+		{name: "one", values: []string{"json"}, want: FormatList{"json"}},
+		{
+			name:   "repeated and comma separated, without duplicates",
+			values: []string{"CSV, md", "csv", "html,"},
+			want:   FormatList{"csv", "md", "html"},
+		},
+		{
+			name:    "unknown",
+			values:  []string{"json,xlsx"},
+			want:    FormatList{"json"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got FormatList
+			var err error
+			for _, value := range tt.values {
+				if err = got.Set(value); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Set() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("formats = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilePrefix(t *testing.T) {
+	pdt := time.FixedZone("PDT", -7*60*60)
+	runAt := time.Date(2020, 9, 1, 2, 3, 4, 0, pdt)
+	got := FilePrefix("5f1e2d3c4b5a69788796a5b4", runAt)
+	if want := "5f1e2d3c4b5a69788796a5b4-20200901T090304Z"; got != want {
+		t.Errorf("FilePrefix() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir = filepath.Join(dir, "reports")
+
+	paths, err := WriteAll(dir, "test", testReport(), Formats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, path := range paths {
+		names = append(names, filepath.Base(path))
+	}
+	wantNames := []string{
+		"test-missing-students.csv",
+		"test-missing-teachers.csv",
+		"test-missing-schools.csv",
+		"test-missing-sections.csv",
+		"test-by-school.csv",
+		"test-by-grade.csv",
+		"test.html",
+		"test.json",
+		"test.md",
+		"test.ndjson",
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("wrote %v, want %v", names, wantNames)
+	}
+
+	read := func(name string) string {
+		t.Helper()
+		content, readErr := ioutil.ReadFile(filepath.Join(dir, name))
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		return string(content)
+	}
+
+	var got MissingReport
+	jsonErr := json.Unmarshal([]byte(read("test.json")), &got)
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if !reflect.DeepEqual(&got, testReport()) {
+		t.Errorf("JSON report = %+v, want %+v", got, testReport())
+	}
+
+	var types []string
+	lines := bufio.NewScanner(strings.NewReader(read("test.ndjson")))
+	for lines.Scan() {
+		var record ndjsonRecord
+		if jsonErr = json.Unmarshal(lines.Bytes(), &record); jsonErr != nil {
+			t.Fatal(jsonErr)
+		}
+		if record.DistrictCleverID != "5f1e2d3c4b5a69788796a5b4" {
+			t.Errorf("ndjson record %+v has no district", record)
+		}
+		types = append(types, record.Type)
+	}
+	if want := []string{"student", "section"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("ndjson record types = %v, want %v", types, want)
+	}
+
+	rows, csvErr := csv.NewReader(
+		strings.NewReader(read("test-by-school.csv")),
+	).ReadAll()
+	if csvErr != nil {
+		t.Fatal(csvErr)
+	}
+	wantRows := [][]string{
+		{
+			"school_id",
+			"school",
+			"missing_students",
+			"students",
+			"missing_teachers",
+			"teachers",
+			"missing_sections",
+			"sections",
+			"percent_affected",
+			"highlighted",
+		},
+		{
+			"5f1e2d3c4b5a69788796a002",
+			"Roosevelt Middle",
+			"1", "3", "0", "0", "0", "0",
+			"33.3",
+			"true",
+		},
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("by school rows = %q, want %q", rows, wantRows)
+	}
+	header := strings.Join(CSVHeader, ",") + "\n"
+	if got := read("test-missing-teachers.csv"); got != header {
+		t.Errorf("teachers CSV = %q, want only the header", got)
+	}
+
+	documents := []struct {
+		name    string
+		want    []string
+		notWant string
+	}{
+		{
+			name: "test.md",
+			want: []string{
+				`Grace &lt;b&gt;\|Hopper\|&lt;/b&gt;`,
+				"**Roosevelt Middle** | 1 / 3 | 0 / 0 | 0 / 0 | 33.3% |",
+				"## Missing Teachers (0)",
+			},
+			notWant: "<b>",
+		},
+		{
+			name: "test.html",
+			want: []string{
+				"Grace &lt;b&gt;|Hopper|&lt;/b&gt;",
+				`<tr class="highlighted"><td>Roosevelt Middle</td>`,
+				"<h2>Missing Teachers (0)</h2>",
+			},
+			notWant: "<b>",
+		},
+	}
+	for _, d := range documents {
+		content := read(d.name)
+		for _, want := range d.want {
+			if !strings.Contains(content, want) {
+				t.Errorf("%s has no %q", d.name, want)
+			}
+		}
+		if strings.Contains(content, d.notWant) {
+			t.Errorf("%s has an unescaped %q", d.name, d.notWant)
+		}
+	}
+}
+
+func TestWriteAllUnknownFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths, err := WriteAll(dir, "test", testReport(), []string{"json", "xlsx"})
+	if err == nil {
+		t.Error("WriteAll() with an unknown format succeeded")
+	}
+	if len(paths) != 1 {
+		t.Errorf("WriteAll() = %v, want the JSON report written", paths)
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "Grace Hopper", want: "Grace Hopper"},
+		{in: `a|b*c_d` + "`e", want: `a\|b\*c\_d` + "\\`e"},
+		{in: `C:\path`, want: `C:\\path`},
+		{in: "<script>", want: "&lt;script&gt;"},
+		{in: "two\nlines", want: "two lines"},
+	}
+	for _, tt := range tests {
+		if got := escapeMarkdown(tt.in); got != tt.want {
+			t.Errorf("escapeMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
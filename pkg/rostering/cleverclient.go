@@ -14,27 +14,48 @@ import (
 	"github.com/deepmap/oapi-codegen/pkg/securityprovider"
 )
 
+// GetCleverClient calls the Clever API as the app, in the district. Its
+// requests are logged with the app.
 func GetCleverClient(
+	ctx context.Context,
 	logger *zap.Logger,
 	districtID string,
 	isMAP bool,
+	opts ...tripperware.Option,
 ) (*generated.Client, error) {
-	districtToken, err := GetCleverToken(logger, districtID, isMAP)
+	districtToken, err := GetCleverToken(
+		ctx,
+		logger,
+		districtID,
+		isMAP,
+		opts...,
+	)
 	if err != nil {
 		return nil, err
 	}
+	return NewCleverClient(AppLogger(logger, isMAP), districtToken, opts...)
+}
+
+// NewCleverClient calls the Clever API with a district token. The token
+// doesn't say which app it is for, so to log requests with the app, pass
+// an AppLogger.
+func NewCleverClient(
+	logger *zap.Logger,
+	districtToken string,
+	opts ...tripperware.Option,
+) (*generated.Client, error) {
 	bearerTokenProvider, bearerTokenProviderErr := securityprovider.
 		NewSecurityProviderBearerToken(districtToken)
 	if bearerTokenProviderErr != nil {
 		panic(bearerTokenProviderErr)
 	}
 
-	pesterClient := tripperware.NewLoggedRetryHTTPClient(logger)
+	httpClient := tripperware.NewLoggedRetryHTTPClient(logger, opts...)
 
 	client, clientErr := generated.NewClient(
 		"https://api.clever.com/v2.1/", []generated.ClientOption{
 			generated.WithRequestEditorFn(bearerTokenProvider.Intercept),
-			generated.WithHTTPClient(pesterClient),
+			generated.WithHTTPClient(httpClient),
 		}...,
 	)
 	return client, clientErr
@@ -54,13 +75,14 @@ func ParseLinkStartingAfter(nextLink string) string {
 }
 
 func GetCleverDistricts(
+	ctx context.Context,
 	client *generated.Client,
 ) (*[]generated.District, error) {
 	var districts []generated.District
 	districtsParams := &generated.GetDistrictsParams{}
 
 	resp, err := client.GetDistricts(
-		context.Background(), //nolint:ka-context // GKE ≠ AppEngine
+		ctx,
 		districtsParams,
 	)
 	if err != nil {
@@ -79,6 +101,7 @@ func GetCleverDistricts(
 	dec := json.NewDecoder(resp.Body)
 	dec.UseNumber()
 	err = dec.Decode(&districtsResp)
+	resp.Body.Close()
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +118,7 @@ func GetCleverDistricts(
 }
 
 func GetCleverSchools(
+	ctx context.Context,
 	client *generated.Client,
 	limit int,
 ) (*[]generated.School, error) {
@@ -103,7 +127,7 @@ func GetCleverSchools(
 	next := true
 	for next {
 		resp, err := client.GetSchools(
-			context.Background(), //nolint:ka-context // GKE ≠ AppEngine
+			ctx,
 			schoolsParams,
 		)
 		if err != nil {
@@ -114,7 +138,7 @@ func GetCleverSchools(
 			resp.Body.Close()
 			return &schools, fmt.Errorf(
 				"HTTP %d Error for Clever Request /schools starting after %s",
-				resp.StatusCode, *schoolsParams.StartingAfter,
+				resp.StatusCode, startingAfter(schoolsParams.StartingAfter),
 			)
 		}
 
@@ -123,6 +147,7 @@ func GetCleverSchools(
 		dec := json.NewDecoder(resp.Body)
 		dec.UseNumber()
 		err = dec.Decode(schoolsResp)
+		resp.Body.Close()
 		if err != nil {
 			return nil, err
 		}
@@ -149,6 +174,7 @@ func GetCleverSchools(
 }
 
 func GetCleverDistrictAdmins(
+	ctx context.Context,
 	client *generated.Client,
 	limit int,
 ) (*[]generated.DistrictAdmin, error) {
@@ -157,7 +183,7 @@ func GetCleverDistrictAdmins(
 	next := true
 	for next {
 		resp, err := client.GetDistrictAdmins(
-			context.Background(), //nolint:ka-context // GKE ≠ AppEngine
+			ctx,
 			districtAdminsParams,
 		)
 		if err != nil {
@@ -170,7 +196,7 @@ func GetCleverDistrictAdmins(
 			return &districtAdmins, fmt.Errorf(
 				"HTTP %d Error for Clever Request /district_admins starting after %s",
 				resp.StatusCode,
-				*districtAdminsParams.StartingAfter,
+				startingAfter(districtAdminsParams.StartingAfter),
 			)
 		}
 		districtAdminsResp := &generated.DistrictAdminsResponse{}
@@ -178,6 +204,7 @@ func GetCleverDistrictAdmins(
 		dec := json.NewDecoder(resp.Body)
 		dec.UseNumber()
 		err = dec.Decode(districtAdminsResp)
+		resp.Body.Close()
 		if err != nil {
 			return nil, err
 		}
@@ -201,6 +228,7 @@ func GetCleverDistrictAdmins(
 }
 
 func GetCleverStudents(
+	ctx context.Context,
 	client *generated.Client,
 	limit int,
 ) (*[]generated.Student, error) {
@@ -209,7 +237,7 @@ func GetCleverStudents(
 	next := true
 	for next {
 		resp, err := client.GetStudents(
-			context.Background(), //nolint:ka-context // GKE ≠ AppEngine
+			ctx,
 			studentsParams,
 		)
 		if err != nil {
@@ -221,7 +249,7 @@ func GetCleverStudents(
 			resp.Body.Close()
 			return &students, fmt.Errorf(
 				"HTTP %d Error for Clever Request /students starting after %s",
-				resp.StatusCode, *studentsParams.StartingAfter,
+				resp.StatusCode, startingAfter(studentsParams.StartingAfter),
 			)
 		}
 		studentsResp := &generated.StudentsResponse{}
@@ -229,6 +257,7 @@ func GetCleverStudents(
 		dec := json.NewDecoder(resp.Body)
 		dec.UseNumber()
 		err = dec.Decode(studentsResp)
+		resp.Body.Close()
 		if err != nil {
 			return nil, err
 		}
@@ -256,6 +285,7 @@ func GetCleverStudents(
 }
 
 func GetCleverTeachers(
+	ctx context.Context,
 	client *generated.Client,
 	limit int,
 ) (*[]generated.Teacher, error) {
@@ -264,7 +294,7 @@ func GetCleverTeachers(
 	next := true
 	for next {
 		resp, err := client.GetTeachers(
-			context.Background(), //nolint:ka-context // GKE ≠ AppEngine
+			ctx,
 			teachersParams,
 		)
 		if err != nil {
@@ -276,13 +306,14 @@ func GetCleverTeachers(
 			resp.Body.Close()
 			return &teachers, fmt.Errorf(
 				"HTTP %d Error for Clever Request /teachers starting after %s",
-				resp.StatusCode, *teachersParams.StartingAfter,
+				resp.StatusCode, startingAfter(teachersParams.StartingAfter),
 			)
 		}
 		teachersResp := &generated.TeachersResponse{}
 		dec := json.NewDecoder(resp.Body)
 		dec.UseNumber()
 		err = dec.Decode(teachersResp)
+		resp.Body.Close()
 		if err != nil {
 			return nil, err
 		}
@@ -310,6 +341,7 @@ func GetCleverTeachers(
 }
 
 func GetCleverSchoolAdmins(
+	ctx context.Context,
 	client *generated.Client,
 	limit int,
 ) (*[]generated.SchoolAdmin, error) {
@@ -318,7 +350,7 @@ func GetCleverSchoolAdmins(
 	next := true
 	for next {
 		resp, err := client.GetSchoolAdmins(
-			context.Background(), //nolint:ka-context // GKE ≠ AppEngine
+			ctx,
 			schoolAdminsParams,
 		)
 		if err != nil {
@@ -331,7 +363,7 @@ func GetCleverSchoolAdmins(
 			return &schoolAdmins, fmt.Errorf(
 				"HTTP %d Error for Clever Request /school_admins starting after %s",
 				resp.StatusCode,
-				*schoolAdminsParams.StartingAfter,
+				startingAfter(schoolAdminsParams.StartingAfter),
 			)
 		}
 
@@ -339,6 +371,7 @@ func GetCleverSchoolAdmins(
 		dec := json.NewDecoder(resp.Body)
 		dec.UseNumber()
 		err = dec.Decode(schoolAdminsResp)
+		resp.Body.Close()
 		if err != nil {
 			return nil, err
 		}
@@ -365,6 +398,7 @@ func GetCleverSchoolAdmins(
 }
 
 func GetCleverSections(
+	ctx context.Context,
 	client *generated.Client,
 	limit int,
 ) (*[]generated.Section, error) {
@@ -373,7 +407,7 @@ func GetCleverSections(
 	next := true
 	for next {
 		resp, err := client.GetSections(
-			context.Background(), //nolint:ka-context // GKE ≠ AppEngine
+			ctx,
 			sectionsParams,
 		)
 		if err != nil {
@@ -385,7 +419,7 @@ func GetCleverSections(
 			resp.Body.Close()
 			return &sections, fmt.Errorf(
 				"HTTP %d Error for Clever Request /sections starting after %s",
-				resp.StatusCode, *sectionsParams.StartingAfter,
+				resp.StatusCode, startingAfter(sectionsParams.StartingAfter),
 			)
 		}
 
@@ -393,6 +427,7 @@ func GetCleverSections(
 		dec := json.NewDecoder(resp.Body)
 		dec.UseNumber()
 		err = dec.Decode(sectionsResp)
+		resp.Body.Close()
 		if err != nil {
 			return nil, err
 		}
@@ -417,6 +452,131 @@ func GetCleverSections(
 	return &sections, nil
 }
 
+// GetCleverTerms fetches every term the client can see, which GetRoster
+// leaves out
+func GetCleverTerms(
+	ctx context.Context,
+	client *generated.Client,
+	limit int,
+) (*[]generated.Term, error) {
+	var terms []generated.Term
+	termsParams := &generated.GetTermsParams{Limit: &limit}
+	next := true
+	for next {
+		resp, err := client.GetTerms(
+			ctx,
+			termsParams,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		next = false
+		if !IsHTTPSuccess(resp.StatusCode) {
+			resp.Body.Close()
+			return &terms, fmt.Errorf(
+				"HTTP %d Error for Clever Request /terms starting after %q",
+				resp.StatusCode, startingAfter(termsParams.StartingAfter),
+			)
+		}
+
+		termsResp := &generated.TermsResponse{}
+		dec := json.NewDecoder(resp.Body)
+		dec.UseNumber()
+		err = dec.Decode(termsResp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if termsResp.Data != nil {
+			for _, d := range *termsResp.Data {
+				if d.Data != nil {
+					terms = append(terms, *d.Data)
+				}
+			}
+		}
+		if sa, ok := nextStartingAfter(termsResp.Links); ok {
+			next = true
+			termsParams.StartingAfter = &sa
+		}
+	}
+
+	return &terms, nil
+}
+
+// GetCleverCourses fetches every course the client can see, which GetRoster
+// leaves out
+func GetCleverCourses(
+	ctx context.Context,
+	client *generated.Client,
+	limit int,
+) (*[]generated.Course, error) {
+	var courses []generated.Course
+	coursesParams := &generated.GetCoursesParams{Limit: &limit}
+	next := true
+	for next {
+		resp, err := client.GetCourses(
+			ctx,
+			coursesParams,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		next = false
+		if !IsHTTPSuccess(resp.StatusCode) {
+			resp.Body.Close()
+			return &courses, fmt.Errorf(
+				"HTTP %d Error for Clever Request /courses starting after %q",
+				resp.StatusCode, startingAfter(coursesParams.StartingAfter),
+			)
+		}
+
+		coursesResp := &generated.CoursesResponse{}
+		dec := json.NewDecoder(resp.Body)
+		dec.UseNumber()
+		err = dec.Decode(coursesResp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if coursesResp.Data != nil {
+			for _, d := range *coursesResp.Data {
+				if d.Data != nil {
+					courses = append(courses, *d.Data)
+				}
+			}
+		}
+		if sa, ok := nextStartingAfter(coursesResp.Links); ok {
+			next = true
+			coursesParams.StartingAfter = &sa
+		}
+	}
+
+	return &courses, nil
+}
+
+// nextStartingAfter is the ID the next page starts after, if there is one
+func nextStartingAfter(links *[]generated.Link) (string, bool) {
+	if links == nil {
+		return "", false
+	}
+	for _, link := range *links {
+		if link.Rel != nil && *link.Rel == "next" && link.Uri != nil {
+			return ParseLinkStartingAfter(*link.Uri), true
+		}
+	}
+	return "", false
+}
+
+// startingAfter is the ID a page starts after, empty for the first page
+func startingAfter(id *string) string {
+	if id == nil {
+		return ""
+	}
+	return *id
+}
+
 func IsHTTPSuccess(code int) bool {
 	return code >= 200 && code <= 299
 }
@@ -0,0 +1,93 @@
+package rostering
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/generated"
+)
+
+// DefaultPageSize is how many records each Clever API request asks for
+const DefaultPageSize = 1000
+
+// MaxPageSize is the most records Clever returns for one request
+const MaxPageSize = 10000
+
+// GetRoster fetches everything the client can see, pageSize records at a
+// time. Cancelling ctx stops the fetch.
+func GetRoster(
+	ctx context.Context,
+	logger *zap.Logger,
+	clientClever *generated.Client,
+	pageSize int,
+) (*Roster, error) {
+	roster := Roster{}
+
+	districts, distErr := GetCleverDistricts(ctx, clientClever)
+	if distErr != nil {
+		return nil, distErr
+	}
+	roster.Districts = districts
+
+	schools, schoolErr := GetCleverSchools(ctx, clientClever, pageSize)
+	if schoolErr != nil {
+		return nil, schoolErr
+	}
+	roster.Schools = schools
+
+	students, studentErr := GetCleverStudents(ctx, clientClever, pageSize)
+	if studentErr != nil {
+		return nil, studentErr
+	}
+	roster.Students = students
+
+	teachers, teachErr := GetCleverTeachers(ctx, clientClever, pageSize)
+	if teachErr != nil {
+		return nil, teachErr
+	}
+	roster.Teachers = teachers
+
+	districtAdmins, distAdmErr := GetCleverDistrictAdmins(
+		ctx,
+		clientClever,
+		pageSize,
+	)
+	if distAdmErr != nil {
+		return nil, distAdmErr
+	}
+	roster.DistrictAdmins = districtAdmins
+
+	schoolAdmins, schoolAdminErr := GetCleverSchoolAdmins(
+		ctx,
+		clientClever,
+		pageSize,
+	)
+	if schoolAdminErr != nil {
+		return nil, schoolAdminErr
+	}
+	roster.SchoolAdmins = schoolAdmins
+
+	sections, sectionErr := GetCleverSections(ctx, clientClever, pageSize)
+	if sectionErr != nil {
+		return nil, sectionErr
+	}
+	roster.Sections = sections
+
+	return &roster, nil
+}
+
+// Roster is everything a Clever app can see in one district
+type Roster struct {
+	Districts      *[]generated.District
+	Schools        *[]generated.School
+	Students       *[]generated.Student
+	Teachers       *[]generated.Teacher
+	DistrictAdmins *[]generated.DistrictAdmin
+	SchoolAdmins   *[]generated.SchoolAdmin
+	Sections       *[]generated.Section
+	// Terms and Courses that sections refer to are left nil by GetRoster,
+	// since diffs don't need them
+	Terms   *[]generated.Term
+	Courses *[]generated.Course
+}
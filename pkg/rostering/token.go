@@ -1,14 +1,18 @@
 package rostering
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/Khan/clever-repartee/pkg/logging"
 	"github.com/Khan/clever-repartee/pkg/tripperware"
 
 	"go.uber.org/zap"
@@ -17,29 +21,50 @@ import (
 )
 
 func GetCleverToken(
+	ctx context.Context,
 	logger *zap.Logger,
 	districtID string,
 	isMAP bool,
+	opts ...tripperware.Option,
 ) (string, error) {
-	req, err := http.NewRequest(
+	tokens, err := GetCleverTokens(ctx, logger, districtID, isMAP, opts...)
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) != 0 {
+		return tokens[0].AccessToken, nil
+	}
+	return "", nil
+}
+
+// GetCleverTokens gets the app's token for the district, or with no district
+// its token for every district it is connected to
+func GetCleverTokens(
+	ctx context.Context,
+	logger *zap.Logger,
+	districtID string,
+	isMAP bool,
+	opts ...tripperware.Option,
+) ([]Data, error) {
+	query := url.Values{"owner_type": {"district"}}
+	if districtID != "" {
+		query.Set("district", districtID)
+	}
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"GET",
-		"https://clever.com/oauth/tokens?owner_type=district&district="+districtID,
+		"https://clever.com/oauth/tokens?"+query.Encode(),
 		nil,
 	)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var creds string
-
-	if isMAP {
-		creds = os.ExpandEnv("${MAP_CLEVER_ID}:${MAP_CLEVER_SECRET}")
-	} else {
-		creds = os.ExpandEnv("${CLEVER_ID}:${CLEVER_SECRET}")
-	}
+	creds := credentials(isMAP)
 
-	if strings.HasPrefix(creds, ":") || strings.HasSuffix(creds, ":") {
-		return "", errors.New(
+	// A replayed run gets its token from the cassette
+	if !CredentialsSet(isMAP) && !tripperware.Replaying(opts...) {
+		return nil, errors.New(
 			"all environment variables must be set including " +
 				"${MAP_CLEVER_ID} ${MAP_CLEVER_SECRET} ${CLEVER_ID} and ${CLEVER_SECRET}",
 		)
@@ -49,43 +74,85 @@ func GetCleverToken(
 		"Basic "+base64.StdEncoding.EncodeToString([]byte(creds)),
 	)
 
-	pesterClient := tripperware.NewLoggedRetryHTTPClient(logger)
+	httpClient := tripperware.NewLoggedRetryHTTPClient(
+		AppLogger(logger, isMAP),
+		opts...,
+	)
 
-	resp, err := pesterClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if !IsHTTPSuccess(resp.StatusCode) {
-		resp.Body.Close()
-		return "", fmt.Errorf(
-			"HTTP %d Error for Clever Request /oauth/tokens?owner_type=district&district=%s",
+		return nil, fmt.Errorf(
+			"HTTP %d Error for Clever Request %s",
 			resp.StatusCode,
-			districtID,
+			req.URL.RequestURI(),
 		)
 	}
 
-	if resp == nil {
-		return "", nil
-	}
-
 	tokenResp := &TokenResponse{}
-	if resp.Body == nil {
-		return "", nil
-	}
-
 	dec := json.NewDecoder(resp.Body)
 	dec.UseNumber()
 	err = dec.Decode(tokenResp)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return tokenResp.Data, nil
+}
 
-	defer resp.Body.Close()
-	if tokenResp.Data != nil && len(tokenResp.Data) != 0 {
-		return tokenResp.Data[0].AccessToken, nil
+var idPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// ValidID is true if id looks like a Clever ID, 24 lowercase hex digits
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// Apps, as labelled in logs and metrics
+const (
+	AppMAPAccelerator = "map_accelerator"
+	AppMAPGrowth      = "map_growth"
+)
+
+// App is the label of the app: MAP Growth if isMAP, else MAP Accelerator
+func App(isMAP bool) string {
+	if isMAP {
+		return AppMAPGrowth
 	}
-	return "", nil
+	return AppMAPAccelerator
+}
+
+// AppName is how an app is named for people, in reports and emails. Labels
+// that aren't apps are returned as they are.
+func AppName(app string) string {
+	switch app {
+	case AppMAPAccelerator:
+		return "MAP Accelerator"
+	case AppMAPGrowth:
+		return "MAP Growth"
+	}
+	return app
+}
+
+// AppLogger logs with the app
+func AppLogger(logger *zap.Logger, isMAP bool) *zap.Logger {
+	return logger.With(zap.String(logging.FieldApp, App(isMAP)))
+}
+
+// CredentialsSet is true if the environment has the Clever app's client ID
+// and secret
+func CredentialsSet(isMAP bool) bool {
+	creds := credentials(isMAP)
+	return !strings.HasPrefix(creds, ":") && !strings.HasSuffix(creds, ":")
+}
+
+func credentials(isMAP bool) string {
+	if isMAP {
+		return os.ExpandEnv("${MAP_CLEVER_ID}:${MAP_CLEVER_SECRET}")
+	}
+	return os.ExpandEnv("${CLEVER_ID}:${CLEVER_SECRET}")
 }
 
 type TokenResponse struct {
@@ -0,0 +1,194 @@
+// Package schedule runs diffs of districts on cron schedules read from a
+// YAML file
+package schedule
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultConcurrency is how many diffs run at once
+const DefaultConcurrency = 1
+
+// File is a schedule definition:
+//
+//	timezone: America/Los_Angeles
+//	jitter: 10m
+//	concurrency: 2
+//	schedules:
+//	  - name: nightly
+//	    cron: "0 2 * * *"
+//	    districts: [5a2f..., 5b31...]
+//	  - cron: "@every 6h"
+//	    districts: [5c47...]
+//	    groups: [pilot]
+//	    jitter: 0s
+type File struct {
+	// Timezone the cron expressions are in, local time if empty
+	Timezone string `yaml:"timezone"`
+	// Jitter is the most a run is delayed by, at random, so that districts
+	// on the same schedule don't all hit Clever at once
+	Jitter time.Duration `yaml:"jitter"`
+	// Concurrency is how many diffs run at once, the rest wait their turn
+	Concurrency int `yaml:"concurrency"`
+	// Schedules are the cron expressions and the districts they run
+	Schedules []Entry `yaml:"schedules"`
+}
+
+// Entry is one cron expression and the group of districts it runs
+type Entry struct {
+	// Name labels the entry in logs, the cron expression if empty
+	Name string `yaml:"name"`
+	// Cron is a standard five field expression or a descriptor such as
+	// @daily or @every 1h
+	Cron      string   `yaml:"cron"`
+	Districts []string `yaml:"districts"`
+	// Groups name lists of districts defined elsewhere, added to Districts
+	// when the file is loaded
+	Groups []string `yaml:"groups"`
+	// Jitter replaces the file's jitter for this entry if set
+	Jitter *time.Duration `yaml:"jitter"`
+}
+
+// Label is the entry's name, or its cron expression
+func (e Entry) Label() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.Cron
+}
+
+// Load reads and validates a schedule file, adding the districts of the
+// groups each entry names
+func Load(path string, groups map[string][]string) (*File, error) {
+	content, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+	f := &File{Concurrency: DefaultConcurrency}
+	if yamlErr := yaml.UnmarshalStrict(content, f); yamlErr != nil {
+		return nil, fmt.Errorf("unable to read schedule %s: %w", path, yamlErr)
+	}
+	var unknown []string
+	for i := range f.Schedules {
+		entry := &f.Schedules[i]
+		for _, group := range entry.Groups {
+			ids, ok := groups[group]
+			if !ok {
+				unknown = append(unknown, fmt.Sprintf(
+					"schedules[%d]: unknown group %q",
+					i,
+					group,
+				))
+			}
+			entry.Districts = append(entry.Districts, ids...)
+		}
+		// A district given twice would be run twice each time
+		entry.Districts = dedupe(entry.Districts)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf(
+			"invalid schedule %s: %s",
+			path,
+			strings.Join(unknown, "; "),
+		)
+	}
+	if validErr := f.Validate(); validErr != nil {
+		return nil, fmt.Errorf("invalid schedule %s: %w", path, validErr)
+	}
+	return f, nil
+}
+
+// Validate reports every problem with the schedule at once
+func (f *File) Validate() error {
+	var problems []string
+	if _, locErr := f.Location(); locErr != nil {
+		problems = append(problems, locErr.Error())
+	}
+	if f.Jitter < 0 {
+		problems = append(problems, "jitter can't be negative")
+	}
+	if f.Concurrency < 1 {
+		problems = append(problems, "concurrency must be at least 1")
+	}
+	if len(f.Schedules) == 0 {
+		problems = append(problems, "no schedules")
+	}
+	for i, entry := range f.Schedules {
+		prefix := fmt.Sprintf("schedules[%d]", i)
+		if entry.Name != "" {
+			prefix += " " + entry.Name
+		}
+		if _, cronErr := cron.ParseStandard(entry.Cron); cronErr != nil {
+			problems = append(
+				problems,
+				fmt.Sprintf("%s: cron %q: %s", prefix, entry.Cron, cronErr),
+			)
+		}
+		if len(entry.Districts) == 0 {
+			problems = append(problems, prefix+": no districts")
+		}
+		for _, district := range entry.Districts {
+			if district == "" {
+				problems = append(problems, prefix+": empty district")
+			}
+		}
+		if entry.Jitter != nil && *entry.Jitter < 0 {
+			problems = append(problems, prefix+": jitter can't be negative")
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Shared are the districts on more than one schedule, with the labels of
+// their schedules. Their runs are skipped whenever the schedules overlap.
+func (f *File) Shared() map[string][]string {
+	labels := map[string][]string{}
+	for _, entry := range f.Schedules {
+		for _, district := range dedupe(entry.Districts) {
+			labels[district] = append(labels[district], entry.Label())
+		}
+	}
+	for district, entries := range labels {
+		if len(entries) < 2 {
+			delete(labels, district)
+		}
+	}
+	return labels
+}
+
+func dedupe(districts []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, district := range districts {
+		if !seen[district] {
+			seen[district] = true
+			unique = append(unique, district)
+		}
+	}
+	return unique
+}
+
+// Location is the timezone of the cron expressions
+func (f *File) Location() (*time.Location, error) {
+	if f.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(f.Timezone)
+}
+
+// jitter is the most the entry's runs are delayed by
+func (f *File) jitter(entry Entry) time.Duration {
+	if entry.Jitter != nil {
+		return *entry.Jitter
+	}
+	return f.Jitter
+}
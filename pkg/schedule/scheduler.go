@@ -0,0 +1,219 @@
+package schedule
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/logging"
+)
+
+// Config is what the scheduler runs
+type Config struct {
+	// Diff runs the diff of one district, logging with the run's logger.
+	// ctx is cancelled if the diff is still running when Stop gives up.
+	Diff func(ctx context.Context, logger *zap.Logger, district string) error
+	// Paused is true if the district's syncing is paused now, in which case
+	// its run is skipped. A district that can't be checked is run anyway.
+	Paused func(
+		ctx context.Context,
+		logger *zap.Logger,
+		district string,
+	) (bool, error)
+}
+
+// Scheduler runs the diffs of a schedule file. A district is never diffed
+// twice at once: a run that comes due while the last one is still running
+// or waiting its turn is skipped.
+type Scheduler struct {
+	logger *zap.Logger
+	file   *File
+	config Config
+	cron   *cron.Cron
+	// slots holds a token for every diff running
+	slots chan struct{}
+	// stopping is closed by Stop, ending jitter delays and waits early
+	stopping chan struct{}
+	// ctx is the context of every run, cancelled when Stop gives up
+	ctx    context.Context
+	cancel context.CancelFunc
+	// after is time.After, replaced in tests
+	after func(d time.Duration) <-chan time.Time
+
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+// New schedules every district of the file, without starting
+func New(logger *zap.Logger, file *File, config Config) (*Scheduler, error) {
+	location, locErr := file.Location()
+	if locErr != nil {
+		return nil, locErr
+	}
+	cronLogger := zapCronLogger{logger}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		logger: logger,
+		file:   file,
+		config: config,
+		cron: cron.New(
+			cron.WithLocation(location),
+			cron.WithLogger(cronLogger),
+			cron.WithChain(cron.Recover(cronLogger)),
+		),
+		slots:    make(chan struct{}, file.Concurrency),
+		stopping: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+		after:    time.After,
+		active:   map[string]bool{},
+	}
+	for district, entries := range file.Shared() {
+		logger.Warn(
+			"District is on more than one schedule, overlapping runs "+
+				"will be skipped",
+			zap.String(logging.FieldDistrict, district),
+			zap.Strings("schedules", entries),
+		)
+	}
+	for _, entry := range file.Schedules {
+		for _, district := range dedupe(entry.Districts) {
+			entry, district := entry, district
+			_, addErr := s.cron.AddFunc(entry.Cron, func() {
+				s.trigger(entry, district)
+			})
+			if addErr != nil {
+				return nil, addErr
+			}
+		}
+	}
+	return s, nil
+}
+
+// Start runs the schedule in the background
+func (s *Scheduler) Start() {
+	s.cron.Start()
+	s.logger.Info("Started schedule", zap.Int("runs", len(s.cron.Entries())))
+}
+
+// Stop stops scheduling runs, skips the ones waiting for jitter or a free
+// slot, and waits for the running ones to finish. If ctx is done first, the
+// running ones are cancelled.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	close(s.stopping)
+	select {
+	case <-s.cron.Stop().Done():
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
+
+// trigger runs one district's diff when its schedule comes due
+func (s *Scheduler) trigger(entry Entry, district string) {
+	logger := s.logger.With(
+		zap.String(logging.FieldRunID, logging.NewRunID()),
+		zap.String("schedule", entry.Label()),
+		zap.String(logging.FieldDistrict, district),
+	)
+	if !s.claim(district) {
+		logger.Warn("Skipping run, the last one is still going")
+		return
+	}
+	defer s.release(district)
+
+	if jitter := s.file.jitter(entry); jitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(jitter)))
+		logger.Debug("Delaying run", zap.Duration("jitter", delay))
+		if !s.wait(s.after(delay)) {
+			logger.Info("Skipping run, stopping")
+			return
+		}
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-s.stopping:
+		logger.Info("Skipping run, stopping")
+		return
+	}
+	defer func() { <-s.slots }()
+
+	if s.config.Paused != nil {
+		paused, pausedErr := s.config.Paused(s.ctx, logger, district)
+		if pausedErr != nil {
+			logger.Error(
+				"Unable to check district pause, running anyway",
+				zap.Error(pausedErr),
+			)
+		} else if paused {
+			logger.Info("Skipping run, district sync is paused")
+			return
+		}
+	}
+
+	began := time.Now()
+	logger.Info("Running scheduled diff")
+	if diffErr := s.config.Diff(s.ctx, logger, district); diffErr != nil {
+		logger.Error(
+			"Scheduled diff failed",
+			zap.Duration("duration", time.Since(began)),
+			zap.Error(diffErr),
+		)
+		return
+	}
+	logger.Info(
+		"Scheduled diff succeeded",
+		zap.Duration("duration", time.Since(began)),
+	)
+}
+
+// wait is false if the scheduler stops first
+func (s *Scheduler) wait(done <-chan time.Time) bool {
+	select {
+	case <-done:
+		return true
+	case <-s.stopping:
+		return false
+	}
+}
+
+// claim marks the district as active, false if it already is
+func (s *Scheduler) claim(district string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[district] {
+		return false
+	}
+	s.active[district] = true
+	return true
+}
+
+func (s *Scheduler) release(district string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.active, district)
+}
+
+// zapCronLogger logs cron's own messages, which are about scheduling, at
+// debug level
+type zapCronLogger struct {
+	logger *zap.Logger
+}
+
+func (l zapCronLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Sugar().Debugw(msg, keysAndValues...)
+}
+
+func (l zapCronLogger) Error(
+	err error,
+	msg string,
+	keysAndValues ...interface{},
+) {
+	l.logger.Sugar().Errorw(msg, append(keysAndValues, "error", err)...)
+}
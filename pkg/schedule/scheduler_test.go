@@ -0,0 +1,419 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/Khan/clever-repartee/pkg/logging"
+)
+
+// wait is how long a test waits for something that should happen at once
+const wait = 5 * time.Second
+
+// fakeClock hands out timers that only fire when the test says so
+type fakeClock struct {
+	mu     sync.Mutex
+	delays []time.Duration
+	timers []chan time.Time
+	// started gets every delay as it is asked for
+	started chan time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{started: make(chan time.Duration, 100)}
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := make(chan time.Time, 1)
+	c.delays = append(c.delays, d)
+	c.timers = append(c.timers, timer)
+	c.started <- d
+	return timer
+}
+
+// fire ends every delay asked for so far
+func (c *fakeClock) fire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, timer := range c.timers {
+		timer <- time.Time{}
+	}
+	c.timers = nil
+}
+
+// fakeDiffs blocks every diff until the test releases it
+type fakeDiffs struct {
+	mu      sync.Mutex
+	ran     []string
+	running int
+	most    int
+	// started gets every district as its diff starts
+	started chan string
+	release chan struct{}
+}
+
+func newFakeDiffs() *fakeDiffs {
+	return &fakeDiffs{
+		started: make(chan string, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (d *fakeDiffs) diff(
+	ctx context.Context,
+	logger *zap.Logger,
+	district string,
+) error {
+	d.mu.Lock()
+	d.ran = append(d.ran, district)
+	d.running++
+	if d.running > d.most {
+		d.most = d.running
+	}
+	d.mu.Unlock()
+	d.started <- district
+	var err error
+	select {
+	case <-d.release:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	d.mu.Lock()
+	d.running--
+	d.mu.Unlock()
+	return err
+}
+
+func (d *fakeDiffs) count() (ran, most int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.ran), d.most
+}
+
+// testFile is one schedule of the districts
+func testFile(
+	concurrency int,
+	jitter time.Duration,
+	districts ...string,
+) *File {
+	return &File{
+		Jitter:      jitter,
+		Concurrency: concurrency,
+		Schedules: []Entry{{
+			Name:      "nightly",
+			Cron:      "0 2 * * *",
+			Districts: districts,
+		}},
+	}
+}
+
+func newTestScheduler(
+	t *testing.T,
+	file *File,
+	config Config,
+) (*Scheduler, *fakeClock, *observer.ObservedLogs) {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	s, err := New(zap.New(core), file, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	s.after = clock.after
+	return s, clock, logs
+}
+
+// triggerAll triggers the districts of the file's first schedule at once,
+// returning a channel closed once every trigger returned
+func triggerAll(s *Scheduler, districts ...string) <-chan struct{} {
+	var wg sync.WaitGroup
+	for _, district := range districts {
+		wg.Add(1)
+		go func(district string) {
+			defer wg.Done()
+			s.trigger(s.file.Schedules[0], district)
+		}(district)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func waitFor(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(wait):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func waitStarted(t *testing.T, diffs *fakeDiffs) string {
+	t.Helper()
+	select {
+	case district := <-diffs.started:
+		return district
+	case <-time.After(wait):
+		t.Fatal("timed out waiting for a diff to start")
+		return ""
+	}
+}
+
+func TestSchedulerSkipsOverlap(t *testing.T) {
+	diffs := newFakeDiffs()
+	s, _, logs := newTestScheduler(
+		t,
+		testFile(2, 0, "district-a"),
+		Config{Diff: diffs.diff},
+	)
+
+	first := triggerAll(s, "district-a")
+	waitStarted(t, diffs)
+	// The district is still running, so the second run is skipped at once
+	waitFor(t, triggerAll(s, "district-a"), "the overlapping run")
+	close(diffs.release)
+	waitFor(t, first, "the first run")
+
+	if ran, _ := diffs.count(); ran != 1 {
+		t.Errorf("%d diffs ran, want 1", ran)
+	}
+	skipped := logs.FilterMessage("Skipping run, the last one is still going")
+	if skipped.Len() != 1 {
+		t.Errorf("%d overlapping runs skipped, want 1", skipped.Len())
+	}
+
+	// Once the first run is done the district runs again
+	again := triggerAll(s, "district-a")
+	waitStarted(t, diffs)
+	waitFor(t, again, "the next run")
+	if ran, _ := diffs.count(); ran != 2 {
+		t.Errorf("%d diffs ran, want 2", ran)
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	zero, minute := time.Duration(0), time.Minute
+	tests := []struct {
+		name       string
+		fileJitter time.Duration
+		jitter     *time.Duration
+		wantJitter time.Duration
+	}{
+		{name: "file jitter", fileJitter: time.Hour, wantJitter: time.Hour},
+		{
+			name:       "schedule jitter replaces the file's",
+			fileJitter: time.Hour,
+			jitter:     &minute,
+			wantJitter: time.Minute,
+		},
+		{name: "no jitter", fileJitter: time.Hour, jitter: &zero},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := newFakeDiffs()
+			close(diffs.release)
+			file := testFile(1, tt.fileJitter, "district-a")
+			file.Schedules[0].Jitter = tt.jitter
+			s, clock, _ := newTestScheduler(t, file, Config{Diff: diffs.diff})
+
+			done := triggerAll(s, "district-a")
+			if tt.wantJitter == 0 {
+				waitFor(t, done, "the run")
+				if len(clock.delays) != 0 {
+					t.Errorf("delayed by %v, want no delay", clock.delays)
+				}
+				return
+			}
+
+			select {
+			case delay := <-clock.started:
+				if delay < 0 || delay >= tt.wantJitter {
+					t.Errorf("delay = %v, want under %v", delay, tt.wantJitter)
+				}
+			case <-time.After(wait):
+				t.Fatal("timed out waiting for the jitter delay")
+			}
+			if ran, _ := diffs.count(); ran != 0 {
+				t.Errorf("%d diffs ran before the delay, want 0", ran)
+			}
+			clock.fire()
+			waitFor(t, done, "the run")
+			if ran, _ := diffs.count(); ran != 1 {
+				t.Errorf("%d diffs ran, want 1", ran)
+			}
+		})
+	}
+}
+
+func TestSchedulerConcurrency(t *testing.T) {
+	diffs := newFakeDiffs()
+	s, _, _ := newTestScheduler(
+		t,
+		testFile(2, 0, "district-a", "district-b", "district-c"),
+		Config{Diff: diffs.diff},
+	)
+
+	done := triggerAll(s, "district-a", "district-b", "district-c")
+	waitStarted(t, diffs)
+	waitStarted(t, diffs)
+	select {
+	case district := <-diffs.started:
+		t.Fatalf("%s started with two diffs running", district)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Finishing one diff frees a slot for the third
+	diffs.release <- struct{}{}
+	waitStarted(t, diffs)
+	close(diffs.release)
+	waitFor(t, done, "every run")
+
+	if ran, most := diffs.count(); ran != 3 || most != 2 {
+		t.Errorf("%d diffs ran, %d at once, want 3, 2 at once", ran, most)
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter time.Duration
+	}{
+		{name: "waiting for jitter", jitter: time.Hour},
+		{name: "waiting for a slot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := newFakeDiffs()
+			s, clock, logs := newTestScheduler(
+				t,
+				testFile(1, tt.jitter, "district-a", "district-b"),
+				Config{Diff: diffs.diff},
+			)
+
+			var done <-chan struct{}
+			if tt.jitter > 0 {
+				done = triggerAll(s, "district-a")
+				<-clock.started
+			} else {
+				// One district takes the only slot, the other waits for it
+				running := triggerAll(s, "district-a")
+				waitStarted(t, diffs)
+				done = triggerAll(s, "district-b")
+				defer func() {
+					close(diffs.release)
+					waitFor(t, running, "the running diff")
+				}()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), wait)
+			defer cancel()
+			if err := s.Stop(ctx); err != nil {
+				t.Fatalf("Stop() error = %v", err)
+			}
+			waitFor(t, done, "the waiting run")
+			skipped := logs.FilterMessage("Skipping run, stopping")
+			if skipped.Len() != 1 {
+				t.Errorf("%d runs skipped, want 1", skipped.Len())
+			}
+			if ran, _ := diffs.count(); tt.jitter > 0 && ran != 0 {
+				t.Errorf("%d diffs ran, want 0", ran)
+			}
+		})
+	}
+}
+
+func TestSchedulerStopTimeout(t *testing.T) {
+	diffs := newFakeDiffs()
+	file := testFile(1, 0, "district-a")
+	file.Schedules[0].Cron = "@every 1s"
+	s, _, logs := newTestScheduler(t, file, Config{Diff: diffs.diff})
+	s.Start()
+	waitStarted(t, diffs)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		10*time.Millisecond,
+	)
+	defer cancel()
+	if err := s.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop() error = %v, want the deadline", err)
+	}
+	// The diff still running is cancelled
+	deadline := time.Now().Add(wait)
+	for logs.FilterMessage("Scheduled diff failed").Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the diff to be cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerPaused(t *testing.T) {
+	tests := []struct {
+		name    string
+		paused  bool
+		err     error
+		wantRan int
+	}{
+		{name: "not paused", wantRan: 1},
+		{name: "paused", paused: true, wantRan: 0},
+		{name: "check failed", err: errors.New("unauthorized"), wantRan: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs := newFakeDiffs()
+			close(diffs.release)
+			s, _, _ := newTestScheduler(t, testFile(1, 0, "district-a"), Config{
+				Diff: diffs.diff,
+				Paused: func(
+					ctx context.Context,
+					logger *zap.Logger,
+					district string,
+				) (bool, error) {
+					return tt.paused, tt.err
+				},
+			})
+			waitFor(t, triggerAll(s, "district-a"), "the run")
+			if ran, _ := diffs.count(); ran != tt.wantRan {
+				t.Errorf("%d diffs ran, want %d", ran, tt.wantRan)
+			}
+		})
+	}
+}
+
+func TestSchedulerRunIDs(t *testing.T) {
+	diffs := newFakeDiffs()
+	close(diffs.release)
+	s, _, logs := newTestScheduler(
+		t,
+		testFile(1, 0, "district-a"),
+		Config{Diff: diffs.diff},
+	)
+	for i := 0; i < 2; i++ {
+		waitFor(t, triggerAll(s, "district-a"), "the run")
+	}
+
+	runIDs := map[string]bool{}
+	for _, entry := range logs.FilterMessage("Running scheduled diff").All() {
+		runID, _ := entry.ContextMap()[logging.FieldRunID].(string)
+		if runID == "" {
+			t.Errorf("no run ID on %+v", entry.ContextMap())
+		}
+		runIDs[runID] = true
+	}
+	if len(runIDs) != 2 {
+		t.Errorf("run IDs %v, want 2 different ones", runIDs)
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Khan/clever-repartee/pkg/logging"
+	"github.com/Khan/clever-repartee/pkg/report"
+)
+
+// Job states
+const (
+	// JobQueued is a job waiting for a free run slot
+	JobQueued = "queued"
+	// JobRunning is a job whose diff is running
+	JobRunning = "running"
+	// JobSucceeded is a job whose report is ready
+	JobSucceeded = "succeeded"
+	// JobFailed is a job whose diff returned an error
+	JobFailed = "failed"
+	// JobCanceled is a job that was still queued when the server shut down
+	JobCanceled = "canceled"
+)
+
+// Job is one diff the server was asked to run
+type Job struct {
+	ID         string
+	District   string
+	State      string
+	CreatedAt  time.Time
+	StartedAt  *time.Time `json:",omitempty"`
+	FinishedAt *time.Time `json:",omitempty"`
+	Error      string     `json:",omitempty"`
+
+	report *report.MissingReport
+}
+
+func (j *Job) done() bool {
+	switch j.State {
+	case JobSucceeded, JobFailed, JobCanceled:
+		return true
+	}
+	return false
+}
+
+// jobs remembers the jobs the server has run, up to max finished ones, the
+// oldest forgotten first
+type jobs struct {
+	mu   sync.Mutex
+	byID map[string]*Job
+	max  int
+}
+
+func newJobs(max int) *jobs {
+	return &jobs{byID: map[string]*Job{}, max: max}
+}
+
+// add queues a job for the district, or returns the district's unfinished
+// job and false if it already has one
+func (s *jobs) add(district string, now time.Time) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.byID {
+		if job.District == district && !job.done() {
+			return *job, false
+		}
+	}
+	job := &Job{
+		ID:        logging.NewRunID(),
+		District:  district,
+		State:     JobQueued,
+		CreatedAt: now,
+	}
+	s.byID[job.ID] = job
+	s.forget()
+	return *job, true
+}
+
+// start moves a queued job to running. It is false if the job was canceled
+// while it waited.
+func (s *jobs) start(id string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.byID[id]
+	if job == nil || job.State != JobQueued {
+		return false
+	}
+	job.State = JobRunning
+	job.StartedAt = &now
+	return true
+}
+
+func (s *jobs) finish(
+	id string,
+	now time.Time,
+	missingReport *report.MissingReport,
+	err error,
+) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.byID[id]
+	if job == nil {
+		return
+	}
+	job.FinishedAt = &now
+	if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.State = JobSucceeded
+		job.report = missingReport
+	}
+	s.forget()
+}
+
+// cancelQueued cancels every job still waiting to run
+func (s *jobs) cancelQueued(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.byID {
+		if job.State == JobQueued {
+			job.State = JobCanceled
+			finished := now
+			job.FinishedAt = &finished
+		}
+	}
+}
+
+func (s *jobs) get(id string) (Job, *report.MissingReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.byID[id]
+	if job == nil {
+		return Job{}, nil, false
+	}
+	return *job, job.report, true
+}
+
+// list is every job, the newest first
+func (s *jobs) list() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Job, 0, len(s.byID))
+	for _, job := range s.byID {
+		list = append(list, *job)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// forget drops the oldest finished jobs beyond the max. Unfinished jobs are
+// always kept.
+func (s *jobs) forget() {
+	var finished []*Job
+	for _, job := range s.byID {
+		if job.done() {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= s.max {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].CreatedAt.Before(finished[j].CreatedAt)
+	})
+	for _, job := range finished[:len(finished)-s.max] {
+		delete(s.byID, job.ID)
+	}
+}
@@ -0,0 +1,351 @@
+// Package server serves an HTTP API for running diffs on demand and reading
+// their results, with health and readiness endpoints for Kubernetes
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/districts"
+	"github.com/Khan/clever-repartee/pkg/logging"
+	"github.com/Khan/clever-repartee/pkg/report"
+	"github.com/Khan/clever-repartee/pkg/rostering"
+)
+
+// DefaultMaxJobs is how many finished jobs are remembered
+const DefaultMaxJobs = 100
+
+// DefaultConcurrency is how many diffs run at once
+const DefaultConcurrency = 2
+
+// Config is what the server runs and how much of it
+type Config struct {
+	// Diff runs the diff of one district, logging with the job's logger.
+	// ctx is cancelled if the diff is still running when Drain gives up.
+	Diff func(
+		ctx context.Context,
+		logger *zap.Logger,
+		district string,
+	) (*report.MissingReport, error)
+	// Districts lists the districts the apps are connected to, until ctx,
+	// the request's, is done
+	Districts func(ctx context.Context) ([]districts.Status, error)
+	// Concurrency is how many diffs run at once, the rest wait their turn
+	Concurrency int
+	// MaxJobs is how many finished jobs are remembered
+	MaxJobs int
+	// Token is the bearer token every request but the probes must carry.
+	// With no token, every such request is refused.
+	Token string
+}
+
+// Server runs diffs in the background and reports on them. It remembers
+// jobs in memory only, so a restart forgets them.
+type Server struct {
+	logger *zap.Logger
+	config Config
+	jobs   *jobs
+	// slots holds a token for every diff running
+	slots   chan struct{}
+	running sync.WaitGroup
+	// ctx is the context of every diff, cancelled when Drain gives up
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	draining bool
+}
+
+func New(logger *zap.Logger, config Config) *Server {
+	if config.Concurrency < 1 {
+		config.Concurrency = DefaultConcurrency
+	}
+	if config.MaxJobs < 1 {
+		config.MaxJobs = DefaultMaxJobs
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		logger: logger,
+		config: config,
+		jobs:   newJobs(config.MaxJobs),
+		slots:  make(chan struct{}, config.Concurrency),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Handler routes the following. Every route but the probes needs an
+// Authorization: Bearer header with the token, or gets 401 Unauthorized.
+//
+//	GET  /healthz                 200 while the process is up
+//	GET  /readyz                  200 until the server starts draining
+//	POST /diffs                   queue a diff of {"district": ID}
+//	GET  /diffs                   every job, the newest first
+//	GET  /diffs/{id}              one job
+//	GET  /diffs/{id}/report       the report of a job that succeeded
+//	GET  /districts               the districts the apps are connected to
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/diffs", s.authorized(s.diffs))
+	mux.HandleFunc("/diffs/", s.authorized(s.diff))
+	mux.HandleFunc("/districts", s.authorized(s.districts))
+	return mux
+}
+
+// authorized refuses requests without the bearer token, since diffs send
+// email and reports have student details
+func (s *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + s.config.Token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if s.config.Token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.writeError(
+				w,
+				http.StatusUnauthorized,
+				fmt.Errorf("a valid bearer token is required"),
+			)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Drain stops new diffs, cancels the queued ones and waits for the running
+// ones to finish. If ctx is done first, the running ones are cancelled too.
+func (s *Server) Drain(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+	s.jobs.cancelQueued(time.Now())
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return fmt.Errorf("diffs still running: %w", ctx.Err())
+	}
+}
+
+func (s *Server) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.isDraining() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) diffs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSON(w, http.StatusOK, s.jobs.list())
+	case http.MethodPost:
+		s.queue(w, r)
+	default:
+		s.methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// queue starts a job for the district, which is read from a JSON body or
+// the district query parameter. A district that already has a job queued
+// or running gets that job back with 409 Conflict.
+func (s *Server) queue(w http.ResponseWriter, r *http.Request) {
+	district := r.URL.Query().Get("district")
+	if district == "" {
+		var request struct {
+			District string `json:"district"`
+		}
+		body, readErr := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 4096))
+		if readErr != nil {
+			s.writeError(w, http.StatusBadRequest, readErr)
+			return
+		}
+		if len(body) > 0 {
+			if jsonErr := json.Unmarshal(body, &request); jsonErr != nil {
+				s.writeError(w, http.StatusBadRequest, jsonErr)
+				return
+			}
+		}
+		district = request.District
+	}
+	if district == "" {
+		s.writeError(
+			w,
+			http.StatusBadRequest,
+			fmt.Errorf("district is required"),
+		)
+		return
+	}
+	if !rostering.ValidID(district) {
+		s.writeError(
+			w,
+			http.StatusBadRequest,
+			fmt.Errorf("district must be a Clever ID, 24 hex digits"),
+		)
+		return
+	}
+
+	// Counted as running under the lock so that Drain waits for it
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		s.writeError(
+			w,
+			http.StatusServiceUnavailable,
+			fmt.Errorf("the server is shutting down"),
+		)
+		return
+	}
+	s.running.Add(1)
+	s.mu.Unlock()
+
+	job, added := s.jobs.add(district, time.Now())
+	w.Header().Set("Location", "/diffs/"+job.ID)
+	if !added {
+		s.running.Done()
+		s.writeJSON(w, http.StatusConflict, job)
+		return
+	}
+	s.logger.Info(
+		"Queued diff",
+		zap.String(logging.FieldRunID, job.ID),
+		zap.String(logging.FieldDistrict, district),
+	)
+	go s.run(job)
+	s.writeJSON(w, http.StatusAccepted, job)
+}
+
+// run waits for a free slot and runs the job's diff
+func (s *Server) run(job Job) {
+	defer s.running.Done()
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+	if !s.jobs.start(job.ID, time.Now()) {
+		return
+	}
+
+	// The job ID is the run ID of its diff
+	logger := s.logger.With(
+		zap.String(logging.FieldRunID, job.ID),
+		zap.String(logging.FieldDistrict, job.District),
+	)
+	logger.Info("Running diff")
+	missingReport, err := s.config.Diff(s.ctx, logger, job.District)
+	s.jobs.finish(job.ID, time.Now(), missingReport, err)
+	if err != nil {
+		logger.Error("Diff failed", zap.Error(err))
+		return
+	}
+	logger.Info("Diff succeeded")
+}
+
+// diff serves /diffs/{id} and /diffs/{id}/report
+func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/diffs/")
+	id := strings.TrimSuffix(path, "/report")
+	wantReport := id != path
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	job, missingReport, ok := s.jobs.get(id)
+	if !ok {
+		s.writeError(
+			w,
+			http.StatusNotFound,
+			fmt.Errorf("no job %s, it may have been forgotten", id),
+		)
+		return
+	}
+	if !wantReport {
+		s.writeJSON(w, http.StatusOK, job)
+		return
+	}
+	if job.State != JobSucceeded {
+		s.writeError(
+			w,
+			http.StatusConflict,
+			fmt.Errorf("job %s is %s and has no report", id, job.State),
+		)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, missingReport)
+}
+
+func (s *Server) districts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	statuses, err := s.config.Districts(r.Context())
+	if err != nil {
+		s.logger.Error("Unable to list districts", zap.Error(err))
+		s.writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if statuses == nil {
+		statuses = []districts.Status{}
+	}
+	s.writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *Server) methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	s.writeError(
+		w,
+		http.StatusMethodNotAllowed,
+		fmt.Errorf("method not allowed"),
+	)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	s.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) writeJSON(
+	w http.ResponseWriter,
+	status int,
+	value interface{},
+) {
+	content, err := json.MarshalIndent(value, "", " ")
+	if err != nil {
+		s.logger.Error("Unable to encode response", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s\n", content)
+}
@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/districts"
+	"github.com/Khan/clever-repartee/pkg/report"
+)
+
+const (
+	testToken    = "secret"
+	testDistrict = "5f1e2d3c4b5a69788796a5b4"
+)
+
+// wait is how long a test waits for something that should happen at once
+const wait = 5 * time.Second
+
+// fakeDiff runs a diff that blocks until the test finishes it
+type fakeDiff struct {
+	started chan string
+	results chan error
+}
+
+func newFakeDiff() *fakeDiff {
+	return &fakeDiff{
+		started: make(chan string, 10),
+		results: make(chan error, 10),
+	}
+}
+
+func (d *fakeDiff) diff(
+	ctx context.Context,
+	logger *zap.Logger,
+	district string,
+) (*report.MissingReport, error) {
+	d.started <- district
+	select {
+	case err := <-d.results:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &report.MissingReport{DistrictCleverID: district}, nil
+}
+
+func (d *fakeDiff) waitStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-d.started:
+	case <-time.After(wait):
+		t.Fatal("timed out waiting for a diff to start")
+	}
+}
+
+func newTestServer(diff *fakeDiff, concurrency int) *Server {
+	return New(zap.NewNop(), Config{
+		Diff: diff.diff,
+		Districts: func(ctx context.Context) ([]districts.Status, error) {
+			status := districts.Status{App: "map_growth", ID: testDistrict}
+			return []districts.Status{status}, nil
+		},
+		Concurrency: concurrency,
+		Token:       testToken,
+	})
+}
+
+// do sends a request with the token, returning the status and the body
+// decoded into out, if given
+func do(
+	t *testing.T,
+	handler http.Handler,
+	method, target, body string,
+	out interface{},
+) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid JSON: %v", method, target, err)
+		}
+	}
+	return rec.Code
+}
+
+// queue asks for a diff of the district
+func queue(
+	t *testing.T,
+	handler http.Handler,
+	district string,
+	out interface{},
+) int {
+	t.Helper()
+	target := "/diffs?district=" + district
+	return do(t, handler, http.MethodPost, target, "", out)
+}
+
+// waitState polls the job until it is in the state
+func waitState(t *testing.T, handler http.Handler, id, state string) Job {
+	t.Helper()
+	deadline := time.Now().Add(wait)
+	for {
+		var job Job
+		do(t, handler, http.MethodGet, "/diffs/"+id, "", &job)
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.State, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		path   string
+		want   int
+	}{
+		{
+			name:   "token",
+			token:  testToken,
+			header: "Bearer secret",
+			path:   "/diffs",
+			want:   http.StatusOK,
+		},
+		{
+			name:  "no header",
+			token: testToken,
+			path:  "/diffs",
+			want:  http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong token",
+			token:  testToken,
+			header: "Bearer guess",
+			path:   "/diffs",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "not bearer",
+			token:  testToken,
+			header: "secret",
+			path:   "/districts",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "no token configured",
+			header: "Bearer ",
+			path:   "/diffs",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:  "job without a header",
+			token: testToken,
+			path:  "/diffs/0123",
+			want:  http.StatusUnauthorized,
+		},
+		{
+			name:  "liveness probe",
+			token: testToken,
+			path:  "/healthz",
+			want:  http.StatusOK,
+		},
+		{
+			name:  "readiness probe",
+			token: testToken,
+			path:  "/readyz",
+			want:  http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(zap.NewNop(), Config{Token: tt.token})
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if rec.Code == http.StatusUnauthorized &&
+				rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Error("no WWW-Authenticate: Bearer header")
+			}
+		})
+	}
+}
+
+func TestQueueValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{
+			name:   "no district",
+			method: http.MethodPost,
+			target: "/diffs",
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "invalid JSON",
+			method: http.MethodPost,
+			target: "/diffs",
+			body:   "{",
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "not a Clever ID",
+			method: http.MethodPost,
+			target: "/diffs",
+			body:   `{"district": "../etc"}`,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "short ID in the query",
+			method: http.MethodPost,
+			target: "/diffs?district=5f1e2d",
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "wrong method",
+			method: http.MethodDelete,
+			target: "/diffs",
+			want:   http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "unknown job",
+			method: http.MethodGet,
+			target: "/diffs/0123456789abcdef",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "job path too deep",
+			method: http.MethodGet,
+			target: "/diffs/0123/report/x",
+			want:   http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := newFakeDiff()
+			srv := newTestServer(diff, 1)
+			got := do(t, srv.Handler(), tt.method, tt.target, tt.body, nil)
+			if got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+			select {
+			case <-diff.started:
+				t.Error("a diff was run")
+			default:
+			}
+		})
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantState  string
+		wantReport int
+	}{
+		{name: "succeeded", wantState: JobSucceeded, wantReport: http.StatusOK},
+		{
+			name:       "failed",
+			err:        errors.New("circuit open"),
+			wantState:  JobFailed,
+			wantReport: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := newFakeDiff()
+			handler := newTestServer(diff, 1).Handler()
+
+			var job Job
+			body := `{"district": "` + testDistrict + `"}`
+			status := do(t, handler, http.MethodPost, "/diffs", body, &job)
+			if status != http.StatusAccepted || job.ID == "" ||
+				job.District != testDistrict {
+				t.Fatalf("POST = %d %+v, want 202 and a job", status, job)
+			}
+			diff.waitStarted(t)
+			waitState(t, handler, job.ID, JobRunning)
+			reportPath := "/diffs/" + job.ID + "/report"
+
+			// A district with a job running gets that job back
+			var again Job
+			status = queue(t, handler, testDistrict, &again)
+			if status != http.StatusConflict || again.ID != job.ID {
+				t.Errorf(
+					"second POST = %d %+v, want 409 and job %s",
+					status,
+					again,
+					job.ID,
+				)
+			}
+			status = do(t, handler, http.MethodGet, reportPath, "", nil)
+			if status != http.StatusConflict {
+				t.Errorf("report of a running job = %d, want 409", status)
+			}
+
+			diff.results <- tt.err
+			finished := waitState(t, handler, job.ID, tt.wantState)
+			if finished.StartedAt == nil || finished.FinishedAt == nil {
+				t.Errorf("job %+v, want start and finish times", finished)
+			}
+			if tt.err != nil && finished.Error != tt.err.Error() {
+				t.Errorf("error = %q, want %q", finished.Error, tt.err)
+			}
+
+			var got report.MissingReport
+			var out interface{}
+			if tt.wantReport == http.StatusOK {
+				out = &got
+			}
+			status = do(t, handler, http.MethodGet, reportPath, "", out)
+			if status != tt.wantReport {
+				t.Errorf("report = %d, want %d", status, tt.wantReport)
+			}
+			if out != nil && got.DistrictCleverID != testDistrict {
+				t.Errorf(
+					"report of %s, want %s",
+					got.DistrictCleverID,
+					testDistrict,
+				)
+			}
+
+			var list []Job
+			do(t, handler, http.MethodGet, "/diffs", "", &list)
+			if len(list) != 1 || list[0].ID != job.ID {
+				t.Errorf("GET /diffs = %+v, want job %s", list, job.ID)
+			}
+		})
+	}
+}
+
+func TestDistricts(t *testing.T) {
+	handler := newTestServer(newFakeDiff(), 1).Handler()
+	var statuses []districts.Status
+	status := do(t, handler, http.MethodGet, "/districts", "", &statuses)
+	if status != http.StatusOK || len(statuses) != 1 ||
+		statuses[0].ID != testDistrict {
+		t.Errorf("GET /districts = %d %+v", status, statuses)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	diff := newFakeDiff()
+	srv := newTestServer(diff, 1)
+	handler := srv.Handler()
+	get := func(path string) int {
+		return do(t, handler, http.MethodGet, path, "", nil)
+	}
+
+	if status := get("/readyz"); status != http.StatusOK {
+		t.Fatalf("/readyz = %d before draining, want 200", status)
+	}
+
+	// One job takes the only slot, the other waits for it
+	var running, queued Job
+	queue(t, handler, testDistrict, &running)
+	diff.waitStarted(t)
+	queue(t, handler, "5f1e2d3c4b5a69788796a5b5", &queued)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- srv.Drain(context.Background())
+	}()
+	waitState(t, handler, queued.ID, JobCanceled)
+
+	if status := get("/readyz"); status != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d while draining, want 503", status)
+	}
+	if status := get("/healthz"); status != http.StatusOK {
+		t.Errorf("/healthz = %d while draining, want 200", status)
+	}
+	status := queue(t, handler, "5f1e2d3c4b5a69788796a5b6", nil)
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("POST /diffs = %d while draining, want 503", status)
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain() = %v with a diff still running", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	diff.results <- nil
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("Drain() error = %v", err)
+		}
+	case <-time.After(wait):
+		t.Fatal("timed out waiting for Drain")
+	}
+	waitState(t, handler, running.ID, JobSucceeded)
+	select {
+	case <-diff.started:
+		t.Error("the canceled job was run")
+	default:
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	diff := newFakeDiff()
+	srv := newTestServer(diff, 1)
+	handler := srv.Handler()
+	var running Job
+	queue(t, handler, testDistrict, &running)
+	diff.waitStarted(t)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		10*time.Millisecond,
+	)
+	defer cancel()
+	if err := srv.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain() error = %v, want the deadline", err)
+	}
+	// The diff still running is cancelled
+	job := waitState(t, handler, running.ID, JobFailed)
+	if job.Error != context.Canceled.Error() {
+		t.Errorf("job error = %q, want %q", job.Error, context.Canceled)
+	}
+}
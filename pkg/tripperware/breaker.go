@@ -0,0 +1,288 @@
+package tripperware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BreakerScope is what a circuit breaker counts failures for
+type BreakerScope string
+
+const (
+	// BreakerScopeHost has one circuit per host, e.g. api.clever.com
+	BreakerScopeHost BreakerScope = "host"
+	// BreakerScopeEndpoint has one circuit per host and endpoint template
+	BreakerScopeEndpoint BreakerScope = "endpoint"
+)
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// BreakerPolicy decides when a circuit opens and how it recovers
+type BreakerPolicy struct {
+	Scope BreakerScope
+	// Window is how many of the most recent requests the failure rate is
+	// measured over
+	Window int
+	// MinRequests is how many requests the window needs before the circuit
+	// can open
+	MinRequests int
+	// FailureRate is the share of failed requests, from 0 to 1, that opens
+	// the circuit. Server errors and requests with no response are failures.
+	FailureRate float64
+	// OpenFor is how long an open circuit fails requests before letting a
+	// probe through
+	OpenFor time.Duration
+	// Probes is how many probes in a row have to succeed to close the
+	// circuit again
+	Probes int
+}
+
+// DefaultBreakerPolicy opens a host's circuit when half of its last 20
+// requests failed, and probes it again after 30 seconds
+func DefaultBreakerPolicy() BreakerPolicy {
+	return BreakerPolicy{
+		Scope:       BreakerScopeHost,
+		Window:      20,
+		MinRequests: 10,
+		FailureRate: 0.5,
+		OpenFor:     30 * time.Second,
+		Probes:      1,
+	}
+}
+
+// Validate reports every problem with the policy
+func (p BreakerPolicy) Validate() []error {
+	var problems []error
+	switch p.Scope {
+	case BreakerScopeHost, BreakerScopeEndpoint:
+	default:
+		problems = append(problems, fmt.Errorf(
+			"invalid circuit breaker scope %q, must be host or endpoint",
+			p.Scope,
+		))
+	}
+	if p.Window < 1 || p.MinRequests < 1 || p.MinRequests > p.Window {
+		problems = append(problems, fmt.Errorf(
+			"circuit breaker needs 1 <= min requests <= window",
+		))
+	}
+	if p.FailureRate <= 0 || p.FailureRate > 1 {
+		problems = append(problems, fmt.Errorf(
+			"circuit breaker failure rate must be above 0 and at most 1",
+		))
+	}
+	if p.OpenFor <= 0 || p.Probes < 1 {
+		problems = append(problems, fmt.Errorf(
+			"circuit breaker needs a positive open time and probe count",
+		))
+	}
+	return problems
+}
+
+// CircuitOpenError is returned instead of sending a request while its
+// circuit is open. The RetryRoundTripper returns it at once rather than
+// retrying, so that a run fails fast during an incident.
+type CircuitOpenError struct {
+	Circuit string
+	Until   time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf(
+		"circuit breaker for %s is open until %s",
+		e.Circuit,
+		e.Until.Format(time.RFC3339),
+	)
+}
+
+// BreakerState describes one circuit, for logs and reports
+type BreakerState struct {
+	Circuit  string
+	State    string
+	Requests int
+	Failures int
+	// Trips is how many times the circuit opened
+	Trips    int
+	LastTrip time.Time `json:",omitempty"`
+}
+
+// Breaker is a circuit breaker shared by every client it is passed to, so
+// that a dead API fails fast instead of being retried page after page
+type Breaker struct {
+	policy BreakerPolicy
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	BreakerState
+	// outcomes of the most recent requests, true for a failure
+	outcomes []bool
+	openedAt time.Time
+	probing  bool
+	probes   int
+}
+
+func NewBreaker(policy BreakerPolicy, logger *zap.Logger) *Breaker {
+	return &Breaker{
+		policy:   policy,
+		logger:   logger,
+		circuits: map[string]*circuit{},
+	}
+}
+
+// States describes every circuit, sorted by name
+func (b *Breaker) States() []BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var states []BreakerState
+	for _, c := range b.circuits {
+		states = append(states, c.BreakerState)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Circuit < states[j].Circuit
+	})
+	return states
+}
+
+// RoundTripper sends requests through next unless their circuit is open
+func (b *Breaker) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		name := req.URL.Host
+		if b.policy.Scope == BreakerScopeEndpoint {
+			name += EndpointTemplate(req.URL.Path)
+		}
+		if err := b.allow(name); err != nil {
+			return nil, err
+		}
+		resp, err := next.RoundTrip(req)
+		if cancelled(err) {
+			b.release(name)
+		} else {
+			b.record(name, err != nil || resp.StatusCode >= 500)
+		}
+		return resp, err
+	})
+}
+
+// cancelled is true for a request the client gave up on, which says nothing
+// about whether the server is healthy
+func cancelled(err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+func (b *Breaker) allow(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[name]
+	if !ok {
+		c = &circuit{BreakerState: BreakerState{
+			Circuit: name,
+			State:   CircuitClosed,
+		}}
+		b.circuits[name] = c
+	}
+	switch c.State {
+	case CircuitOpen:
+		until := c.openedAt.Add(b.policy.OpenFor)
+		if time.Now().Before(until) {
+			return &CircuitOpenError{Circuit: name, Until: until}
+		}
+		c.State = CircuitHalfOpen
+		c.probes = 0
+		b.logger.Info(
+			"Circuit breaker half-open, probing",
+			zap.String("circuit", name),
+		)
+		fallthrough
+	case CircuitHalfOpen:
+		// One probe at a time
+		if c.probing {
+			return &CircuitOpenError{Circuit: name, Until: time.Now()}
+		}
+		c.probing = true
+	}
+	return nil
+}
+
+// release forgets a request that was cancelled. A cancelled probe frees
+// the probe slot for the next request, leaving the circuit half-open.
+func (b *Breaker) release(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.circuits[name].probing = false
+}
+
+func (b *Breaker) record(name string, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[name]
+	c.Requests++
+	if failed {
+		c.Failures++
+	}
+
+	if c.State == CircuitHalfOpen {
+		c.probing = false
+		if failed {
+			b.trip(c)
+			return
+		}
+		c.probes++
+		if c.probes >= b.policy.Probes {
+			c.State = CircuitClosed
+			c.outcomes = nil
+			b.logger.Info(
+				"Circuit breaker closed",
+				zap.String("circuit", name),
+			)
+		}
+		return
+	}
+
+	c.outcomes = append(c.outcomes, failed)
+	if len(c.outcomes) > b.policy.Window {
+		c.outcomes = c.outcomes[1:]
+	}
+	if c.State == CircuitClosed && len(c.outcomes) >= b.policy.MinRequests {
+		failures := 0
+		for _, outcome := range c.outcomes {
+			if outcome {
+				failures++
+			}
+		}
+		rate := float64(failures) / float64(len(c.outcomes))
+		if rate >= b.policy.FailureRate {
+			b.trip(c)
+		}
+	}
+}
+
+func (b *Breaker) trip(c *circuit) {
+	c.State = CircuitOpen
+	c.openedAt = time.Now()
+	c.Trips++
+	c.LastTrip = c.openedAt
+	c.outcomes = nil
+	b.logger.Warn(
+		"Circuit breaker opened",
+		zap.String("circuit", c.Circuit),
+		zap.Duration("open_for", b.policy.OpenFor),
+		zap.Int("trips", c.Trips),
+	)
+}
@@ -0,0 +1,374 @@
+package tripperware
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestBreakerPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *BreakerPolicy)
+		want   int
+	}{
+		{name: "default", modify: func(p *BreakerPolicy) {}, want: 0},
+		{
+			name:   "unknown scope",
+			modify: func(p *BreakerPolicy) { p.Scope = "path" },
+			want:   1,
+		},
+		{
+			name:   "min requests above window",
+			modify: func(p *BreakerPolicy) { p.MinRequests = p.Window + 1 },
+			want:   1,
+		},
+		{
+			name:   "no failure rate",
+			modify: func(p *BreakerPolicy) { p.FailureRate = 0 },
+			want:   1,
+		},
+		{
+			name:   "failure rate above 1",
+			modify: func(p *BreakerPolicy) { p.FailureRate = 1.5 },
+			want:   1,
+		},
+		{
+			name:   "no probes",
+			modify: func(p *BreakerPolicy) { p.Probes = 0 },
+			want:   1,
+		},
+		{
+			name: "every problem at once",
+			modify: func(p *BreakerPolicy) {
+				p.Scope = ""
+				p.Window = 0
+				p.FailureRate = -1
+				p.OpenFor = 0
+			},
+			want: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := DefaultBreakerPolicy()
+			tt.modify(&policy)
+			if got := policy.Validate(); len(got) != tt.want {
+				t.Errorf("Validate() = %v, want %d problems", got, tt.want)
+			}
+		})
+	}
+}
+
+// step is one request through a breaker
+type step struct {
+	// wait for the open circuit to let a probe through first
+	wait bool
+	// fail is true if the server answers with an error
+	fail bool
+	// cancel is true if the request is cancelled before it is answered
+	cancel bool
+	// refused is true if the breaker should fail the request without
+	// sending it
+	refused bool
+	// state is the circuit's state after the request
+	state string
+}
+
+func TestBreakerStates(t *testing.T) {
+	const openFor = 20 * time.Millisecond
+	policy := BreakerPolicy{
+		Scope:       BreakerScopeHost,
+		Window:      4,
+		MinRequests: 2,
+		FailureRate: 0.5,
+		OpenFor:     openFor,
+		Probes:      1,
+	}
+	ok := step{state: CircuitClosed}
+	failed := step{fail: true, state: CircuitClosed}
+	tripped := step{fail: true, state: CircuitOpen}
+	refused := step{refused: true, state: CircuitOpen}
+	cancelled := step{cancel: true, state: CircuitClosed}
+	tests := []struct {
+		name      string
+		modify    func(p *BreakerPolicy)
+		steps     []step
+		wantTrips int
+	}{
+		{
+			name:  "stays closed below min requests",
+			steps: []step{failed},
+		},
+		{
+			name:  "stays closed below the failure rate",
+			steps: []step{ok, ok, failed, ok},
+		},
+		{
+			name:      "opens at the failure rate",
+			steps:     []step{ok, tripped, refused},
+			wantTrips: 1,
+		},
+		{
+			// Over every request the rate is 3 in 4, but over the window of
+			// the last 2 it is every request
+			name: "only the window counts",
+			modify: func(p *BreakerPolicy) {
+				p.Window = 2
+				p.FailureRate = 1
+			},
+			steps:     []step{failed, ok, failed, tripped},
+			wantTrips: 1,
+		},
+		{
+			// Counted as a success, the cancelled request would make the
+			// first failure a rate of 1 in 2
+			name:      "cancelled requests aren't counted",
+			steps:     []step{cancelled, failed, tripped},
+			wantTrips: 1,
+		},
+		{
+			name: "cancelled probe leaves the circuit half-open",
+			steps: []step{
+				failed,
+				tripped,
+				{wait: true, cancel: true, state: CircuitHalfOpen},
+				{state: CircuitClosed},
+			},
+			wantTrips: 1,
+		},
+		{
+			name: "probe success closes",
+			steps: []step{
+				failed,
+				tripped,
+				{wait: true, state: CircuitClosed},
+				ok,
+			},
+			wantTrips: 1,
+		},
+		{
+			name: "probe failure opens again",
+			steps: []step{
+				failed,
+				tripped,
+				{wait: true, fail: true, state: CircuitOpen},
+				refused,
+			},
+			wantTrips: 2,
+		},
+		{
+			name:   "closes after enough probes",
+			modify: func(p *BreakerPolicy) { p.Probes = 2 },
+			steps: []step{
+				failed,
+				tripped,
+				{wait: true, state: CircuitHalfOpen},
+				{state: CircuitClosed},
+			},
+			wantTrips: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := policy
+			if tt.modify != nil {
+				tt.modify(&p)
+			}
+			breaker := NewBreaker(p, zap.NewNop())
+			fail, cancel := false, false
+			sent := 0
+			client := &http.Client{Transport: breaker.RoundTripper(
+				roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					sent++
+					if cancel {
+						return nil, context.Canceled
+					}
+					status := http.StatusOK
+					if fail {
+						status = http.StatusServiceUnavailable
+					}
+					return &http.Response{
+						StatusCode: status,
+						Body:       ioutil.NopCloser(strings.NewReader("")),
+						Request:    req,
+					}, nil
+				}),
+			)}
+
+			for i, s := range tt.steps {
+				if s.wait {
+					time.Sleep(openFor)
+				}
+				fail, cancel = s.fail, s.cancel
+				before := sent
+				resp, err := client.Get("https://api.clever.com/v2.1/students")
+				if resp != nil {
+					resp.Body.Close()
+				}
+				var circuitErr *CircuitOpenError
+				gotRefused := errors.As(err, &circuitErr)
+				if gotRefused != s.refused || gotRefused == (sent > before) {
+					t.Fatalf(
+						"step %d: refused = %v, sent = %v, want refused %v",
+						i,
+						gotRefused,
+						sent > before,
+						s.refused,
+					)
+				}
+				states := breaker.States()
+				if len(states) != 1 || states[0].State != s.state {
+					t.Fatalf("step %d: states = %+v, want %s", i, states, s.state)
+				}
+			}
+			if got := breaker.States()[0].Trips; got != tt.wantTrips {
+				t.Errorf("trips = %d, want %d", got, tt.wantTrips)
+			}
+		})
+	}
+}
+
+func TestBreakerScope(t *testing.T) {
+	tests := []struct {
+		scope BreakerScope
+		want  []string
+	}{
+		{scope: BreakerScopeHost, want: []string{"api.clever.com"}},
+		{
+			scope: BreakerScopeEndpoint,
+			want: []string{
+				"api.clever.com/v2.1/sections/{id}",
+				"api.clever.com/v2.1/students",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.scope), func(t *testing.T) {
+			policy := DefaultBreakerPolicy()
+			policy.Scope = tt.scope
+			breaker := NewBreaker(policy, zap.NewNop())
+			client := &http.Client{
+				Transport: breaker.RoundTripper(fakeResponse(200, "")),
+			}
+			for _, url := range []string{
+				"https://api.clever.com/v2.1/students",
+				"https://api.clever.com/v2.1/sections/5f1e2d3c4b5a69788796f001",
+			} {
+				resp, err := client.Get(url)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+			var got []string
+			for _, state := range breaker.States() {
+				got = append(got, state.Circuit)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("circuits = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerIgnoresCancellation(t *testing.T) {
+	policy := DefaultBreakerPolicy()
+	policy.MinRequests = 1
+	breaker := NewBreaker(policy, zap.NewNop())
+	rt := breaker.RoundTripper(roundTripperFunc(
+		func(req *http.Request) (*http.Response, error) {
+			return nil, context.Canceled
+		},
+	))
+	req, _ := http.NewRequest("GET", "https://api.clever.com/v2.1/students", nil)
+	if _, err := rt.RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	if state := breaker.States()[0]; state.State != CircuitClosed ||
+		state.Failures != 0 {
+		t.Errorf("state = %+v, want closed with no failures", state)
+	}
+}
+
+// TestRetryFailsFastOnOpenCircuit returns a request's CircuitOpenError at
+// once, without sleeping until the circuit would let a probe through
+func TestRetryFailsFastOnOpenCircuit(t *testing.T) {
+	breakerPolicy := BreakerPolicy{
+		Scope:       BreakerScopeHost,
+		Window:      2,
+		MinRequests: 2,
+		FailureRate: 1,
+		OpenFor:     time.Hour,
+		Probes:      1,
+	}
+	tests := []struct {
+		name string
+		// tripFirst opens the circuit before the request is made
+		tripFirst bool
+		baseDelay time.Duration
+		wantSent  int
+	}{
+		{
+			name:      "made while the circuit is open",
+			tripFirst: true,
+			baseDelay: time.Hour,
+			wantSent:  2,
+		},
+		{
+			name:      "retried until the circuit opens",
+			baseDelay: time.Millisecond,
+			wantSent:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := NewBreaker(breakerPolicy, zap.NewNop())
+			sent := 0
+			server := breaker.RoundTripper(
+				fakeAttempts([]attempt{{status: 503}}, &sent),
+			)
+			url := "https://api.clever.com/v2.1/students"
+			if tt.tripFirst {
+				for i := 0; i < breakerPolicy.MinRequests; i++ {
+					req, _ := http.NewRequest("GET", url, nil)
+					if _, err := server.RoundTrip(req); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			rt := NewRetryRoundTripper(
+				server,
+				RetryPolicy{
+					MaxAttempts:   5,
+					RetryStatuses: []int{http.StatusServiceUnavailable},
+					BaseDelay:     tt.baseDelay,
+					MaxDelay:      tt.baseDelay,
+					Jitter:        JitterNone,
+				},
+				zap.NewNop(),
+				nil,
+			)
+
+			begin := time.Now()
+			req, _ := http.NewRequest("GET", url, nil)
+			_, err := rt.RoundTrip(req)
+			var circuitErr *CircuitOpenError
+			if !errors.As(err, &circuitErr) {
+				t.Fatalf("error = %v, want a CircuitOpenError", err)
+			}
+			if elapsed := time.Since(begin); elapsed > time.Second {
+				t.Errorf("returned after %v, want at once", elapsed)
+			}
+			if sent != tt.wantSent {
+				t.Errorf("sent %d requests, want %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
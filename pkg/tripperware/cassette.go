@@ -0,0 +1,262 @@
+package tripperware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+// Headers and query parameters that are never written to a cassette
+var (
+	secretHeaders = []string{
+		"Authorization",
+		"Proxy-Authorization",
+		"Cookie",
+		"Set-Cookie",
+	}
+	secretParams = []string{"access_token", "client_secret", "token"}
+	secretFields = regexp.MustCompile(
+		`("(?:access_token|client_secret)"\s*:\s*")[^"]*(")`,
+	)
+)
+
+// Interaction is one recorded request and its response, or the error
+// returned instead of a response
+type Interaction struct {
+	Request  RecordedRequest
+	Response *RecordedResponse `json:",omitempty"`
+	Error    string            `json:",omitempty"`
+}
+
+type RecordedRequest struct {
+	Method string
+	URL    string
+	Header http.Header `json:",omitempty"`
+	Body   string      `json:",omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int
+	Header     http.Header `json:",omitempty"`
+	Body       string      `json:",omitempty"`
+}
+
+// Cassette records HTTP interactions to a file, or replays them from one
+// with no network, so a run against Clever can be captured once and
+// reproduced later. Secrets are scrubbed before anything is recorded.
+//
+// Requests are replayed by method and URL, in the order they were recorded,
+// so that the same URL fetched with two apps' tokens gets each app's
+// response back.
+type Cassette struct {
+	path      string
+	replaying bool
+
+	mu           sync.Mutex
+	Interactions []Interaction
+	// next is the index of the next interaction to replay for each request
+	next map[string]int
+}
+
+// NewRecordingCassette records to path once Save is called
+func NewRecordingCassette(path string) *Cassette {
+	return &Cassette{path: path}
+}
+
+// LoadCassette reads a recorded cassette to replay
+func LoadCassette(path string) (*Cassette, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{path: path, replaying: true, next: map[string]int{}}
+	if err = json.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("unable to read cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Replaying is true if the cassette replays rather than records
+func (c *Cassette) Replaying() bool {
+	return c.replaying
+}
+
+// Save writes the recorded interactions to the cassette's file. It does
+// nothing when replaying.
+func (c *Cassette) Save() error {
+	if c.replaying {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	content, err := json.MarshalIndent(c, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, content, 0600)
+}
+
+// RoundTripper records the requests sent through next, or when replaying
+// answers them from the cassette without calling next at all
+func (c *Cassette) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if c.replaying {
+			return c.replay(req)
+		}
+		return c.record(next, req)
+	})
+}
+
+func (c *Cassette) record(
+	next http.RoundTripper,
+	req *http.Request,
+) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    scrubURL(req.URL),
+			Header: scrubHeader(req.Header),
+			Body:   scrubBody(reqBody),
+		},
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		interaction.Error = err.Error()
+		c.add(interaction)
+		return resp, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	interaction.Response = &RecordedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     scrubHeader(resp.Header),
+		Body:       scrubBody(respBody),
+	}
+	c.add(interaction)
+	return resp, nil
+}
+
+func (c *Cassette) add(interaction Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, interaction)
+}
+
+func (c *Cassette) replay(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	u := scrubURL(req.URL)
+	key := req.Method + " " + u
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := c.next[key]; i < len(c.Interactions); i++ {
+		interaction := c.Interactions[i]
+		if interaction.Request.Method != req.Method ||
+			interaction.Request.URL != u {
+			continue
+		}
+		c.next[key] = i + 1
+		if interaction.Response == nil {
+			return nil, errors.New(interaction.Error)
+		}
+		recorded := interaction.Response
+		return &http.Response{
+			Status: fmt.Sprintf(
+				"%d %s",
+				recorded.StatusCode,
+				http.StatusText(recorded.StatusCode),
+			),
+			StatusCode: recorded.StatusCode,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     recorded.Header.Clone(),
+			Body: ioutil.NopCloser(
+				bytes.NewReader([]byte(recorded.Body)),
+			),
+			ContentLength: int64(len(recorded.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, &ReplayError{Cassette: c.path, Request: key}
+}
+
+// ReplayError is returned for a request the cassette has no more recorded
+// responses for. Retrying it can't help.
+type ReplayError struct {
+	Cassette string
+	Request  string
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf(
+		"cassette %s has no more recorded responses for %s",
+		e.Cassette,
+		e.Request,
+	)
+}
+
+func scrubHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	scrubbed := header.Clone()
+	for _, name := range secretHeaders {
+		if scrubbed.Get(name) != "" {
+			scrubbed.Set(name, redacted)
+		}
+	}
+	return scrubbed
+}
+
+func scrubURL(u *url.URL) string {
+	scrubbed := *u
+	query := scrubbed.Query()
+	changed := false
+	for _, name := range secretParams {
+		if query.Get(name) != "" {
+			query.Set(name, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		scrubbed.RawQuery = query.Encode()
+	}
+	scrubbed.User = nil
+	return scrubbed.String()
+}
+
+func scrubBody(body []byte) string {
+	return string(secretFields.ReplaceAll(body, []byte("${1}"+redacted+"${2}")))
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
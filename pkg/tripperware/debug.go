@@ -0,0 +1,328 @@
+package tripperware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultDebugBodyBytes is how much of each body is logged by default
+const DefaultDebugBodyBytes = 2048
+
+// DebugOptions configure the DebugRoundTripper
+type DebugOptions struct {
+	// Log logs each exchange. Without it only the HAR file is written.
+	Log bool
+	// MaxBodyBytes is how much of each body is logged. Bodies in the HAR
+	// file are never truncated.
+	MaxBodyBytes int
+	// RedactFields are JSON keys whose values are replaced in every body,
+	// e.g. "email" or "dob"
+	RedactFields []string
+	// HAR collects every request and response, if set
+	HAR *HAR
+}
+
+// DebugRoundTripper logs every request and response in full, headers and
+// bodies, with credentials and PII redacted, for sharing with Clever support
+type DebugRoundTripper struct {
+	next    http.RoundTripper
+	logger  *zap.Logger
+	options DebugOptions
+	redact  *regexp.Regexp
+}
+
+func NewDebugRoundTripper(
+	next http.RoundTripper,
+	logger *zap.Logger,
+	options DebugOptions,
+) *DebugRoundTripper {
+	rt := &DebugRoundTripper{next: next, logger: logger, options: options}
+	if len(options.RedactFields) > 0 {
+		keys := make([]string, len(options.RedactFields))
+		for i, field := range options.RedactFields {
+			keys[i] = regexp.QuoteMeta(field)
+		}
+		// A JSON key followed by a string, number, boolean or null value
+		rt.redact = regexp.MustCompile(
+			`("(?:` + strings.Join(keys, "|") + `)"\s*:\s*)` +
+				`(?:"(?:[^"\\]|\\.)*"|-?[0-9][0-9.eE+-]*|true|false|null)`,
+		)
+	}
+	return rt
+}
+
+func (rt *DebugRoundTripper) RoundTrip(
+	req *http.Request,
+) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	begin := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	took := time.Since(begin)
+
+	var respBody []byte
+	if resp != nil && resp.Body != nil {
+		var readErr error
+		respBody, readErr = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	}
+
+	redactedReqBody := rt.redactBody(reqBody)
+	redactedRespBody := rt.redactBody(respBody)
+	fields := []zap.Field{
+		zap.String("method", req.Method),
+		zap.String("url", scrubURL(req.URL)),
+		zap.Any("request_header", scrubHeader(req.Header)),
+		zap.String("request_body", rt.truncate(redactedReqBody)),
+		zap.Duration("took", took),
+	}
+	if resp != nil {
+		fields = append(
+			fields,
+			zap.Int("status_code", resp.StatusCode),
+			zap.Any("response_header", scrubHeader(resp.Header)),
+			zap.String("response_body", rt.truncate(redactedRespBody)),
+		)
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	if rt.options.Log {
+		rt.logger.Info("HTTP exchange", fields...)
+	}
+
+	if rt.options.HAR != nil {
+		rt.options.HAR.add(
+			req,
+			redactedReqBody,
+			resp,
+			redactedRespBody,
+			begin,
+			took,
+		)
+	}
+	return resp, err
+}
+
+// Credentials that turn up in a body, e.g. an echoed Authorization header
+var credentials = regexp.MustCompile(
+	`(?i)\b(Bearer|Basic)\s+[A-Za-z0-9._~+/-]+=*`,
+)
+
+func (rt *DebugRoundTripper) redactBody(body []byte) string {
+	scrubbed := credentials.ReplaceAllString(scrubBody(body), "$1 "+redacted)
+	if rt.redact == nil {
+		return scrubbed
+	}
+	return rt.redact.ReplaceAllString(scrubbed, `${1}"`+redacted+`"`)
+}
+
+func (rt *DebugRoundTripper) truncate(body string) string {
+	limit := rt.options.MaxBodyBytes
+	if limit <= 0 || len(body) <= limit {
+		return body
+	}
+	return body[:limit] + "...(truncated)"
+}
+
+// HAR collects HTTP exchanges in the HTTP Archive 1.2 format, which browser
+// developer tools and most support teams can open
+type HAR struct {
+	creator harCreator
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+// NewHAR starts an empty archive created by the named tool
+func NewHAR(name, version string) *HAR {
+	return &HAR{creator: harCreator{Name: name, Version: version}}
+}
+
+// Save writes the archive to path
+func (h *HAR) Save(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := h.entries
+	if entries == nil {
+		entries = []harEntry{}
+	}
+	content, err := json.MarshalIndent(map[string]harLog{
+		"log": {Version: "1.2", Creator: h.creator, Entries: entries},
+	}, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0600)
+}
+
+func (h *HAR) add(
+	req *http.Request,
+	reqBody string,
+	resp *http.Response,
+	respBody string,
+	begin time.Time,
+	took time.Duration,
+) {
+	millis := float64(took) / float64(time.Millisecond)
+	entry := harEntry{
+		StartedDateTime: begin.Format(time.RFC3339Nano),
+		Time:            millis,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         scrubURL(req.URL),
+			HTTPVersion: "HTTP/1.1",
+			Headers:     harHeaders(scrubHeader(req.Header)),
+			QueryString: harQuery(req),
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(reqBody),
+		},
+		Response: harResponse{
+			HTTPVersion: "HTTP/1.1",
+			Headers:     []harNameValue{},
+			Cookies:     []harNameValue{},
+			Content:     harContent{Size: len(respBody), Text: respBody},
+			HeadersSize: -1,
+			BodySize:    len(respBody),
+		},
+		Cache:   struct{}{},
+		Timings: harTimings{Send: 0, Wait: millis, Receive: 0},
+	}
+	if reqBody != "" {
+		entry.Request.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     reqBody,
+		}
+	}
+	if resp != nil {
+		entry.Response.Status = resp.StatusCode
+		entry.Response.StatusText = http.StatusText(resp.StatusCode)
+		entry.Response.Headers = harHeaders(scrubHeader(resp.Header))
+		entry.Response.Content.MimeType = resp.Header.Get("Content-Type")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entry)
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	pairs := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			pairs = append(pairs, harNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Name < pairs[j].Name
+	})
+	return pairs
+}
+
+func harQuery(req *http.Request) []harNameValue {
+	pairs := []harNameValue{}
+	query := req.URL.Query()
+	for _, name := range secretParams {
+		if query.Get(name) != "" {
+			query.Set(name, redacted)
+		}
+	}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, harNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Name < pairs[j].Name
+	})
+	return pairs
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
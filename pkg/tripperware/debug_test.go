@@ -0,0 +1,222 @@
+package tripperware
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDebugRedactBody(t *testing.T) {
+	rt := NewDebugRoundTripper(nil, zap.NewNop(), DebugOptions{
+		RedactFields: []string{"email", "dob", "student_number"},
+	})
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "fields",
+			body: `{"name":"Grace","email": "g@example.org","dob":"1/2/2008"}`,
+			want: `{"name":"Grace","email": "REDACTED","dob":"REDACTED"}`,
+		},
+		{
+			name: "escaped quotes, numbers and null",
+			body: `{"email":"a\"b@example.org","student_number":1234,` +
+				`"dob":null}`,
+			want: `{"email":"REDACTED","student_number":"REDACTED",` +
+				`"dob":"REDACTED"}`,
+		},
+		{
+			name: "tokens and credentials",
+			body: `{"access_token":"abc","echo":"Bearer abc.def"}`,
+			want: `{"access_token":"REDACTED","echo":"Bearer REDACTED"}`,
+		},
+		{
+			name: "a key only named like a field",
+			body: `{"emails":"g@example.org"}`,
+			want: `{"emails":"g@example.org"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rt.redactBody([]byte(tt.body)); got != tt.want {
+				t.Errorf("redactBody() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugTruncate(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  string
+	}{
+		{limit: 0, want: "0123456789"},
+		{limit: 10, want: "0123456789"},
+		{limit: 4, want: "0123...(truncated)"},
+	}
+	for _, tt := range tests {
+		rt := NewDebugRoundTripper(nil, zap.NewNop(), DebugOptions{
+			MaxBodyBytes: tt.limit,
+		})
+		if got := rt.truncate("0123456789"); got != tt.want {
+			t.Errorf("truncate() at %d = %q, want %q", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestDebugRoundTripper(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	har := NewHAR("clever-repartee", "test")
+	body := `{"data":{"id":"st1","email":"g@example.org"}}`
+	rt := NewDebugRoundTripper(
+		fakeResponse(200, body),
+		zap.New(core),
+		DebugOptions{
+			Log:          true,
+			MaxBodyBytes: 20,
+			RedactFields: []string{"email"},
+			HAR:          har,
+		},
+	)
+	req, _ := http.NewRequest(
+		"POST",
+		"https://api.clever.com/v2.1/students?token=abc&limit=10",
+		strings.NewReader(`{"email":"g@example.org"}`),
+	)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The caller still reads the whole, unredacted body
+	got, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(got) != body {
+		t.Errorf("response body = %s, want %s", got, body)
+	}
+
+	entries := logs.FilterMessage("HTTP exchange").All()
+	if len(entries) != 1 {
+		t.Fatalf("%d exchanges logged, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["response_body"] != `{"data":{"id":"st1",...(truncated)` {
+		t.Errorf("logged response body %q", fields["response_body"])
+	}
+	if fields["status_code"] != int64(200) {
+		t.Errorf("logged status %v, want 200", fields["status_code"])
+	}
+
+	dir, dirErr := ioutil.TempDir("", "har")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "debug.har")
+	if saveErr := har.Save(path); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+	saved, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	for _, secret := range []string{"g@example.org", "secret", "abc"} {
+		if strings.Contains(string(saved), secret) {
+			t.Errorf("HAR contains %q:\n%s", secret, saved)
+		}
+	}
+
+	var archive struct {
+		Log harLog `json:"log"`
+	}
+	if jsonErr := json.Unmarshal(saved, &archive); jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if archive.Log.Version != "1.2" || len(archive.Log.Entries) != 1 {
+		t.Fatalf("HAR log = %+v, want 1.2 with one entry", archive.Log)
+	}
+	entry := archive.Log.Entries[0]
+	if entry.Request.PostData == nil ||
+		entry.Request.PostData.Text != `{"email":"REDACTED"}` ||
+		entry.Request.PostData.MimeType != "application/json" {
+		t.Errorf("HAR request body = %+v", entry.Request.PostData)
+	}
+	wantQuery := []harNameValue{
+		{Name: "limit", Value: "10"},
+		{Name: "token", Value: "REDACTED"},
+	}
+	if len(entry.Request.QueryString) != len(wantQuery) {
+		t.Fatalf(
+			"HAR query = %+v, want %+v",
+			entry.Request.QueryString,
+			wantQuery,
+		)
+	}
+	for i := range wantQuery {
+		if entry.Request.QueryString[i] != wantQuery[i] {
+			t.Errorf(
+				"HAR query %d = %+v, want %+v",
+				i,
+				entry.Request.QueryString[i],
+				wantQuery[i],
+			)
+		}
+	}
+	// Bodies in the HAR file aren't truncated
+	wantBody := `{"data":{"id":"st1","email":"REDACTED"}}`
+	if entry.Response.Status != 200 || entry.Response.StatusText != "OK" ||
+		entry.Response.Content.Text != wantBody {
+		t.Errorf("HAR response = %+v", entry.Response)
+	}
+}
+
+func TestDebugRoundTripperError(t *testing.T) {
+	har := NewHAR("clever-repartee", "test")
+	failed := errors.New("connection reset")
+	rt := NewDebugRoundTripper(
+		roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, failed
+		}),
+		zap.NewNop(),
+		DebugOptions{HAR: har},
+	)
+	req, _ := http.NewRequest(
+		"GET",
+		"https://api.clever.com/v2.1/students",
+		nil,
+	)
+	if _, err := rt.RoundTrip(req); !errors.Is(err, failed) {
+		t.Errorf("RoundTrip() error = %v, want %v", err, failed)
+	}
+	if len(har.entries) != 1 || har.entries[0].Response.Status != 0 {
+		t.Errorf("HAR entries = %+v, want one without a response", har.entries)
+	}
+}
+
+func TestHARSaveEmpty(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "har")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "empty.har")
+	if err := NewHAR("clever-repartee", "test").Save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(saved), `"entries": []`) {
+		t.Errorf("empty HAR = %s, want an empty list of entries", saved)
+	}
+}
@@ -1,11 +1,11 @@
 package tripperware
 
 import (
-	"fmt"
 	"net/http"
 	"time"
 
-	"github.com/sethgrid/pester"
+	"github.com/Khan/clever-repartee/pkg/metrics"
+
 	"go.uber.org/zap"
 )
 
@@ -28,54 +28,120 @@ func (rt *LoggingRoundTripper) RoundTrip(
 	req *http.Request,
 ) (resp *http.Response, err error) {
 	defer func(begin time.Time) {
-		var msg string
+		// Fields rather than a formatted message, so that JSON logs can be
+		// queried by them
+		fields := []zap.Field{
+			zap.Bool("performance", true),
+			zap.String("method", req.Method),
+			zap.String("host", req.URL.Host),
+			zap.String("path", req.URL.Path),
+			zap.Duration("took", time.Since(begin)),
+		}
 		if resp != nil {
-			msg = fmt.Sprintf(
-				"method=%s host=%s path=%s status_code=%d took=%s\n",
-				req.Method,
-				req.URL.Host,
-				req.URL.Path,
-				resp.StatusCode,
-				time.Since(begin),
-			)
-		} else {
-			msg = fmt.Sprintf(
-				"method=%s host=%s path=%s status_code=nil took=%s\n",
-				req.Method, req.URL.Host, req.URL.Path, time.Since(begin),
-			)
+			fields = append(fields, zap.Int("status_code", resp.StatusCode))
 		}
 
 		if err != nil {
 			rt.logger.Error(
-				msg,
-				zap.Bool("performance", true),
-				zap.Error(err),
+				"Clever API request failed",
+				append(fields, zap.Error(err))...,
 			)
 		} else {
-			rt.logger.Debug(msg, zap.Bool("performance", true))
+			rt.logger.Debug("Clever API request", fields...)
 		}
 	}(time.Now())
 
 	return rt.next.RoundTrip(req)
 }
 
-func NewLoggedRetryHTTPClient(logger *zap.Logger) *pester.Client {
-	pesterClient := pester.New()
-	pesterClient.Backoff = pester.ExponentialJitterBackoff
-	pesterClient.MaxRetries = 8
-	pesterClient.KeepLog = false // Cannot both retain logs and have loghook
-	pesterClient.LogHook = func(e pester.ErrEntry) {
-		// e.Err nil when Retry on HTTP 429
-		message := fmt.Sprintf("%d %s [%s] %s request-%d retry-%d",
-			e.Time.Unix(), e.Method, e.Verb, e.URL, e.Request, e.Retry)
-		if e.Err != nil {
-			logger.Error(message, zap.Error(e.Err))
-		} else {
-			logger.Error(message)
-		}
+// Option configures the client built by NewLoggedRetryHTTPClient
+type Option func(*options)
+
+type options struct {
+	metrics     *metrics.Metrics
+	cassette    *Cassette
+	retryPolicy *RetryPolicy
+	breaker     *Breaker
+	debug       *DebugOptions
+}
+
+// WithMetrics records every request attempt and retry in m
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithCassette records every request to c, or replays them from it
+func WithCassette(c *Cassette) Option {
+	return func(o *options) {
+		o.cassette = c
+	}
+}
+
+// WithRetryPolicy replaces the DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = &policy
+	}
+}
+
+// WithBreaker fails requests fast while their circuit in b is open. Share
+// one Breaker between clients that call the same API.
+func WithBreaker(b *Breaker) Option {
+	return func(o *options) {
+		o.breaker = b
+	}
+}
+
+// WithDebug logs every request and response in full
+func WithDebug(debug DebugOptions) Option {
+	return func(o *options) {
+		o.debug = &debug
+	}
+}
+
+// Replaying is true if the options replay requests from a cassette, so no
+// real credentials are needed
+func Replaying(opts ...Option) bool {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.cassette != nil && o.cassette.Replaying()
+}
+
+// NewLoggedRetryHTTPClient builds the client every Clever request goes
+// through. From the outside in, it retries, breaks the circuit, logs,
+// dumps for debugging, records metrics, and records or replays a cassette. The circuit breaker
+// sits inside the retries so that each attempt counts.
+func NewLoggedRetryHTTPClient(
+	logger *zap.Logger,
+	opts ...Option,
+) *http.Client {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	policy := DefaultRetryPolicy()
+	if o.retryPolicy != nil {
+		policy = *o.retryPolicy
+	}
+
+	var transport = http.DefaultTransport
+	if o.cassette != nil {
+		transport = o.cassette.RoundTripper(transport)
+	}
+	if o.metrics != nil {
+		transport = NewMetricsRoundTripper(transport, o.metrics)
+	}
+	if o.debug != nil {
+		transport = NewDebugRoundTripper(transport, logger, *o.debug)
+	}
+	transport = NewLoggingRoundTripper(transport, logger)
+	if o.breaker != nil {
+		transport = o.breaker.RoundTripper(transport)
 	}
-	pesterClient.KeepLog = false
-	rt := NewLoggingRoundTripper(http.DefaultTransport, logger)
-	pesterClient.Transport = rt
-	return pesterClient
+	transport = NewRetryRoundTripper(transport, policy, logger, o.metrics)
+	return &http.Client{Transport: transport}
 }
@@ -0,0 +1,88 @@
+package tripperware
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Khan/clever-repartee/pkg/metrics"
+)
+
+// MetricsRoundTripper records the count, latency and size of every request
+// attempt, labelled by endpoint template so that IDs don't explode the
+// number of series
+type MetricsRoundTripper struct {
+	next    http.RoundTripper
+	metrics *metrics.Metrics
+}
+
+func NewMetricsRoundTripper(
+	next http.RoundTripper,
+	m *metrics.Metrics,
+) *MetricsRoundTripper {
+	return &MetricsRoundTripper{
+		next:    next,
+		metrics: m,
+	}
+}
+
+func (rt *MetricsRoundTripper) RoundTrip(
+	req *http.Request,
+) (*http.Response, error) {
+	endpoint := EndpointTemplate(req.URL.Path)
+	begin := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	var requestBytes int64
+	if req.ContentLength > 0 {
+		requestBytes = req.ContentLength
+	}
+	rt.metrics.ObserveRequest(
+		endpoint,
+		req.Method,
+		status,
+		time.Since(begin),
+		requestBytes,
+	)
+	if resp != nil && resp.Body != nil {
+		resp.Body = &countingBody{
+			ReadCloser: resp.Body,
+			count: func(n int) {
+				rt.metrics.ObserveResponseBytes(endpoint, n)
+			},
+		}
+	}
+	return resp, err
+}
+
+// countingBody reports the bytes read from a response body as they are read
+type countingBody struct {
+	io.ReadCloser
+	count func(n int)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count(n)
+	return n, err
+}
+
+// Clever IDs are 24 hex digit MongoDB ObjectIDs
+var cleverID = regexp.MustCompile(`^[0-9a-fA-F]{24}$`)
+
+// EndpointTemplate replaces the IDs in a request path with {id}, e.g.
+// /v2.1/districts/{id}/students
+func EndpointTemplate(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if cleverID.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,353 @@
+package tripperware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Khan/clever-repartee/pkg/metrics"
+)
+
+// Jitter spreads out retries so that clients that failed together don't
+// retry together
+type Jitter string
+
+const (
+	// JitterNone waits exactly the backoff delay
+	JitterNone Jitter = "none"
+	// JitterFull waits a random time between zero and the backoff delay
+	JitterFull Jitter = "full"
+	// JitterEqual waits half the backoff delay plus a random time up to the
+	// other half
+	JitterEqual Jitter = "equal"
+)
+
+// RetryPolicy decides which failed requests are retried, and how long to
+// wait before each retry
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, including the first
+	MaxAttempts int
+	// MaxElapsed is the longest to keep retrying a request, measured from
+	// the first attempt. Zero means no limit.
+	MaxElapsed time.Duration
+	// RetryStatuses are the response status codes that are retried
+	RetryStatuses []int
+	// RetryError decides whether a request that failed without a response
+	// is retried. Context cancellation is never retried.
+	RetryError func(err error) bool
+	// RetryNonIdempotent also retries POST and PATCH requests, which may
+	// repeat their side effects
+	RetryNonIdempotent bool
+	// BaseDelay is the wait before the first retry, doubling for each retry
+	// after it up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Jitter    Jitter
+}
+
+// DefaultRetryPolicy retries rate limits, server errors and network errors
+// for up to 8 attempts or 5 minutes
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 8,
+		MaxElapsed:  5 * time.Minute,
+		RetryStatuses: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		RetryError: IsRetryableError,
+		BaseDelay:  time.Second,
+		MaxDelay:   time.Minute,
+		Jitter:     JitterEqual,
+	}
+}
+
+// IsRetryableError is true for errors that may go away on their own, i.e.
+// anything but a replay miss. An open circuit is never retried, whatever the
+// policy's RetryError says.
+func IsRetryableError(err error) bool {
+	var replayErr *ReplayError
+	return !errors.As(err, &replayErr)
+}
+
+// Validate reports every problem with the policy
+func (p RetryPolicy) Validate() []error {
+	var problems []error
+	if p.MaxAttempts < 1 {
+		problems = append(
+			problems,
+			fmt.Errorf("retry max attempts must be at least 1"),
+		)
+	}
+	if p.MaxElapsed < 0 {
+		problems = append(
+			problems,
+			fmt.Errorf("retry max elapsed time can't be negative"),
+		)
+	}
+	if p.BaseDelay < 0 || p.MaxDelay < 0 {
+		problems = append(problems, fmt.Errorf("retry delays can't be negative"))
+	}
+	switch p.Jitter {
+	case JitterNone, JitterFull, JitterEqual, "":
+	default:
+		problems = append(problems, fmt.Errorf(
+			"invalid retry jitter %q, must be none, full or equal",
+			p.Jitter,
+		))
+	}
+	return problems
+}
+
+// StatusList is a flag.Value of comma separated HTTP status codes, or none
+type StatusList []int
+
+func (l *StatusList) String() string {
+	if len(*l) == 0 {
+		return "none"
+	}
+	statuses := make([]string, len(*l))
+	for i, status := range *l {
+		statuses[i] = strconv.Itoa(status)
+	}
+	return strings.Join(statuses, ",")
+}
+
+// Set replaces the statuses
+func (l *StatusList) Set(list string) error {
+	*l = StatusList{}
+	list = strings.TrimSpace(strings.ToLower(list))
+	if list == "" || list == "none" {
+		return nil
+	}
+	for _, name := range strings.Split(list, ",") {
+		status, err := strconv.Atoi(strings.TrimSpace(name))
+		if err != nil || status < 100 || status > 599 {
+			return fmt.Errorf("invalid HTTP status %q", name)
+		}
+		*l = append(*l, status)
+	}
+	return nil
+}
+
+// Backoff is the wait before the given retry, counting from 1
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay == 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	switch p.Jitter {
+	case JitterFull:
+		return time.Duration(rand.Int63n(int64(delay) + 1))
+	case JitterEqual:
+		return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	default:
+		return delay
+	}
+}
+
+// capRetryAfter limits the wait a server asks for to MaxDelay and to what is
+// left of MaxElapsed, so a server can't stall a run for as long as it likes
+func (p RetryPolicy) capRetryAfter(
+	retryAfter time.Duration,
+	elapsed time.Duration,
+) time.Duration {
+	delay := retryAfter
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.MaxElapsed > 0 && delay > p.MaxElapsed-elapsed {
+		delay = p.MaxElapsed - elapsed
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+func (p RetryPolicy) retryStatus(status int) bool {
+	for _, s := range p.RetryStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) retryMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPatch:
+		return p.RetryNonIdempotent
+	default:
+		return true
+	}
+}
+
+// RetryRoundTripper retries failed requests according to a RetryPolicy
+type RetryRoundTripper struct {
+	next    http.RoundTripper
+	policy  RetryPolicy
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewRetryRoundTripper(
+	next http.RoundTripper,
+	policy RetryPolicy,
+	logger *zap.Logger,
+	m *metrics.Metrics,
+) *RetryRoundTripper {
+	return &RetryRoundTripper{
+		next:    next,
+		policy:  policy,
+		logger:  logger,
+		metrics: m,
+	}
+}
+
+func (rt *RetryRoundTripper) RoundTrip(
+	req *http.Request,
+) (*http.Response, error) {
+	ctx := req.Context()
+	begin := time.Now()
+	endpoint := EndpointTemplate(req.URL.Path)
+	// A request body can only be sent again if it can be rewound
+	rewindable := req.Body == nil || req.Body == http.NoBody ||
+		req.GetBody != nil
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := rt.next.RoundTrip(attemptReq)
+		if !rt.shouldRetry(ctx, req, resp, err) {
+			return resp, err
+		}
+
+		fields := []zap.Field{
+			zap.String("method", req.Method),
+			zap.String("url", req.URL.String()),
+			zap.Int("attempt", attempt),
+		}
+		if resp != nil {
+			fields = append(fields, zap.Int("status_code", resp.StatusCode))
+		}
+		if err != nil {
+			fields = append(fields, zap.Error(err))
+		}
+
+		spent := time.Since(begin)
+		delay := rt.policy.Backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header); ok {
+				delay = rt.policy.capRetryAfter(retryAfter, spent)
+				fields = append(fields, zap.Duration("retry_after", retryAfter))
+			}
+		}
+		elapsed := spent + delay
+		outOfTime := rt.policy.MaxElapsed > 0 && elapsed > rt.policy.MaxElapsed
+		if attempt >= rt.policy.MaxAttempts || outOfTime || !rewindable {
+			rt.logger.Error("Giving up on request", fields...)
+			return resp, err
+		}
+
+		fields = append(fields, zap.Duration("delay", delay))
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			// Being rate limited is routine, and nothing is wrong
+			rt.logger.Info("Rate limited, retrying request", fields...)
+		} else {
+			rt.logger.Warn("Retrying request", fields...)
+		}
+		rt.metrics.ObserveRetry(endpoint, req.Method)
+
+		if resp != nil {
+			// Drain the body so the connection can be reused
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return nil, sleepErr
+		}
+	}
+}
+
+func (rt *RetryRoundTripper) shouldRetry(
+	ctx context.Context,
+	req *http.Request,
+	resp *http.Response,
+	err error,
+) bool {
+	if ctx.Err() != nil || !rt.policy.retryMethod(req.Method) {
+		return false
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) ||
+			errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		// An open circuit fails fast, rather than waiting out the incident
+		var circuitErr *CircuitOpenError
+		if errors.As(err, &circuitErr) {
+			return false
+		}
+		return rt.policy.RetryError == nil || rt.policy.RetryError(err)
+	}
+	return rt.policy.retryStatus(resp.StatusCode)
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		delay := time.Until(at)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// sleep waits for d, or returns early with the context's error if it is
+// cancelled first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
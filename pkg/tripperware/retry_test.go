@@ -0,0 +1,496 @@
+package tripperware
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		retry  int
+		want   time.Duration
+	}{
+		{
+			name:   "first retry waits the base delay",
+			policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute},
+			retry:  1,
+			want:   time.Second,
+		},
+		{
+			name:   "doubles for each retry",
+			policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute},
+			retry:  4,
+			want:   8 * time.Second,
+		},
+		{
+			name: "capped at the max delay",
+			policy: RetryPolicy{
+				BaseDelay: time.Second,
+				MaxDelay:  10 * time.Second,
+			},
+			retry: 5,
+			want:  10 * time.Second,
+		},
+		{
+			name: "capped long after the max delay is reached",
+			policy: RetryPolicy{
+				BaseDelay: time.Second,
+				MaxDelay:  10 * time.Second,
+			},
+			retry: 1000,
+			want:  10 * time.Second,
+		},
+		{
+			name:   "no max delay",
+			policy: RetryPolicy{BaseDelay: time.Second},
+			retry:  7,
+			want:   64 * time.Second,
+		},
+		{
+			name:   "no base delay",
+			policy: RetryPolicy{MaxDelay: time.Minute},
+			retry:  3,
+			want:   0,
+		},
+		{
+			name: "no jitter",
+			policy: RetryPolicy{
+				BaseDelay: time.Second,
+				MaxDelay:  time.Minute,
+				Jitter:    JitterNone,
+			},
+			retry: 2,
+			want:  2 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Backoff(tt.retry); got != tt.want {
+				t.Errorf("Backoff(%d) = %v, want %v", tt.retry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	tests := []struct {
+		jitter   Jitter
+		min, max time.Duration
+	}{
+		{jitter: JitterFull, min: 0, max: 4 * time.Second},
+		{jitter: JitterEqual, min: 2 * time.Second, max: 4 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.jitter), func(t *testing.T) {
+			policy := RetryPolicy{
+				BaseDelay: time.Second,
+				MaxDelay:  time.Minute,
+				Jitter:    tt.jitter,
+			}
+			for i := 0; i < 100; i++ {
+				got := policy.Backoff(3)
+				if got < tt.min || got > tt.max {
+					t.Fatalf(
+						"Backoff(3) = %v, want from %v to %v",
+						got,
+						tt.min,
+						tt.max,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *RetryPolicy)
+		want   int
+	}{
+		{name: "default", modify: func(p *RetryPolicy) {}, want: 0},
+		{
+			name:   "no attempts",
+			modify: func(p *RetryPolicy) { p.MaxAttempts = 0 },
+			want:   1,
+		},
+		{
+			name:   "negative elapsed",
+			modify: func(p *RetryPolicy) { p.MaxElapsed = -time.Second },
+			want:   1,
+		},
+		{
+			name:   "negative delay",
+			modify: func(p *RetryPolicy) { p.MaxDelay = -time.Second },
+			want:   1,
+		},
+		{
+			name:   "unknown jitter",
+			modify: func(p *RetryPolicy) { p.Jitter = "some" },
+			want:   1,
+		},
+		{
+			name: "every problem at once",
+			modify: func(p *RetryPolicy) {
+				p.MaxAttempts = 0
+				p.MaxElapsed = -time.Second
+				p.BaseDelay = -time.Second
+				p.Jitter = "some"
+			},
+			want: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := DefaultRetryPolicy()
+			tt.modify(&policy)
+			if got := policy.Validate(); len(got) != tt.want {
+				t.Errorf("Validate() = %v, want %d problems", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusList(t *testing.T) {
+	tests := []struct {
+		set     string
+		want    string
+		wantErr bool
+	}{
+		{set: "429,503", want: "429,503"},
+		{set: " 500 , 502 ", want: "500,502"},
+		{set: "none", want: "none"},
+		{set: "", want: "none"},
+		{set: "NONE", want: "none"},
+		{set: "99", wantErr: true},
+		{set: "600", wantErr: true},
+		{set: "429,teapot", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.set, func(t *testing.T) {
+			l := StatusList{http.StatusTeapot}
+			err := l.Set(tt.set)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf(
+					"Set(%q) error = %v, want error %v",
+					tt.set,
+					err,
+					tt.wantErr,
+				)
+			}
+			if err == nil && l.String() != tt.want {
+				t.Errorf("Set(%q) = %s, want %s", tt.set, l.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "missing", value: ""},
+		{name: "seconds", value: "120", want: 2 * time.Minute, wantOK: true},
+		{name: "zero", value: "0", want: 0, wantOK: true},
+		{name: "negative", value: "-1"},
+		{name: "garbage", value: "soon"},
+		{
+			name:   "date in the past",
+			value:  "Wed, 21 Oct 2015 07:28:00 GMT",
+			want:   0,
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+			got, ok := parseRetryAfter(header)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf(
+					"parseRetryAfter(%q) = %v, %v, want %v, %v",
+					tt.value,
+					got,
+					ok,
+					tt.want,
+					tt.wantOK,
+				)
+			}
+		})
+	}
+}
+
+func TestCapRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		retryAfter time.Duration
+		elapsed    time.Duration
+		want       time.Duration
+	}{
+		{
+			name:       "no limits",
+			retryAfter: time.Hour,
+			want:       time.Hour,
+		},
+		{
+			name:       "under the limits",
+			policy:     RetryPolicy{MaxDelay: time.Minute, MaxElapsed: time.Hour},
+			retryAfter: 30 * time.Second,
+			want:       30 * time.Second,
+		},
+		{
+			name:       "capped at max delay",
+			policy:     RetryPolicy{MaxDelay: time.Minute},
+			retryAfter: time.Hour,
+			want:       time.Minute,
+		},
+		{
+			name:       "capped at the time left",
+			policy:     RetryPolicy{MaxElapsed: 5 * time.Minute},
+			retryAfter: time.Hour,
+			elapsed:    4 * time.Minute,
+			want:       time.Minute,
+		},
+		{
+			name: "time left is below max delay",
+			policy: RetryPolicy{
+				MaxDelay:   time.Minute,
+				MaxElapsed: 5 * time.Minute,
+			},
+			retryAfter: time.Hour,
+			elapsed:    290 * time.Second,
+			want:       10 * time.Second,
+		},
+		{
+			name:       "out of time",
+			policy:     RetryPolicy{MaxElapsed: 5 * time.Minute},
+			retryAfter: time.Second,
+			elapsed:    6 * time.Minute,
+			want:       0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.capRetryAfter(tt.retryAfter, tt.elapsed)
+			if got != tt.want {
+				t.Errorf(
+					"capRetryAfter(%v, %v) = %v, want %v",
+					tt.retryAfter,
+					tt.elapsed,
+					got,
+					tt.want,
+				)
+			}
+		})
+	}
+}
+
+// attempt is what a fake server does for one attempt: answer with status and
+// any Retry-After header, or fail with err
+type attempt struct {
+	status     int
+	retryAfter string
+	err        error
+}
+
+// fakeAttempts answers each request with the next attempt, repeating the last
+// one once they run out, and counts the requests
+func fakeAttempts(attempts []attempt, sent *int) roundTripperFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		a := attempts[len(attempts)-1]
+		if *sent < len(attempts) {
+			a = attempts[*sent]
+		}
+		*sent++
+		if a.err != nil {
+			return nil, a.err
+		}
+		header := http.Header{}
+		if a.retryAfter != "" {
+			header.Set("Retry-After", a.retryAfter)
+		}
+		return &http.Response{
+			StatusCode: a.status,
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+}
+
+func TestRetryRoundTripper(t *testing.T) {
+	networkErr := errors.New("connection reset by peer")
+	policy := RetryPolicy{
+		MaxAttempts:   3,
+		RetryStatuses: []int{http.StatusTooManyRequests, http.StatusBadGateway},
+		RetryError:    IsRetryableError,
+		BaseDelay:     time.Millisecond,
+		MaxDelay:      time.Millisecond,
+		Jitter:        JitterNone,
+	}
+	tests := []struct {
+		name       string
+		method     string
+		modify     func(p *RetryPolicy)
+		attempts   []attempt
+		wantSent   int
+		wantStatus int
+		wantErr    error
+	}{
+		{
+			name:       "success is not retried",
+			attempts:   []attempt{{status: 200}},
+			wantSent:   1,
+			wantStatus: 200,
+		},
+		{
+			name:       "retried status then success",
+			attempts:   []attempt{{status: 429}, {status: 502}, {status: 200}},
+			wantSent:   3,
+			wantStatus: 200,
+		},
+		{
+			name:       "status not in the policy",
+			attempts:   []attempt{{status: 500}},
+			wantSent:   1,
+			wantStatus: 500,
+		},
+		{
+			name:       "gives up after max attempts",
+			attempts:   []attempt{{status: 502}},
+			wantSent:   3,
+			wantStatus: 502,
+		},
+		{
+			name:       "network error then success",
+			attempts:   []attempt{{err: networkErr}, {status: 200}},
+			wantSent:   2,
+			wantStatus: 200,
+		},
+		{
+			name: "network errors not retried",
+			modify: func(p *RetryPolicy) {
+				p.RetryError = func(error) bool { return false }
+			},
+			attempts: []attempt{{err: networkErr}},
+			wantSent: 1,
+			wantErr:  networkErr,
+		},
+		{
+			name:     "replay miss is not retried",
+			attempts: []attempt{{err: &ReplayError{}}},
+			wantSent: 1,
+			wantErr:  &ReplayError{},
+		},
+		{
+			name:     "cancelled is not retried",
+			attempts: []attempt{{err: context.Canceled}},
+			wantSent: 1,
+			wantErr:  context.Canceled,
+		},
+		{
+			name:       "post is not retried",
+			method:     http.MethodPost,
+			attempts:   []attempt{{status: 502}, {status: 200}},
+			wantSent:   1,
+			wantStatus: 502,
+		},
+		{
+			name:       "post is retried if asked for",
+			method:     http.MethodPost,
+			modify:     func(p *RetryPolicy) { p.RetryNonIdempotent = true },
+			attempts:   []attempt{{status: 502}, {status: 200}},
+			wantSent:   2,
+			wantStatus: 200,
+		},
+		{
+			name: "retry-after capped at max delay",
+			attempts: []attempt{
+				{status: 429, retryAfter: "3600"},
+				{status: 200},
+			},
+			wantSent:   2,
+			wantStatus: 200,
+		},
+		{
+			name: "retry-after capped at the time left",
+			modify: func(p *RetryPolicy) {
+				p.MaxDelay = 0
+				p.MaxElapsed = 50 * time.Millisecond
+			},
+			attempts: []attempt{
+				{status: 429, retryAfter: "3600"},
+				{status: 200},
+			},
+			wantSent:   2,
+			wantStatus: 200,
+		},
+		{
+			name:       "out of time",
+			modify:     func(p *RetryPolicy) { p.MaxElapsed = time.Nanosecond },
+			attempts:   []attempt{{status: 502}, {status: 200}},
+			wantSent:   1,
+			wantStatus: 502,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := policy
+			if tt.modify != nil {
+				tt.modify(&p)
+			}
+			sent := 0
+			rt := NewRetryRoundTripper(
+				fakeAttempts(tt.attempts, &sent),
+				p,
+				zap.NewNop(),
+				nil,
+			)
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, _ := http.NewRequest(
+				method,
+				"https://api.clever.com/v2.1/students",
+				nil,
+			)
+
+			start := time.Now()
+			resp, err := rt.RoundTrip(req)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %v, want no long waits", elapsed)
+			}
+			if sent != tt.wantSent {
+				t.Errorf("sent %d requests, want %d", sent, tt.wantSent)
+			}
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}